@@ -1,71 +1,177 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/aceagles/etherum_parser/pkg/abi"
+	"github.com/aceagles/etherum_parser/pkg/api"
+	"github.com/aceagles/etherum_parser/pkg/auth"
+	"github.com/aceagles/etherum_parser/pkg/chains"
 	"github.com/aceagles/etherum_parser/pkg/eth_observer"
+	fileregistry "github.com/aceagles/etherum_parser/pkg/file_registry"
 	memorystore "github.com/aceagles/etherum_parser/pkg/memory_store"
+	"github.com/aceagles/etherum_parser/pkg/tokens"
+	"github.com/aceagles/etherum_parser/pkg/webhook"
 )
 
 func main() {
-	slog.SetLogLoggerLevel(slog.LevelWarn)
+	subscriptionsPath := flag.String("subscriptions", "subscriptions.json", "file used to persist subscriptions")
+	keysPath := flag.String("keys", "keys.json", "file holding the hashed API keys")
+	outboxPath := flag.String("outbox", "outbox.json", "file used to persist pending and dead webhook deliveries")
+	abisPath := flag.String("abis", "", "directory of contract ABI JSON files used to decode matched transactions, with the ABIs of the contracts of each chain under <dir>/<chainId>")
+	tokensPath := flag.String("tokens", "", "JSON file of known ERC-20 tokens, other tokens are read from their contract")
+	chainsPath := flag.String("chains", "", "JSON file of the chains to observe, mainnet with the files above by default")
+	mempool := flag.String("mempool", "", "follow the pending transactions of mainnet: a websocket URL of the client, or txpool to poll txpool_content")
+	allowPrivateWebhooks := flag.Bool("webhook-allow-private", false, "allow webhooks to loopback, link-local and private addresses")
+	socketOrigins := flag.String("ws-origins", "", "comma separated origins of the web pages allowed to open websockets besides the API's own, * for any")
+	hashKey := flag.String("hash-key", "", "print the hash of an API key for the keys file and exit")
+	flag.Parse()
 
-	// Create a memory store to hold transactions
-	memoryStore := memorystore.NewMemStore()
+	if *hashKey != "" {
+		fmt.Println(auth.HashKey(*hashKey))
+		return
+	}
 
-	// Create an observer to watch the ethereum chain
-	ethObserver := eth_observer.NewEthereumObserver("https://cloudflare-eth.com", memoryStore)
-	go ethObserver.ObserveChain() // Start observing the chain
+	slog.SetLogLoggerLevel(slog.LevelWarn)
 
-	// Define rest api for interfacing with the observer
-	// in practice the observer would be passed to a notification handler using the Parser interface
-	http.HandleFunc("/getLatestBlock", func(w http.ResponseWriter, r *http.Request) {
-		latestBlock := struct {
-			LatestBlock int `json:"latestBlock"`
-		}{
-			LatestBlock: ethObserver.GetCurrentBlock(),
-		}
-		err := json.NewEncoder(w).Encode(latestBlock)
+	keys, err := auth.LoadKeys(*keysPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Observe mainnet unless a file of chains is given
+	configs := []chains.Config{{
+		Name:          "mainnet",
+		ChainId:       chains.Mainnet,
+		RPC:           "https://cloudflare-eth.com",
+		Subscriptions: *subscriptionsPath,
+		Outbox:        *outboxPath,
+		Tokens:        *tokensPath,
+	}}
+	switch *mempool {
+	case "":
+	case "txpool":
+		configs[0].Mempool = &chains.Mempool{}
+	default:
+		configs[0].Mempool = &chains.Mempool{WebSocket: *mempool}
+	}
+	if *chainsPath != "" {
+		configs, err = chains.Load(*chainsPath)
 		if err != nil {
-			http.Error(w, "Error encoding response", http.StatusInternalServerError)
+			log.Fatal(err)
 		}
-	})
+	} else if err := chains.Validate(configs); err != nil {
+		log.Fatal(err)
+	}
 
-	http.HandleFunc("/getTransactions", func(w http.ResponseWriter, r *http.Request) {
-		transactionsResponse := struct {
-			Transactions []eth_observer.Transaction `json:"transactions"`
-		}{
-			Transactions: ethObserver.GetTransactions(r.URL.Query().Get("address")),
-		}
-		err := json.NewEncoder(w).Encode(transactionsResponse)
+	// Create a memory store to hold transactions, in which each chain keeps its records under its chain ID
+	memoryStore := memorystore.NewMemStore()
+
+	var served []api.Chain
+	for _, config := range configs {
+		chain, err := newChain(config, memoryStore.ForChain(config.ChainId), *abisPath)
 		if err != nil {
-			http.Error(w, "Error encoding response", http.StatusInternalServerError)
+			log.Fatalf("chain %s: %v", config.Name, err)
 		}
+		chain.Webhooks.AllowPrivateNetworks = *allowPrivateWebhooks
+		served = append(served, chain)
+	}
+	ctx := context.Background()
+	for i, chain := range served {
+		go chain.Webhooks.Run(ctx)
+		go chain.Observer.ObserveChain() // Start observing the chain
+		if mempool := configs[i].Mempool; mempool != nil {
+			go watchMempool(ctx, configs[i].Name, chain.Observer, *mempool)
+		}
+	}
+
+	// Serve the rest api for interfacing with the observers
+	// in practice the observer would be passed to a notification handler using the Parser interface
+	server, err := api.NewChainServer(auth.NewAuthenticator(keys), served...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *socketOrigins != "" {
+		server.AllowedOrigins = strings.Split(*socketOrigins, ",")
+	}
+	log.Fatal(http.ListenAndServe(":8081", server))
+
+}
+
+// watchMempool follows the pending transactions of a chain until the context is cancelled
+func watchMempool(ctx context.Context, name string, observer *eth_observer.EthereumObserver, mempool chains.Mempool) {
+	err := observer.WatchMempool(ctx, eth_observer.MempoolOptions{
+		WebSocketURL: mempool.WebSocket,
+		PollInterval: time.Duration(mempool.PollInterval),
+		DropAfter:    time.Duration(mempool.DropAfter),
 	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("chain %s: mempool: %v", name, err)
+	}
+}
 
-	http.HandleFunc("/subscribe", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-			return
-		}
-		decoder := json.NewDecoder(r.Body)
-		var t struct {
-			Address string `json:"address"`
+// chainStore is the store of the records of one chain
+type chainStore interface {
+	eth_observer.TransactionsStore
+	eth_observer.WithdrawalsStore
+}
+
+// newChain creates the observer of a chain after checking the chain ID of its endpoint, restores its
+// subscriptions and wires its store, broker and webhook dispatcher. contract calls and logs are decoded with the ABIs
+// of the directory abisPath and of its subdirectory named after the chain ID, when abisPath is set
+func newChain(config chains.Config, store chainStore, abisPath string) (api.Chain, error) {
+	// Wrap the store with a broker which streams the matched transactions to api clients
+	broker := api.NewBroker(store)
+
+	// Create an observer to watch the chain, refusing an endpoint serving another chain
+	ethObserver := eth_observer.NewEthereumObserver(config.RPC, broker)
+	ethObserver.UseStack(config.Stack)
+	if err := ethObserver.VerifyChain(config.ChainId); err != nil {
+		return api.Chain{}, err
+	}
+	ethObserver.UseWithdrawalsStore(store)
+
+	// Restore subscriptions from previous runs
+	registry, err := fileregistry.NewFileRegistry(config.Subscriptions)
+	if err != nil {
+		return api.Chain{}, err
+	}
+	if err := ethObserver.UseRegistry(registry); err != nil {
+		return api.Chain{}, err
+	}
+
+	if abisPath != "" {
+		abis, err := abi.LoadChainRegistry(abisPath, config.ChainId)
+		if err != nil {
+			return api.Chain{}, err
 		}
-		err := decoder.Decode(&t)
+		ethObserver.UseABIs(abis)
+	}
+
+	// Format token balances with the symbols and decimals of known tokens
+	if config.Tokens != "" {
+		registry, err := tokens.LoadRegistry(config.Tokens)
 		if err != nil {
-			fmt.Fprintf(w, "Error decoding request: %v", err)
-			return
+			return api.Chain{}, err
 		}
-		ethObserver.Subscribe(strings.ToLower(t.Address))
-		fmt.Fprintf(w, "Subscribed to address: %s", t.Address)
-	})
+		ethObserver.UseTokens(registry)
+	}
 
-	log.Fatal(http.ListenAndServe(":8081", nil))
+	// Deliver matched transactions to the webhooks of their subscriptions
+	outbox, err := webhook.NewOutbox(config.Outbox)
+	if err != nil {
+		return api.Chain{}, err
+	}
+	dispatcher := webhook.NewDispatcher(ethObserver, outbox)
+	broker.OnTransactions(dispatcher.Notify)
 
+	return api.Chain{Name: config.Name, Observer: ethObserver, Broker: broker, Webhooks: dispatcher}, nil
 }
@@ -0,0 +1,243 @@
+// Package abi decodes contract calls and event logs with the contract ABI JSON produced by solidity
+package abi
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aceagles/etherum_parser/pkg/keccak"
+)
+
+// ErrUnknown is returned when no ABI knows the selector of a call or the topic of a log
+var ErrUnknown = errors.New("abi: unknown selector")
+
+// Argument is an input of a function or event as written in the ABI JSON
+type Argument struct {
+	Name       string     `json:"name"`
+	Type       string     `json:"type"`
+	Indexed    bool       `json:"indexed,omitempty"`
+	Components []Argument `json:"components,omitempty"`
+}
+
+// entry is an item of the ABI JSON. constructors, errors, fallback and receive entries are ignored
+type entry struct {
+	Type      string     `json:"type"`
+	Name      string     `json:"name"`
+	Inputs    []Argument `json:"inputs"`
+	Anonymous bool       `json:"anonymous"`
+}
+
+// Method is a contract function
+type Method struct {
+	Name      string
+	Inputs    []Argument
+	Signature string
+	Selector  string // 0x prefixed 4-byte selector
+	types     []Type
+}
+
+// Event is a contract event
+type Event struct {
+	Name      string
+	Inputs    []Argument
+	Signature string
+	Topic     string // 0x prefixed hash of the signature, the first topic of its logs
+	types     []Type
+}
+
+// ABI holds the methods and events of a contract keyed by selector and topic
+type ABI struct {
+	Methods map[string]Method
+	Events  map[string]Event
+}
+
+// Parse parses the ABI JSON of a contract. the JSON may be the ABI array itself
+// or a build artifact holding it under "abi", as written by hardhat and truffle
+func Parse(data []byte) (*ABI, error) {
+	var entries []entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		var artifact struct {
+			ABI []entry `json:"abi"`
+		}
+		if artifactErr := json.Unmarshal(data, &artifact); artifactErr != nil || artifact.ABI == nil {
+			return nil, fmt.Errorf("abi: %w", err)
+		}
+		entries = artifact.ABI
+	}
+
+	abi := &ABI{Methods: make(map[string]Method), Events: make(map[string]Event)}
+	for _, entry := range entries {
+		if entry.Type != "function" && entry.Type != "event" && entry.Type != "" {
+			continue
+		}
+		types, err := parseArguments(entry.Inputs)
+		if err != nil {
+			return nil, fmt.Errorf("abi: %s: %w", entry.Name, err)
+		}
+		signature := Signature(entry.Name, types)
+		hash := keccak.Sum256([]byte(signature))
+		switch entry.Type {
+		case "event":
+			// anonymous events have no topic to find them by
+			if entry.Anonymous {
+				continue
+			}
+			topic := "0x" + hex.EncodeToString(hash[:])
+			abi.Events[topic] = Event{Name: entry.Name, Inputs: entry.Inputs, Signature: signature, Topic: topic, types: types}
+		default:
+			// the type defaults to function in the ABI specification
+			selector := "0x" + hex.EncodeToString(hash[:4])
+			abi.Methods[selector] = Method{Name: entry.Name, Inputs: entry.Inputs, Signature: signature, Selector: selector, types: types}
+		}
+	}
+	return abi, nil
+}
+
+// Signature returns the canonical signature of a function or event, e.g. transfer(address,uint256)
+func Signature(name string, types []Type) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.String()
+	}
+	return name + "(" + strings.Join(names, ",") + ")"
+}
+
+// Kind is the kind of an ABI type
+type Kind int
+
+const (
+	KindUint Kind = iota
+	KindInt
+	KindAddress
+	KindBool
+	KindFixedBytes
+	KindBytes
+	KindString
+	KindSlice
+	KindArray
+	KindTuple
+)
+
+// Type is a parsed ABI type
+type Type struct {
+	Kind Kind
+	// Size is the bit size of integers and the byte size of fixed bytes
+	Size int
+	// Elem is the element type of slices and arrays, Length the length of arrays
+	Elem   *Type
+	Length int
+	// Components are the types of the fields of a tuple, Names their names
+	Components []Type
+	Names      []string
+}
+
+// String returns the canonical name of the type
+func (t Type) String() string {
+	switch t.Kind {
+	case KindUint:
+		return "uint" + strconv.Itoa(t.Size)
+	case KindInt:
+		return "int" + strconv.Itoa(t.Size)
+	case KindAddress:
+		return "address"
+	case KindBool:
+		return "bool"
+	case KindFixedBytes:
+		return "bytes" + strconv.Itoa(t.Size)
+	case KindBytes:
+		return "bytes"
+	case KindString:
+		return "string"
+	case KindSlice:
+		return t.Elem.String() + "[]"
+	case KindArray:
+		return t.Elem.String() + "[" + strconv.Itoa(t.Length) + "]"
+	default:
+		names := make([]string, len(t.Components))
+		for i, component := range t.Components {
+			names[i] = component.String()
+		}
+		return "(" + strings.Join(names, ",") + ")"
+	}
+}
+
+// parseArguments parses the types of the arguments
+func parseArguments(arguments []Argument) ([]Type, error) {
+	types := make([]Type, len(arguments))
+	for i, argument := range arguments {
+		t, err := ParseType(argument.Type, argument.Components)
+		if err != nil {
+			return nil, err
+		}
+		types[i] = t
+	}
+	return types, nil
+}
+
+// ParseType parses an ABI type. components describe the fields of tuple types
+func ParseType(name string, components []Argument) (Type, error) {
+	// array suffixes apply to everything before them, so the last one is the outermost
+	if strings.HasSuffix(name, "]") {
+		open := strings.LastIndex(name, "[")
+		if open < 0 {
+			return Type{}, fmt.Errorf("invalid type %q", name)
+		}
+		elem, err := ParseType(name[:open], components)
+		if err != nil {
+			return Type{}, err
+		}
+		if name[open+1:len(name)-1] == "" {
+			return Type{Kind: KindSlice, Elem: &elem}, nil
+		}
+		length, err := strconv.Atoi(name[open+1 : len(name)-1])
+		if err != nil || length <= 0 {
+			return Type{}, fmt.Errorf("invalid array length in %q", name)
+		}
+		return Type{Kind: KindArray, Elem: &elem, Length: length}, nil
+	}
+
+	switch {
+	case name == "tuple":
+		t := Type{Kind: KindTuple}
+		for _, component := range components {
+			componentType, err := ParseType(component.Type, component.Components)
+			if err != nil {
+				return Type{}, err
+			}
+			t.Components = append(t.Components, componentType)
+			t.Names = append(t.Names, component.Name)
+		}
+		return t, nil
+	case name == "address":
+		return Type{Kind: KindAddress, Size: 160}, nil
+	case name == "bool":
+		return Type{Kind: KindBool}, nil
+	case name == "string":
+		return Type{Kind: KindString}, nil
+	case name == "bytes":
+		return Type{Kind: KindBytes}, nil
+	case name == "uint" || name == "int":
+		return ParseType(name+"256", nil)
+	case strings.HasPrefix(name, "uint"), strings.HasPrefix(name, "int"):
+		kind, digits := KindUint, strings.TrimPrefix(name, "uint")
+		if !strings.HasPrefix(name, "uint") {
+			kind, digits = KindInt, strings.TrimPrefix(name, "int")
+		}
+		size, err := strconv.Atoi(digits)
+		if err != nil || size <= 0 || size > 256 || size%8 != 0 {
+			return Type{}, fmt.Errorf("invalid integer type %q", name)
+		}
+		return Type{Kind: kind, Size: size}, nil
+	case strings.HasPrefix(name, "bytes"):
+		size, err := strconv.Atoi(strings.TrimPrefix(name, "bytes"))
+		if err != nil || size <= 0 || size > 32 {
+			return Type{}, fmt.Errorf("invalid fixed bytes type %q", name)
+		}
+		return Type{Kind: KindFixedBytes, Size: size}, nil
+	}
+	return Type{}, fmt.Errorf("unsupported type %q", name)
+}
@@ -0,0 +1,178 @@
+package abi
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/aceagles/etherum_parser/pkg/keccak"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	other    = "0x00000000000000000000000000000000000000bb"
+	contract = "0x00000000000000000000000000000000000000cc"
+)
+
+// words encodes each value as a 32 byte word: integers are left padded and strings right padded hex
+func words(values ...any) string {
+	var encoded strings.Builder
+	for _, value := range values {
+		switch value := value.(type) {
+		case int:
+			encoded.WriteString(fmt.Sprintf("%064x", value))
+		case string:
+			encoded.WriteString(value + strings.Repeat("0", 64-len(value)))
+		}
+	}
+	return encoded.String()
+}
+
+func topic(signature string) string {
+	hash := keccak.Sum256([]byte(signature))
+	return "0x" + hex.EncodeToString(hash[:])
+}
+
+func TestParseType(t *testing.T) {
+	for name, want := range map[string]string{
+		"uint":          "uint256",
+		"int8":          "int8",
+		"address[]":     "address[]",
+		"bytes32[2][]":  "bytes32[2][]",
+		"string":        "string",
+		"uint7":         "",
+		"bytes33":       "",
+		"address[0]":    "",
+		"function":      "",
+		"tuple[]":       "(uint8,bytes)[]",
+		"tuple":         "(uint8,bytes)",
+		"uint256[2][3]": "uint256[2][3]",
+		"int256[][]":    "int256[][]",
+		"bool":          "bool",
+		"bytes":         "bytes",
+		"int":           "int256",
+		"address":       "address",
+		"bytes1":        "bytes1",
+		"uint256[]":     "uint256[]",
+		"uint256[2":     "",
+	} {
+		parsed, err := ParseType(name, []Argument{{Type: "uint8"}, {Type: "bytes"}})
+		if want == "" {
+			assert.Error(t, err, name)
+			continue
+		}
+		assert.NoError(t, err, name)
+		assert.Equal(t, want, parsed.String(), name)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	registry, err := LoadRegistry("testdata")
+	require.NoError(t, err)
+
+	t.Run("Call by selector", func(t *testing.T) {
+		call, err := registry.DecodeCall("0x00000000000000000000000000000000000000dd", "0xa9059cbb"+words(0xbb, 1000))
+		require.NoError(t, err)
+		assert.Equal(t, "transfer", call.Method)
+		assert.Equal(t, "transfer(address,uint256)", call.Signature)
+		assert.Equal(t, []Value{{Name: "to", Type: "address", Value: other}, {Name: "amount", Type: "uint256", Value: "1000"}}, call.Args)
+	})
+
+	t.Run("Contract ABI first", func(t *testing.T) {
+		abi := registry.contracts[contract]
+		var selector string
+		for s, method := range abi.Methods {
+			if method.Name == "transfer" {
+				selector = s
+			}
+		}
+		minusTwo := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(2)).Text(16)
+		call, err := registry.DecodeCall(strings.ToUpper(contract[:2])+contract[2:], selector+words(minusTwo, 1, 0))
+		require.NoError(t, err)
+		assert.Equal(t, "transfer(int256,bool[2])", call.Signature)
+		assert.Equal(t, "-2", call.Args[0].Value)
+		assert.Equal(t, []any{true, false}, call.Args[1].Value)
+	})
+
+	t.Run("Dynamic arguments", func(t *testing.T) {
+		selector := topic("register(string,uint256[],(uint8,bytes))")[:10]
+		input := selector + words(0x60, 0xa0, 0x100, 2, "6869", 2, 1, 2, 7, 0x40, 2, "dead")
+		call, err := registry.DecodeCall(contract, input)
+		require.NoError(t, err)
+		assert.Equal(t, []Value{
+			{Name: "name", Type: "string", Value: "hi"},
+			{Name: "ids", Type: "uint256[]", Value: []any{"1", "2"}},
+			{Name: "info", Type: "(uint8,bytes)", Value: []Value{{Name: "level", Type: "uint8", Value: "7"}, {Name: "data", Type: "bytes", Value: "0xdead"}}},
+		}, call.Args)
+
+		// every offset and length is checked against the data
+		for _, truncated := range []string{input[:len(input)-64], selector + words(0x60, 0xa0, 0x100, 2, "6869", 99), selector + words(0x1000)} {
+			_, err := registry.DecodeCall(contract, truncated)
+			assert.Error(t, err)
+			assert.NotErrorIs(t, err, ErrUnknown)
+		}
+	})
+
+	t.Run("Unknown selector", func(t *testing.T) {
+		_, err := registry.DecodeCall(contract, "0x12345678")
+		assert.ErrorIs(t, err, ErrUnknown)
+		_, err = registry.DecodeCall(contract, "0x")
+		assert.ErrorIs(t, err, ErrUnknown)
+		_, err = registry.DecodeCall(contract, "nothex")
+		assert.Error(t, err)
+	})
+
+	t.Run("Event log", func(t *testing.T) {
+		event, err := registry.DecodeLog(contract, []string{topic("Transfer(address,address,uint256)"), "0x" + words(0xaa), "0x" + words(0xbb)}, "0x"+words(5))
+		require.NoError(t, err)
+		assert.Equal(t, "Transfer", event.Event)
+		assert.Equal(t, []Value{
+			{Name: "from", Type: "address", Value: "0x00000000000000000000000000000000000000aa"},
+			{Name: "to", Type: "address", Value: other},
+			{Name: "value", Type: "uint256", Value: "5"},
+		}, event.Args)
+
+		_, err = registry.DecodeLog(contract, []string{topic("Transfer(address,address,uint256)"), "0x" + words(0xaa)}, "0x"+words(5))
+		assert.Error(t, err, "missing topic")
+		_, err = registry.DecodeLog(contract, []string{topic("Approval(address,address,uint256)")}, "0x")
+		assert.ErrorIs(t, err, ErrUnknown)
+	})
+
+	t.Run("Indexed dynamic argument", func(t *testing.T) {
+		nameHash := topic("alice")
+		event, err := registry.DecodeLog(contract, []string{topic("Registered(string,bytes32,string)"), nameHash, "0x" + words(0x11)}, "0x"+words(0x20, 3, "6f6b21"))
+		require.NoError(t, err)
+		assert.Equal(t, nameHash, event.Args[0].Value)
+		assert.Equal(t, "0x"+words(0x11), event.Args[1].Value)
+		assert.Equal(t, "ok!", event.Args[2].Value)
+	})
+}
+
+func TestLoadChainRegistry(t *testing.T) {
+	// testdata/10 holds the ABI of the contract on chain 10
+	registry, err := LoadChainRegistry("testdata", 10)
+	require.NoError(t, err)
+	assert.Contains(t, registry.contracts, contract)
+
+	// the contract files of the directory itself are not tied to an address on any chain
+	registry, err = LoadChainRegistry("testdata", 1)
+	require.NoError(t, err)
+	assert.Empty(t, registry.contracts)
+	call, err := registry.DecodeCall(contract, "0xa9059cbb"+words(0xbb, 1000))
+	require.NoError(t, err)
+	assert.Equal(t, "transfer(address,uint256)", call.Signature, "shared files are searched by selector")
+}
+
+func TestParse_invalid(t *testing.T) {
+	_, err := Parse([]byte(`{"abi": "nope"}`))
+	assert.Error(t, err)
+	_, err = Parse([]byte(`[{"type": "function", "name": "f", "inputs": [{"name": "x", "type": "uint3"}]}]`))
+	assert.Error(t, err)
+	abi, err := Parse([]byte(`[{"type": "event", "name": "E", "anonymous": true, "inputs": []}, {"type": "error", "name": "Err", "inputs": []}]`))
+	assert.NoError(t, err)
+	assert.Empty(t, abi.Events)
+	assert.Empty(t, abi.Methods)
+}
@@ -0,0 +1,265 @@
+package abi
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Value is a decoded argument. integers are decimal strings so they survive JSON intact, addresses and
+// bytes are 0x prefixed hex, arrays are []any and tuples []Value
+type Value struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value any    `json:"value"`
+}
+
+// Call is a decoded contract call
+type Call struct {
+	Method    string  `json:"method"`
+	Signature string  `json:"signature"`
+	Args      []Value `json:"args"`
+}
+
+// DecodedEvent is a decoded event log
+type DecodedEvent struct {
+	Event     string  `json:"event"`
+	Signature string  `json:"signature"`
+	Args      []Value `json:"args"`
+}
+
+// errShort is returned when the data ends before a value
+var errShort = errors.New("abi: data too short")
+
+// DecodeCall decodes the call data of a transaction to the method
+func (m Method) DecodeCall(input []byte) (*Call, error) {
+	if len(input) < 4 || "0x"+hex.EncodeToString(input[:4]) != m.Selector {
+		return nil, fmt.Errorf("abi: call data does not start with %s", m.Selector)
+	}
+	values, err := decodeTuple(m.types, input[4:])
+	if err != nil {
+		return nil, fmt.Errorf("abi: %s: %w", m.Signature, err)
+	}
+	return &Call{Method: m.Name, Signature: m.Signature, Args: named(m.Inputs, m.types, values)}, nil
+}
+
+// DecodeLog decodes a log of the event from its topics and data. indexed arguments of dynamic
+// types are only stored as their hash in the topics, so their value is the 0x prefixed hash
+func (e Event) DecodeLog(topics [][]byte, data []byte) (*DecodedEvent, error) {
+	if len(topics) == 0 || "0x"+hex.EncodeToString(topics[0]) != e.Topic {
+		return nil, fmt.Errorf("abi: log is not a %s event", e.Signature)
+	}
+
+	var dataTypes []Type
+	for i, input := range e.Inputs {
+		if !input.Indexed {
+			dataTypes = append(dataTypes, e.types[i])
+		}
+	}
+	dataValues, err := decodeTuple(dataTypes, data)
+	if err != nil {
+		return nil, fmt.Errorf("abi: %s: %w", e.Signature, err)
+	}
+
+	values := make([]any, len(e.Inputs))
+	topic, dataIndex := 1, 0
+	for i, input := range e.Inputs {
+		if !input.Indexed {
+			values[i] = dataValues[dataIndex]
+			dataIndex++
+			continue
+		}
+		if topic >= len(topics) {
+			return nil, fmt.Errorf("abi: %s: missing topic for %s", e.Signature, input.Name)
+		}
+		if isDynamic(e.types[i]) || e.types[i].Kind == KindTuple || e.types[i].Kind == KindArray {
+			values[i] = "0x" + hex.EncodeToString(topics[topic])
+		} else if values[i], err = decodeValue(e.types[i], topics[topic], 0); err != nil {
+			return nil, fmt.Errorf("abi: %s: %w", e.Signature, err)
+		}
+		topic++
+	}
+	return &DecodedEvent{Event: e.Name, Signature: e.Signature, Args: named(e.Inputs, e.types, values)}, nil
+}
+
+// Decode decodes values of the types encoded one after another, such as the return data of a call
+func Decode(types []Type, data []byte) ([]any, error) {
+	values, err := decodeTuple(types, data)
+	if err != nil {
+		return nil, fmt.Errorf("abi: %w", err)
+	}
+	return values, nil
+}
+
+// named pairs decoded values with the names and types of the arguments
+func named(arguments []Argument, types []Type, values []any) []Value {
+	named := make([]Value, len(values))
+	for i, value := range values {
+		named[i] = Value{Name: arguments[i].Name, Type: types[i].String(), Value: value}
+	}
+	return named
+}
+
+// isDynamic reports whether the type is encoded out of place with an offset in the head
+func isDynamic(t Type) bool {
+	switch t.Kind {
+	case KindBytes, KindString, KindSlice:
+		return true
+	case KindArray:
+		return isDynamic(*t.Elem)
+	case KindTuple:
+		for _, component := range t.Components {
+			if isDynamic(component) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headSize returns the number of bytes the type takes in the head of its enclosing tuple
+func headSize(t Type) int {
+	if isDynamic(t) {
+		return 32
+	}
+	switch t.Kind {
+	case KindArray:
+		return t.Length * headSize(*t.Elem)
+	case KindTuple:
+		size := 0
+		for _, component := range t.Components {
+			size += headSize(component)
+		}
+		return size
+	}
+	return 32
+}
+
+// decodeTuple decodes values encoded one after another as the fields of a tuple
+func decodeTuple(types []Type, data []byte) ([]any, error) {
+	values := make([]any, len(types))
+	offset := 0
+	for i, t := range types {
+		var err error
+		if isDynamic(t) {
+			var start int
+			start, err = readOffset(data, offset)
+			if err == nil {
+				values[i], err = decodeValue(t, data, start)
+			}
+		} else {
+			values[i], err = decodeValue(t, data, offset)
+		}
+		if err != nil {
+			return nil, err
+		}
+		offset += headSize(t)
+	}
+	return values, nil
+}
+
+// decodeValue decodes a value of the type starting at the offset of the data
+func decodeValue(t Type, data []byte, offset int) (any, error) {
+	switch t.Kind {
+	case KindTuple:
+		if offset > len(data) {
+			return nil, errShort
+		}
+		fields, err := decodeTuple(t.Components, data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		values := make([]Value, len(fields))
+		for i, field := range fields {
+			values[i] = Value{Name: t.Names[i], Type: t.Components[i].String(), Value: field}
+		}
+		return values, nil
+	case KindSlice, KindArray:
+		length := t.Length
+		if t.Kind == KindSlice {
+			var err error
+			if length, err = readOffset(data, offset); err != nil {
+				return nil, err
+			}
+			offset += 32
+		}
+		if offset > len(data) || length > (len(data)-offset)/32 {
+			return nil, errShort
+		}
+		elems := make([]Type, length)
+		for i := range elems {
+			elems[i] = *t.Elem
+		}
+		values, err := decodeTuple(elems, data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		return values, nil
+	case KindBytes, KindString:
+		length, err := readOffset(data, offset)
+		if err != nil {
+			return nil, err
+		}
+		if length > len(data)-offset-32 {
+			return nil, errShort
+		}
+		content := data[offset+32 : offset+32+length]
+		if t.Kind == KindString {
+			return string(content), nil
+		}
+		return "0x" + hex.EncodeToString(content), nil
+	}
+
+	word, err := readWord(data, offset)
+	if err != nil {
+		return nil, err
+	}
+	switch t.Kind {
+	case KindUint:
+		return new(big.Int).SetBytes(word).String(), nil
+	case KindInt:
+		value := new(big.Int).SetBytes(word)
+		if word[0]&0x80 != 0 {
+			value.Sub(value, new(big.Int).Lsh(big.NewInt(1), 256))
+		}
+		return value.String(), nil
+	case KindAddress:
+		return "0x" + hex.EncodeToString(word[12:]), nil
+	case KindBool:
+		return word[31] == 1, nil
+	case KindFixedBytes:
+		return "0x" + hex.EncodeToString(word[:t.Size]), nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+// readWord returns the 32 byte word at the offset
+func readWord(data []byte, offset int) ([]byte, error) {
+	if offset < 0 || offset+32 > len(data) {
+		return nil, errShort
+	}
+	return data[offset : offset+32], nil
+}
+
+// readOffset reads a word holding an offset or length, which must fit in the data
+func readOffset(data []byte, offset int) (int, error) {
+	word, err := readWord(data, offset)
+	if err != nil {
+		return 0, err
+	}
+	value := new(big.Int).SetBytes(word)
+	if !value.IsInt64() || value.Int64() > int64(len(data)) {
+		return 0, errShort
+	}
+	return int(value.Int64()), nil
+}
+
+// decodeHex decodes a 0x prefixed hex string
+func decodeHex(value string) ([]byte, error) {
+	if !strings.HasPrefix(value, "0x") && !strings.HasPrefix(value, "0X") {
+		return nil, fmt.Errorf("abi: %q is not 0x prefixed hex", value)
+	}
+	return hex.DecodeString(value[2:])
+}
@@ -0,0 +1,159 @@
+package abi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// contractFilePattern matches the ABI files named after the address of their contract
+var contractFilePattern = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+
+// Registry finds the ABI to decode calls and logs with. ABIs of a contract address are used first,
+// then the methods and events of every ABI loaded are looked up by selector and topic
+type Registry struct {
+	contracts map[string]*ABI
+	methods   map[string]Method
+	events    map[string]Event
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{contracts: make(map[string]*ABI), methods: make(map[string]Method), events: make(map[string]Event)}
+}
+
+// LoadRegistry loads every .json file in the directory. a file named after a contract address,
+// e.g. 0xdac17f958d2ee523a2206206994597c13d831ec7.json, holds the ABI of that contract
+func LoadRegistry(dir string) (*Registry, error) {
+	registry := NewRegistry()
+	if err := registry.load(dir, true); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// LoadChainRegistry loads the ABIs of one chain: the .json files of the subdirectory named after its chain ID,
+// e.g. abis/1/, as LoadRegistry does, and those of the directory itself, which are shared by every chain. a contract
+// address names different contracts on different chains, so the shared files are only searched by selector and topic
+func LoadChainRegistry(dir string, chainId uint64) (*Registry, error) {
+	registry := NewRegistry()
+	if err := registry.load(dir, false); err != nil {
+		return nil, err
+	}
+	if err := registry.load(filepath.Join(dir, strconv.FormatUint(chainId, 10)), true); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// load adds every .json file in the directory, a missing directory holding none. files named after a contract
+// address are added as the ABI of that contract when contracts is set
+func (r *Registry) load(dir string, contracts bool) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		abi, err := Parse(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		if contracts && contractFilePattern.MatchString(name) {
+			r.AddContract(name, abi)
+		} else {
+			r.Add(abi)
+		}
+	}
+	return nil
+}
+
+// Add adds the methods and events of an ABI to the selectors known for every contract
+func (r *Registry) Add(abi *ABI) {
+	for selector, method := range abi.Methods {
+		r.methods[selector] = method
+	}
+	for topic, event := range abi.Events {
+		r.events[topic] = event
+	}
+}
+
+// AddContract adds the ABI of the contract at an address, which is also searched for other contracts
+func (r *Registry) AddContract(address string, abi *ABI) {
+	r.contracts[strings.ToLower(address)] = abi
+	r.Add(abi)
+}
+
+// DecodeCall decodes the hex call data of a transaction to the contract at an address.
+// it returns ErrUnknown if no ABI holds the selector
+func (r *Registry) DecodeCall(to string, input string) (*Call, error) {
+	data, err := decodeHex(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, ErrUnknown
+	}
+	selector := strings.ToLower(input[:10])
+	method, ok := r.contracts[strings.ToLower(to)].method(selector)
+	if !ok {
+		if method, ok = r.methods[selector]; !ok {
+			return nil, ErrUnknown
+		}
+	}
+	return method.DecodeCall(data)
+}
+
+// DecodeLog decodes a log emitted by the contract at an address from its hex topics and data.
+// it returns ErrUnknown if no ABI holds the event of the first topic
+func (r *Registry) DecodeLog(address string, topics []string, data string) (*DecodedEvent, error) {
+	if len(topics) == 0 {
+		return nil, ErrUnknown
+	}
+	topic := strings.ToLower(topics[0])
+	event, ok := r.contracts[strings.ToLower(address)].event(topic)
+	if !ok {
+		if event, ok = r.events[topic]; !ok {
+			return nil, ErrUnknown
+		}
+	}
+
+	rawTopics := make([][]byte, len(topics))
+	for i, topic := range topics {
+		raw, err := decodeHex(topic)
+		if err != nil {
+			return nil, err
+		}
+		rawTopics[i] = raw
+	}
+	rawData, err := decodeHex(data)
+	if err != nil {
+		return nil, err
+	}
+	return event.DecodeLog(rawTopics, rawData)
+}
+
+// method returns the method with the selector, it is safe to call on a nil ABI
+func (a *ABI) method(selector string) (Method, bool) {
+	if a == nil {
+		return Method{}, false
+	}
+	method, ok := a.Methods[selector]
+	return method, ok
+}
+
+// event returns the event with the topic, it is safe to call on a nil ABI
+func (a *ABI) event(topic string) (Event, bool) {
+	if a == nil {
+		return Event{}, false
+	}
+	event, ok := a.Events[topic]
+	return event, ok
+}
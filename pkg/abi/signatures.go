@@ -0,0 +1,76 @@
+package abi
+
+import (
+	_ "embed"
+	"encoding/hex"
+	"slices"
+	"strings"
+
+	"github.com/aceagles/etherum_parser/pkg/keccak"
+)
+
+// builtinSignatures is the curated table of common function and event signatures
+//
+//go:embed signatures.txt
+var builtinSignatures string
+
+// Guess is the signature found in the built-in table for a selector or topic
+type Guess struct {
+	Signature string `json:"signature"`
+	// Ambiguous is set when several signatures share the selector. Candidates then lists them, best guess first
+	Ambiguous  bool     `json:"ambiguous,omitempty"`
+	Candidates []string `json:"candidates,omitempty"`
+}
+
+// builtinMethods and builtinEvents hold the signatures of the table by 0x prefixed selector and topic
+var builtinMethods, builtinEvents = loadSignatures(builtinSignatures)
+
+// loadSignatures parses the table, a "function" or "event" keyword and a signature per line
+func loadSignatures(table string) (methods, events map[string][]string) {
+	methods, events = make(map[string][]string), make(map[string][]string)
+	for _, line := range strings.Split(table, "\n") {
+		kind, signature, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok || strings.HasPrefix(kind, "#") {
+			continue
+		}
+		hash := keccak.Sum256([]byte(signature))
+		switch kind {
+		case "function":
+			selector := "0x" + hex.EncodeToString(hash[:4])
+			if !slices.Contains(methods[selector], signature) {
+				methods[selector] = append(methods[selector], signature)
+			}
+		case "event":
+			topic := "0x" + hex.EncodeToString(hash[:])
+			if !slices.Contains(events[topic], signature) {
+				events[topic] = append(events[topic], signature)
+			}
+		}
+	}
+	return methods, events
+}
+
+// GuessMethod returns the signature of the method called with the hex call data, or nil if the
+// table does not know its selector
+func GuessMethod(input string) *Guess {
+	if len(input) < 10 {
+		return nil
+	}
+	return guess(builtinMethods[strings.ToLower(input[:10])])
+}
+
+// GuessEvent returns the signature of the event with the hex topic, or nil if the table does not know it
+func GuessEvent(topic string) *Guess {
+	return guess(builtinEvents[strings.ToLower(topic)])
+}
+
+// guess returns the best of the signatures found
+func guess(signatures []string) *Guess {
+	switch len(signatures) {
+	case 0:
+		return nil
+	case 1:
+		return &Guess{Signature: signatures[0]}
+	}
+	return &Guess{Signature: signatures[0], Ambiguous: true, Candidates: slices.Clone(signatures)}
+}
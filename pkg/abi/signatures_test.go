@@ -0,0 +1,45 @@
+package abi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuessMethod(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  *Guess
+	}{
+		{name: "ERC-20 transfer", input: "0xa9059cbb" + words(0xbb, 5), want: &Guess{Signature: "transfer(address,uint256)"}},
+		{name: "Uppercase selector", input: "0xA9059CBB", want: &Guess{Signature: "transfer(address,uint256)"}},
+		{name: "WETH deposit", input: "0xd0e30db0", want: &Guess{Signature: "deposit()"}},
+		{name: "Collision", input: "0x42966c68" + words(1), want: &Guess{
+			Signature:  "burn(uint256)",
+			Ambiguous:  true,
+			Candidates: []string{"burn(uint256)", "collate_propagate_storage(bytes16)"},
+		}},
+		{name: "Unknown", input: "0x12345678"},
+		{name: "Plain transfer", input: "0x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, GuessMethod(tt.input))
+		})
+	}
+}
+
+func TestGuessEvent(t *testing.T) {
+	assert.Equal(t, &Guess{Signature: "Transfer(address,address,uint256)"}, GuessEvent("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"))
+	assert.Equal(t, &Guess{Signature: "Swap(address,address,int256,int256,uint160,uint128,int24)"}, GuessEvent(topic("Swap(address,address,int256,int256,uint160,uint128,int24)")))
+	assert.Nil(t, GuessEvent(topic("Unknown()")))
+}
+
+func Test_loadSignatures(t *testing.T) {
+	methods, events := loadSignatures("# comment\n\nfunction f()\nfunction f()\n  event E(uint256)  \nbogus\n")
+	assert.Len(t, methods, 1)
+	assert.Equal(t, []string{"f()"}, methods[topic("f()")[:10]])
+	assert.Equal(t, []string{"E(uint256)"}, events[topic("E(uint256)")])
+
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aceagles/etherum_parser/pkg/auth"
+	"github.com/aceagles/etherum_parser/pkg/eth_observer"
+	"github.com/aceagles/etherum_parser/pkg/webhook"
+)
+
+// openAPISpec is the OpenAPI 3 document describing the API, served at /openapi.json
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// Server is the REST API of the observer. every endpoint is authenticated by API key
+// and reads and writes the subscriptions of the tenant the key belongs to on the chain of the request
+type Server struct {
+	// AllowedOrigins are the origins, e.g. https://app.example.com, of the web pages allowed to open websockets
+	// besides pages served from the API's own host. "*" allows every origin
+	AllowedOrigins []string
+
+	chains    []*Chain
+	auth      *auth.Authenticator
+	mux       *http.ServeMux
+	patterns  []string
+	heartbeat time.Duration
+}
+
+// NewServer creates a new Server for the observer. the broker must be the transaction store of the observer
+// so matched transactions can be streamed. webhooks may be nil if webhook delivery is disabled.
+// rejected requests are answered with the API error envelope
+func NewServer(observer *eth_observer.EthereumObserver, authenticator *auth.Authenticator, broker *Broker, webhooks *webhook.Dispatcher) *Server {
+	return newServer(authenticator, []*Chain{{Observer: observer, Broker: broker, Webhooks: webhooks}})
+}
+
+// NewChainServer creates a new Server for several chains. requests choose a chain with the chain parameter,
+// the first chain serves requests without one. it returns an error if no chain is given or two share a name
+func NewChainServer(authenticator *auth.Authenticator, chains ...Chain) (*Server, error) {
+	if len(chains) == 0 {
+		return nil, errors.New("no chains to serve")
+	}
+	served := make([]*Chain, 0, len(chains))
+	names := make(map[string]bool)
+	for _, chain := range chains {
+		if chain.Name != "" && names[chain.Name] {
+			return nil, fmt.Errorf("duplicate chain name %q", chain.Name)
+		}
+		names[chain.Name] = true
+		chain := chain
+		served = append(served, &chain)
+	}
+	return newServer(authenticator, served), nil
+}
+
+// newServer creates a Server for chains already checked, the first serving requests without a chain parameter
+func newServer(authenticator *auth.Authenticator, chains []*Chain) *Server {
+	s := &Server{chains: chains, auth: authenticator, mux: http.NewServeMux(), heartbeat: defaultHeartbeat}
+	s.routes()
+	return s
+}
+
+// routes registers the endpoints of the API
+func (s *Server) routes() {
+	s.handle("GET /chains", auth.ScopeRead, s.handleChains)
+	s.handle("GET /blocks/latest", auth.ScopeRead, s.handleLatestBlock)
+	s.handle("GET /metrics", auth.ScopeRead, s.handleMetrics)
+	s.handle("GET /transactions", auth.ScopeRead, s.handleTransactions)
+	s.handle("GET /addresses/{address}/transactions", auth.ScopeRead, s.handleAddressTransactions)
+	s.handle("GET /addresses/{address}/withdrawals", auth.ScopeRead, s.handleAddressWithdrawals)
+	s.handle("GET /addresses/{address}/fees", auth.ScopeRead, s.handleAddressFees)
+	s.handle("GET /addresses/{address}/balance", auth.ScopeRead, s.handleBalance)
+	s.handle("GET /addresses/{address}/tokens", auth.ScopeRead, s.handleTokenBalances)
+	s.handle("GET /addresses/{address}/nonces", auth.ScopeRead, s.handleNonceStatus)
+	s.handle("GET /subscriptions", auth.ScopeRead, s.handleListSubscriptions)
+	s.handle("POST /subscriptions", auth.ScopeSubscribe, s.handleCreateSubscription)
+	s.handle("GET /subscriptions/{address}", auth.ScopeRead, s.handleGetSubscription)
+	s.handle("GET /stream", auth.ScopeRead, s.handleStream)
+	s.handle("GET /ws", auth.ScopeRead, s.handleWebSocket)
+	s.handle("GET /webhooks/dead-letters", auth.ScopeSubscribe, s.handleDeadLetters)
+	s.handle("POST /webhooks/dead-letters/{id}/replay", auth.ScopeSubscribe, s.handleReplayDeadLetter)
+	s.handle("DELETE /webhooks/dead-letters/{id}", auth.ScopeSubscribe, s.handlePurgeDeadLetter)
+
+	// endpoints kept for clients of the original API
+	s.handle("GET /getLatestBlock", auth.ScopeRead, s.handleLatestBlock)
+	s.handle("GET /getTransactions", auth.ScopeRead, s.handleLegacyTransactions)
+	s.handle("POST /subscribe", auth.ScopeSubscribe, s.handleCreateSubscription)
+
+	s.patterns = append(s.patterns, "GET /openapi.json")
+	s.mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(openAPISpec)
+	})
+}
+
+// handle registers a handler requiring the scope for the pattern, which is served for the chain of the request
+func (s *Server) handle(pattern string, scope auth.Scope, handler http.HandlerFunc) {
+	s.patterns = append(s.patterns, pattern)
+	s.mux.Handle(pattern, s.auth.RequireWith(scope, writeError, s.withChain(handler)))
+}
+
+// ServeHTTP assigns the request an ID and routes it to its handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" || len(requestID) > 128 {
+		requestID = newRequestID()
+	}
+	w.Header().Set("X-Request-ID", requestID)
+	r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, requestID))
+	s.mux.ServeHTTP(&errorInterceptor{ResponseWriter: w, r: r}, r)
+}
+
+type requestIDKey struct{}
+
+// RequestID returns the ID assigned to the request
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// newRequestID returns a random 128 bit hex request ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aceagles/etherum_parser/pkg/auth"
+	"github.com/aceagles/etherum_parser/pkg/eth_observer"
+	memorystore "github.com/aceagles/etherum_parser/pkg/memory_store"
+	"github.com/aceagles/etherum_parser/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testAddress  = "0x00000000000000000000000000000000000000aa"
+	otherAddress = "0x00000000000000000000000000000000000000bb"
+)
+
+// newTestServer returns a server with keys "acme-key" (read, subscribe, max two subscriptions), "acme-read" (read only),
+// "acme-ops" (subscribe, max one subscription) and "globex-key" (read, subscribe). acme is subscribed to testAddress
+func newTestServer(t *testing.T) *httptest.Server {
+	ts, _ := newTestServerWithBroker(t)
+	return ts
+}
+
+func newTestServerWithBroker(t *testing.T) (*httptest.Server, *Broker) {
+	env := newTestEnv(t)
+	return env.ts, env.broker
+}
+
+// testEnv is a test server along with the components wired into it
+type testEnv struct {
+	ts         *httptest.Server
+	server     *Server
+	broker     *Broker
+	dispatcher *webhook.Dispatcher
+}
+
+// newTestNode returns a fake ethereum client answering the methods used by the API handlers
+func newTestNode(t *testing.T) *httptest.Server {
+	results := map[string]string{
+		"eth_getTransactionCount latest":  `"0x3"`,
+		"eth_getTransactionCount pending": `"0x5"`,
+		"eth_getBalance 0x0":              `"0xde0b6b3a7640000"`,
+	}
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req eth_observer.EthRequestStruct
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		key := req.Method
+		if len(req.Params) > 1 {
+			key += " " + fmt.Sprint(req.Params[len(req.Params)-1])
+		}
+		result, ok := results[key]
+		if !ok {
+			json.NewEncoder(w).Encode(eth_observer.EthResponseStruct{Jsonrpc: "2.0", Error: &eth_observer.EthErrorStruct{Code: -32601, Message: "method not found"}})
+			return
+		}
+		json.NewEncoder(w).Encode(eth_observer.EthResponseStruct{Jsonrpc: "2.0", Result: []byte(result)})
+	}))
+	t.Cleanup(node.Close)
+	return node
+}
+
+func newTestEnv(t *testing.T) testEnv {
+	store := memorystore.NewMemStore()
+	store.AddWithdrawals(testAddress, []eth_observer.Withdrawal{{Index: "0x1", ValidatorIndex: "0x2", Address: testAddress, Amount: "0x3", BlockNumber: "0x1", BlockHash: "0xb1"}})
+	broker := NewBroker(store)
+	broker.AddTransactions(testAddress, []eth_observer.Transaction{{Hash: "0x1", From: testAddress, BlockNumber: "0x1", Nonce: "0x0"}})
+	observer := eth_observer.NewEthereumObserver(newTestNode(t).URL, broker)
+	observer.UseWithdrawalsStore(store)
+	observer.Tenant("acme").SubscribeWithMetadata(testAddress, eth_observer.SubscriptionMetadata{Label: "hot", Tags: []string{"exchange"}})
+
+	authenticator := auth.NewAuthenticator([]auth.Key{
+		{ID: "acme", Hash: auth.HashKey("acme-key"), Tenant: "acme", Scopes: []auth.Scope{auth.ScopeRead, auth.ScopeSubscribe}, MaxSubscriptions: 2},
+		{ID: "acme-read", Hash: auth.HashKey("acme-read"), Tenant: "acme", Scopes: []auth.Scope{auth.ScopeRead}},
+		{ID: "acme-ops", Hash: auth.HashKey("acme-ops"), Tenant: "acme", Scopes: []auth.Scope{auth.ScopeSubscribe}, MaxSubscriptions: 1},
+		{ID: "globex", Hash: auth.HashKey("globex-key"), Tenant: "globex", Scopes: []auth.Scope{auth.ScopeRead, auth.ScopeSubscribe}},
+	})
+	outbox, err := webhook.NewOutbox(filepath.Join(t.TempDir(), "outbox.json"))
+	assert.NoError(t, err)
+	dispatcher := webhook.NewDispatcher(observer, outbox)
+	dispatcher.AllowPrivateNetworks = true
+	broker.OnTransactions(dispatcher.Notify)
+	server := NewServer(observer, authenticator, broker, dispatcher)
+	server.heartbeat = 50 * time.Millisecond
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	return testEnv{ts: ts, server: server, broker: broker, dispatcher: dispatcher}
+}
+
+func do(t *testing.T, ts *httptest.Server, method, path, key, body string) (*http.Response, map[string]any) {
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	assert.NoError(t, err)
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	var decoded map[string]any
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&decoded))
+	return resp, decoded
+}
+
+func TestServer_routes(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		key        string
+		body       string
+		wantStatus int
+		wantCode   string
+		wantKey    string
+	}{
+		{name: "Latest block", method: http.MethodGet, path: "/blocks/latest", key: "acme-key", wantStatus: http.StatusOK, wantKey: "latestBlock"},
+		{name: "Chains", method: http.MethodGet, path: "/chains", key: "acme-read", wantStatus: http.StatusOK, wantKey: "chains"},
+		{name: "Unknown chain", method: http.MethodGet, path: "/blocks/latest?chain=goerli", key: "acme-key", wantStatus: http.StatusBadRequest, wantCode: "unknown_chain"},
+		{name: "Metrics", method: http.MethodGet, path: "/metrics", key: "acme-key", wantStatus: http.StatusOK, wantKey: "metrics"},
+		{name: "Legacy latest block", method: http.MethodGet, path: "/getLatestBlock", key: "acme-key", wantStatus: http.StatusOK, wantKey: "latestBlock"},
+		{name: "Unauthenticated", method: http.MethodGet, path: "/blocks/latest", wantStatus: http.StatusUnauthorized, wantCode: "unauthorized"},
+		{name: "Wrong method", method: http.MethodPost, path: "/blocks/latest", key: "acme-key", wantStatus: http.StatusMethodNotAllowed, wantCode: "method_not_allowed"},
+		{name: "Unknown path", method: http.MethodGet, path: "/nope", key: "acme-key", wantStatus: http.StatusNotFound, wantCode: "not_found"},
+		{name: "Address transactions", method: http.MethodGet, path: "/addresses/" + testAddress + "/transactions", key: "acme-key", wantStatus: http.StatusOK, wantKey: "transactions"},
+		{name: "Invalid address", method: http.MethodGet, path: "/addresses/0x12/transactions", key: "acme-key", wantStatus: http.StatusBadRequest, wantCode: "invalid_address"},
+		{name: "Other tenant cannot read", method: http.MethodGet, path: "/addresses/" + testAddress + "/transactions", key: "globex-key", wantStatus: http.StatusNotFound, wantCode: "not_subscribed"},
+		{name: "Legacy transactions empty address", method: http.MethodGet, path: "/getTransactions", key: "acme-key", wantStatus: http.StatusBadRequest, wantCode: "invalid_address"},
+		{name: "Legacy transactions wrong method", method: http.MethodDelete, path: "/getTransactions?address=" + testAddress, key: "acme-key", wantStatus: http.StatusMethodNotAllowed, wantCode: "method_not_allowed"},
+		{name: "Legacy transactions", method: http.MethodGet, path: "/getTransactions?address=" + testAddress, key: "acme-key", wantStatus: http.StatusOK, wantKey: "transactions"},
+		{name: "Transactions invalid format", method: http.MethodGet, path: "/addresses/" + testAddress + "/transactions?format=pretty", key: "acme-key", wantStatus: http.StatusBadRequest, wantCode: "invalid_format"},
+		{name: "Tenant transactions human", method: http.MethodGet, path: "/transactions?format=human", key: "acme-key", wantStatus: http.StatusOK, wantKey: "transactions"},
+		{name: "Tenant transactions", method: http.MethodGet, path: "/transactions?tag=exchange", key: "acme-key", wantStatus: http.StatusOK, wantKey: "transactions"},
+		{name: "Withdrawals", method: http.MethodGet, path: "/addresses/" + testAddress + "/withdrawals", key: "acme-read", wantStatus: http.StatusOK, wantKey: "withdrawals"},
+		{name: "Withdrawals not subscribed", method: http.MethodGet, path: "/addresses/" + otherAddress + "/withdrawals", key: "acme-key", wantStatus: http.StatusNotFound, wantCode: "not_subscribed"},
+		{name: "Fees", method: http.MethodGet, path: "/addresses/" + testAddress + "/fees?from=2024-01-01T00:00:00Z", key: "acme-read", wantStatus: http.StatusOK, wantKey: "fees"},
+		{name: "Fees invalid time", method: http.MethodGet, path: "/addresses/" + testAddress + "/fees?to=tomorrow", key: "acme-key", wantStatus: http.StatusBadRequest, wantCode: "invalid_time"},
+		{name: "Fees not subscribed", method: http.MethodGet, path: "/addresses/" + otherAddress + "/fees", key: "acme-key", wantStatus: http.StatusNotFound, wantCode: "not_subscribed"},
+		{name: "Balance", method: http.MethodGet, path: "/addresses/" + testAddress + "/balance", key: "acme-read", wantStatus: http.StatusOK, wantKey: "balance"},
+		{name: "Balance not subscribed", method: http.MethodGet, path: "/addresses/" + otherAddress + "/balance", key: "acme-key", wantStatus: http.StatusNotFound, wantCode: "not_subscribed"},
+		{name: "Tokens", method: http.MethodGet, path: "/addresses/" + testAddress + "/tokens?token=" + otherAddress, key: "acme-read", wantStatus: http.StatusOK, wantKey: "balances"},
+		{name: "Tokens invalid address", method: http.MethodGet, path: "/addresses/0x12/tokens", key: "acme-key", wantStatus: http.StatusBadRequest, wantCode: "invalid_address"},
+		{name: "Tokens invalid token", method: http.MethodGet, path: "/addresses/" + testAddress + "/tokens?token=0x1", key: "acme-key", wantStatus: http.StatusBadRequest, wantCode: "invalid_address"},
+		{name: "Tokens too many", method: http.MethodGet, path: "/addresses/" + testAddress + "/tokens?token=" + strings.Repeat(testAddress+"&token=", maxTokensPerRequest) + testAddress, key: "acme-key", wantStatus: http.StatusBadRequest, wantCode: "too_many_tokens"},
+		{name: "Tokens invalid block", method: http.MethodGet, path: "/addresses/" + testAddress + "/tokens?block=latest", key: "acme-key", wantStatus: http.StatusBadRequest, wantCode: "invalid_block"},
+		{name: "Tokens not subscribed", method: http.MethodGet, path: "/addresses/" + otherAddress + "/tokens", key: "acme-key", wantStatus: http.StatusNotFound, wantCode: "not_subscribed"},
+		{name: "Nonces", method: http.MethodGet, path: "/addresses/" + testAddress + "/nonces", key: "acme-read", wantStatus: http.StatusOK, wantKey: "nonces"},
+		{name: "Nonces not subscribed", method: http.MethodGet, path: "/addresses/" + otherAddress + "/nonces", key: "acme-key", wantStatus: http.StatusNotFound, wantCode: "not_subscribed"},
+		{name: "Subscription", method: http.MethodGet, path: "/subscriptions/" + testAddress, key: "acme-key", wantStatus: http.StatusOK, wantKey: "subscription"},
+		{name: "Subscriptions", method: http.MethodGet, path: "/subscriptions", key: "acme-key", wantStatus: http.StatusOK, wantKey: "subscriptions"},
+		{name: "Subscribe bad body", method: http.MethodPost, path: "/subscriptions", key: "acme-key", body: "{", wantStatus: http.StatusBadRequest, wantCode: "invalid_body"},
+		{name: "Subscribe invalid address", method: http.MethodPost, path: "/subscribe", key: "acme-key", body: `{"address":"nope"}`, wantStatus: http.StatusBadRequest, wantCode: "invalid_address"},
+		{name: "Subscribe missing prefix", method: http.MethodPost, path: "/subscriptions", key: "acme-key", body: `{"address":"` + strings.ToUpper(testAddress[2:]) + `"}`, wantStatus: http.StatusBadRequest, wantCode: "invalid_address"},
+		{name: "Subscribe invalid rules", method: http.MethodPost, path: "/subscriptions", key: "acme-key", body: `{"address":"` + otherAddress + `","rules":[{"name":"big","minValue":"lots"}]}`, wantStatus: http.StatusBadRequest, wantCode: "invalid_rules"},
+		{name: "Subscribe already subscribed", method: http.MethodPost, path: "/subscriptions", key: "acme-key", body: `{"address":"` + testAddress + `"}`, wantStatus: http.StatusConflict, wantCode: "already_subscribed"},
+		{name: "Subscribe without scope", method: http.MethodPost, path: "/subscriptions", key: "acme-read", body: `{"address":"` + otherAddress + `"}`, wantStatus: http.StatusForbidden, wantCode: "forbidden"},
+		{name: "Subscribe", method: http.MethodPost, path: "/subscriptions", key: "globex-key", body: `{"address":"` + testAddress + `","label":"cold","rules":[{"name":"big","minValue":"1000"}]}`, wantStatus: http.StatusCreated, wantKey: "subscription"},
+	}
+	ts := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := do(t, ts, tt.method, tt.path, tt.key, tt.body)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			assert.NotEmpty(t, resp.Header.Get("X-Request-ID"))
+			if tt.wantCode != "" {
+				envelope, ok := body["error"].(map[string]any)
+				assert.True(t, ok, "error envelope missing: %v", body)
+				assert.Equal(t, tt.wantCode, envelope["code"])
+				assert.Equal(t, resp.Header.Get("X-Request-ID"), envelope["requestId"])
+			}
+			if tt.wantKey != "" {
+				assert.Contains(t, body, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestServer_subscriptionQuota(t *testing.T) {
+	ts := newTestServer(t)
+	for _, address := range []string{otherAddress, "0x00000000000000000000000000000000000000cc"} {
+		resp, _ := do(t, ts, http.MethodPost, "/subscriptions", "acme-key", `{"address":"`+address+`"}`)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	resp, body := do(t, ts, http.MethodPost, "/subscriptions", "acme-key", `{"address":"0x00000000000000000000000000000000000000dd"}`)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "subscription_quota_exceeded", body["error"].(map[string]any)["code"])
+
+	// another key of the tenant has a quota of its own
+	resp, _ = do(t, ts, http.MethodPost, "/subscriptions", "acme-ops", `{"address":"0x00000000000000000000000000000000000000dd"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, _ = do(t, ts, http.MethodPost, "/subscriptions", "acme-ops", `{"address":"0x00000000000000000000000000000000000000ee"}`)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+}
+
+func TestServer_requestID(t *testing.T) {
+	ts := newTestServer(t)
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/blocks/latest", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "abc-123", resp.Header.Get("X-Request-ID"))
+}
+
+func TestNewServer_sharedAuthenticator(t *testing.T) {
+	// the server answers rejected requests with its own envelope without changing the authenticator for other handlers
+	authenticator := auth.NewAuthenticator(nil)
+	server := NewServer(eth_observer.NewEthereumObserver("", nil), authenticator, nil, nil)
+	tests := []struct {
+		name          string
+		handler       http.Handler
+		wantRequestID bool
+	}{
+		{name: "Server", handler: server, wantRequestID: true},
+		{name: "Other handler", handler: authenticator.Require(auth.ScopeRead, http.NotFoundHandler())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/blocks/latest", nil))
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+			var body map[string]map[string]any
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+			assert.Equal(t, tt.wantRequestID, body["error"]["requestId"] != nil)
+		})
+	}
+}
+
+func TestServer_transactionsAnnotated(t *testing.T) {
+	ts := newTestServer(t)
+	_, body := do(t, ts, http.MethodGet, "/addresses/"+testAddress+"/transactions", "acme-key", "")
+	transactions := body["transactions"].([]any)
+	assert.Len(t, transactions, 1)
+	subscription := transactions[0].(map[string]any)["subscription"].(map[string]any)
+	assert.Equal(t, "hot", subscription["label"])
+}
+
+func TestServer_transactionsHumanFormat(t *testing.T) {
+	env := newTestEnv(t)
+	env.broker.AddTransactions(testAddress, []eth_observer.Transaction{{
+		Hash: "0x2", From: testAddress, BlockNumber: "0x2", Value: "0x16345785d8a0000", GasPrice: "0x4c5e52d00",
+		EffectiveGasPrice: "0x4c5e52d00", Fee: "0x968578b5d800", BurntFee: "0x968578b5d800", PriorityFee: "0x0", BlobFee: "0x0",
+	}})
+
+	_, body := do(t, env.ts, http.MethodGet, "/addresses/"+testAddress+"/transactions?format=human", "acme-key", "")
+	transactions := body["transactions"].([]any)
+	require.Len(t, transactions, 2)
+	transaction := transactions[1].(map[string]any)
+	assert.Equal(t, "0x16345785d8a0000", transaction["value"])
+	assert.Equal(t, "0.1", transaction["valueEth"])
+	assert.Equal(t, "0.0001655", transaction["feeEth"])
+	assert.Equal(t, "0", transaction["priorityFeeEth"])
+	assert.Equal(t, "20.5", transaction["gasPriceGwei"])
+	assert.Equal(t, "hot", transaction["subscription"].(map[string]any)["label"])
+	assert.NotContains(t, transactions[0].(map[string]any), "valueEth", "missing amounts are not rendered")
+
+	_, body = do(t, env.ts, http.MethodGet, "/transactions?format=raw", "acme-key", "")
+	assert.NotContains(t, body["transactions"].([]any)[1].(map[string]any), "valueEth")
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"sync"
+
+	"github.com/aceagles/etherum_parser/pkg/eth_observer"
+)
+
+// defaultBufferSize is the number of events buffered for each streaming client
+const defaultBufferSize = 64
+
+// streamEvent is a matched transaction pushed to streaming clients. ID is the 1 based position
+// of the transaction in the store for its address, which lets clients resume from the store
+type streamEvent struct {
+	ID          int
+	Address     string
+	Transaction eth_observer.Transaction
+}
+
+// streamClient receives the events of the addresses it watches. its buffer is bounded: a client
+// which falls behind is dropped rather than stalling the observer and can resume from the store
+type streamClient struct {
+	events  chan streamEvent
+	dropped chan struct{}
+	once    sync.Once
+}
+
+// drop closes the client's dropped channel, telling its handler to end the stream
+func (c *streamClient) drop() {
+	c.once.Do(func() { close(c.dropped) })
+}
+
+// Broker wraps a transaction store and pushes every transaction added to it to the streaming
+// clients watching its address. it implements the TransactionsStore interface
+type Broker struct {
+	store      eth_observer.TransactionsStore
+	mux        sync.Mutex
+	clients    map[string]map[*streamClient]struct{}
+	listeners  []func(address string, transactions []eth_observer.Transaction)
+	bufferSize int
+}
+
+// NewBroker creates a new Broker storing transactions in store
+func NewBroker(store eth_observer.TransactionsStore) *Broker {
+	return &Broker{store: store, clients: make(map[string]map[*streamClient]struct{}), bufferSize: defaultBufferSize}
+}
+
+// OnTransactions registers a listener called with the transactions of every AddTransactions call
+// once they are stored. listeners are called on the observer's goroutine and should return quickly
+func (b *Broker) OnTransactions(listener func(address string, transactions []eth_observer.Transaction)) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.listeners = append(b.listeners, listener)
+}
+
+// AddTransactions adds transactions to the store, pushes them to the clients watching the address
+// and passes them to the listeners. clients whose buffer is full are dropped so a slow client never blocks the observer
+func (b *Broker) AddTransactions(address string, transactions []eth_observer.Transaction) {
+	listeners := b.push(address, transactions)
+	for _, listener := range listeners {
+		listener(address, transactions)
+	}
+}
+
+// push stores the transactions and pushes them to the streaming clients, it returns the listeners to call
+func (b *Broker) push(address string, transactions []eth_observer.Transaction) []func(string, []eth_observer.Transaction) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	first := len(b.store.GetTransactions(address)) + 1
+	b.store.AddTransactions(address, transactions)
+	for client := range b.clients[address] {
+		for i, transaction := range transactions {
+			select {
+			case client.events <- streamEvent{ID: first + i, Address: address, Transaction: transaction}:
+			default:
+				client.drop()
+			}
+		}
+	}
+	return b.listeners
+}
+
+// GetTransactions returns transactions for a given address
+func (b *Broker) GetTransactions(address string) []eth_observer.Transaction {
+	return b.store.GetTransactions(address)
+}
+
+// newClient creates a client with a bounded buffer which watches no addresses
+func (b *Broker) newClient() *streamClient {
+	return &streamClient{events: make(chan streamEvent, b.bufferSize), dropped: make(chan struct{})}
+}
+
+// watch starts pushing the events of the address to the client. it returns the transactions
+// stored for the address at that point, events for later transactions are pushed to the client
+func (b *Broker) watch(client *streamClient, address string) []eth_observer.Transaction {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	clients, ok := b.clients[address]
+	if !ok {
+		clients = make(map[*streamClient]struct{})
+		b.clients[address] = clients
+	}
+	clients[client] = struct{}{}
+	return b.store.GetTransactions(address)
+}
+
+// unwatch stops pushing the events of the address to the client
+func (b *Broker) unwatch(client *streamClient, address string) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	delete(b.clients[address], client)
+	if len(b.clients[address]) == 0 {
+		delete(b.clients, address)
+	}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/aceagles/etherum_parser/pkg/eth_observer"
+	"github.com/aceagles/etherum_parser/pkg/webhook"
+)
+
+// Chain is a network served by the API: the observer following it, the broker which is the observer's transaction
+// store and the dispatcher of its webhooks, which may be nil. Name is matched by the chain parameter of a request,
+// as is the chain ID once checked by the observer's VerifyChain
+type Chain struct {
+	Name     string
+	Observer *eth_observer.EthereumObserver
+	Broker   *Broker
+	Webhooks *webhook.Dispatcher
+}
+
+type chainResponse struct {
+	Name        string `json:"name,omitempty"`
+	ChainId     uint64 `json:"chainId,omitempty"`
+	LatestBlock int    `json:"latestBlock"`
+	Default     bool   `json:"default"`
+}
+
+type chainsResponse struct {
+	Chains []chainResponse `json:"chains"`
+}
+
+type chainKey struct{}
+
+// withChain passes the request on with the chain named by its chain parameter, or the first chain when it has none,
+// and answers 400 when the chain parameter matches no chain
+func (s *Server) withChain(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chain, ok := s.findChain(r.URL.Query().Get("chain"))
+		if !ok {
+			writeError(w, r, http.StatusBadRequest, "unknown_chain", fmt.Sprintf("%q is not the name or ID of a chain served", r.URL.Query().Get("chain")))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), chainKey{}, chain)))
+	})
+}
+
+// findChain returns the chain with the name or decimal chain ID given, or the first chain if it is empty
+func (s *Server) findChain(name string) (*Chain, bool) {
+	if name == "" {
+		return s.chains[0], true
+	}
+	id, err := strconv.ParseUint(name, 10, 64)
+	for _, chain := range s.chains {
+		if chain.Name == name || err == nil && chain.Observer.ChainId() == id {
+			return chain, true
+		}
+	}
+	return nil, false
+}
+
+// chain returns the chain the request was routed to
+func (s *Server) chain(r *http.Request) *Chain {
+	if chain, ok := r.Context().Value(chainKey{}).(*Chain); ok {
+		return chain
+	}
+	return s.chains[0]
+}
+
+// handleChains lists the chains served, the first one being used by requests without a chain parameter
+func (s *Server) handleChains(w http.ResponseWriter, r *http.Request) {
+	response := chainsResponse{Chains: []chainResponse{}}
+	for i, chain := range s.chains {
+		response.Chains = append(response.Chains, chainResponse{
+			Name:        chain.Name,
+			ChainId:     chain.Observer.ChainId(),
+			LatestBlock: chain.Observer.GetCurrentBlock(),
+			Default:     i == 0,
+		})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aceagles/etherum_parser/pkg/auth"
+	"github.com/aceagles/etherum_parser/pkg/eth_observer"
+	memorystore "github.com/aceagles/etherum_parser/pkg/memory_store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newChainNode returns a fake ethereum client serving the chain with the ID given
+func newChainNode(t *testing.T, chainId uint64) *httptest.Server {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(eth_observer.EthResponseStruct{Jsonrpc: "2.0", Result: []byte(fmt.Sprintf(`"0x%x"`, chainId))})
+	}))
+	t.Cleanup(node.Close)
+	return node
+}
+
+func TestServer_chains(t *testing.T) {
+	store := memorystore.NewMemStore()
+	var chains []Chain
+	for _, chain := range []struct {
+		name string
+		id   uint64
+	}{{"mainnet", 1}, {"base", 8453}} {
+		broker := NewBroker(store.ForChain(chain.id))
+		observer := eth_observer.NewEthereumObserver(newChainNode(t, chain.id).URL, broker)
+		require.NoError(t, observer.VerifyChain(chain.id))
+		chains = append(chains, Chain{Name: chain.name, Observer: observer, Broker: broker})
+	}
+	chains[0].Observer.Tenant("acme").Subscribe(testAddress)
+	chains[0].Broker.AddTransactions(testAddress, []eth_observer.Transaction{{Hash: "0x1", From: testAddress, BlockNumber: "0x1"}})
+
+	authenticator := auth.NewAuthenticator([]auth.Key{
+		{ID: "acme", Hash: auth.HashKey("acme-key"), Tenant: "acme", Scopes: []auth.Scope{auth.ScopeRead, auth.ScopeSubscribe}, MaxSubscriptions: 2},
+	})
+	server, err := NewChainServer(authenticator, chains...)
+	require.NoError(t, err)
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	resp, body := do(t, ts, http.MethodGet, "/chains", "acme-key", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []any{
+		map[string]any{"name": "mainnet", "chainId": float64(1), "latestBlock": float64(0), "default": true},
+		map[string]any{"name": "base", "chainId": float64(8453), "latestBlock": float64(0), "default": false},
+	}, body["chains"])
+
+	resp, body = do(t, ts, http.MethodGet, "/addresses/"+testAddress+"/transactions", "acme-key", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "requests without a chain go to the first chain")
+	assert.Len(t, body["transactions"], 1)
+	resp, _ = do(t, ts, http.MethodGet, "/addresses/"+testAddress+"/transactions?chain=base", "acme-key", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "subscriptions belong to one chain")
+
+	resp, _ = do(t, ts, http.MethodPost, "/subscriptions?chain=8453", "acme-key", `{"address":"`+testAddress+`"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "chains are also chosen by chain ID")
+	resp, body = do(t, ts, http.MethodGet, "/addresses/"+testAddress+"/transactions?chain=base", "acme-key", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, body["transactions"], "records of an address are kept apart per chain")
+
+	resp, _ = do(t, ts, http.MethodPost, "/subscriptions?chain=base", "acme-key", `{"address":"`+otherAddress+`"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, body = do(t, ts, http.MethodPost, "/subscriptions?chain=base", "acme-key", `{"address":"0x00000000000000000000000000000000000000cc"}`)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "subscription_quota_exceeded", body["error"].(map[string]any)["code"])
+	resp, _ = do(t, ts, http.MethodPost, "/subscriptions", "acme-key", `{"address":"`+otherAddress+`"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "each chain has a quota of its own")
+
+	_, err = NewChainServer(authenticator, chains[0], chains[0])
+	assert.Error(t, err)
+	_, err = NewChainServer(authenticator)
+	assert.Error(t, err)
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// errorBody is the envelope of every error returned by the API
+type errorBody struct {
+	Error struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		RequestID string `json:"requestId"`
+	} `json:"error"`
+}
+
+// writeError writes an error response in the API error envelope
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	var body errorBody
+	body.Error.Code = code
+	body.Error.Message = message
+	body.Error.RequestID = RequestID(r.Context())
+	writeJSON(w, status, body)
+}
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error encoding response", "error", err)
+	}
+}
+
+// errorInterceptor replaces the plain text 404 and 405 errors written by http.ServeMux
+// for unknown paths and known paths with the wrong method with the API error envelope
+type errorInterceptor struct {
+	http.ResponseWriter
+	r           *http.Request
+	intercepted bool
+}
+
+func (e *errorInterceptor) WriteHeader(status int) {
+	if strings.HasPrefix(e.Header().Get("Content-Type"), "text/plain") {
+		switch status {
+		case http.StatusNotFound:
+			e.intercept(status, "not_found", "no such endpoint")
+			return
+		case http.StatusMethodNotAllowed:
+			e.intercept(status, "method_not_allowed", "method not allowed, see the Allow header")
+			return
+		}
+	}
+	e.ResponseWriter.WriteHeader(status)
+}
+
+// intercept writes the error envelope and discards the plain text body which follows
+func (e *errorInterceptor) intercept(status int, code, message string) {
+	e.intercepted = true
+	e.Header().Del("X-Content-Type-Options")
+	writeError(e.ResponseWriter, e.r, status, code, message)
+}
+
+func (e *errorInterceptor) Write(b []byte) (int, error) {
+	if e.intercepted {
+		return len(b), nil
+	}
+	return e.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (e *errorInterceptor) Unwrap() http.ResponseWriter {
+	return e.ResponseWriter
+}
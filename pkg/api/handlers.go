@@ -0,0 +1,419 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aceagles/etherum_parser/pkg/auth"
+	"github.com/aceagles/etherum_parser/pkg/eth_observer"
+	"github.com/aceagles/etherum_parser/pkg/units"
+	"github.com/aceagles/etherum_parser/pkg/webhook"
+)
+
+// addressPattern matches a hex encoded 20 byte address in any case
+var addressPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+
+type latestBlockResponse struct {
+	LatestBlock int `json:"latestBlock"`
+}
+
+type metricsResponse struct {
+	Metrics eth_observer.Metrics `json:"metrics"`
+}
+
+type transactionsResponse struct {
+	Transactions []eth_observer.Transaction `json:"transactions"`
+}
+
+// humanTransaction is a transaction with its amounts also rendered as decimal ether and its gas prices as decimal gwei
+type humanTransaction struct {
+	eth_observer.Transaction
+	ValueEth              string `json:"valueEth,omitempty"`
+	FeeEth                string `json:"feeEth,omitempty"`
+	BurntFeeEth           string `json:"burntFeeEth,omitempty"`
+	PriorityFeeEth        string `json:"priorityFeeEth,omitempty"`
+	BlobFeeEth            string `json:"blobFeeEth,omitempty"`
+	L1FeeEth              string `json:"l1FeeEth,omitempty"`
+	GasPriceGwei          string `json:"gasPriceGwei,omitempty"`
+	EffectiveGasPriceGwei string `json:"effectiveGasPriceGwei,omitempty"`
+}
+
+type humanTransactionsResponse struct {
+	Transactions []humanTransaction `json:"transactions"`
+}
+
+type withdrawalsResponse struct {
+	Withdrawals []eth_observer.Withdrawal `json:"withdrawals"`
+}
+
+type feeSummaryResponse struct {
+	Fees eth_observer.FeeSummary `json:"fees"`
+}
+
+type balanceReportResponse struct {
+	Balance eth_observer.BalanceReport `json:"balance"`
+}
+
+type tokenBalancesResponse struct {
+	Address  string                      `json:"address"`
+	Block    int                         `json:"block"`
+	Balances []eth_observer.TokenBalance `json:"balances"`
+}
+
+type subscriptionsResponse struct {
+	Subscriptions []eth_observer.Subscription `json:"subscriptions"`
+}
+
+type nonceStatusResponse struct {
+	Nonces eth_observer.NonceStatus `json:"nonces"`
+}
+
+type subscriptionResponse struct {
+	Subscription eth_observer.Subscription `json:"subscription"`
+}
+
+type subscribeRequest struct {
+	Address       string   `json:"address"`
+	Label         string   `json:"label"`
+	Tags          []string `json:"tags"`
+	WebhookURL    string   `json:"webhookUrl"`
+	WebhookSecret string   `json:"webhookSecret"`
+
+	Rules     []eth_observer.Rule `json:"rules"`
+	MatchLogs bool                `json:"matchLogs"`
+}
+
+// redact removes the webhook secret of subscriptions, it is only returned when the subscription is created
+func redact(subscriptions ...eth_observer.Subscription) []eth_observer.Subscription {
+	for i := range subscriptions {
+		subscriptions[i].WebhookSecret = ""
+	}
+	return subscriptions
+}
+
+// validWebhookURL checks the webhook URL is an absolute http or https URL the chain's dispatcher may deliver to,
+// which excludes internal addresses unless it allows private networks, and writes a 400 response if not
+func (s *Server) validWebhookURL(w http.ResponseWriter, r *http.Request, webhookURL string) bool {
+	var err error
+	if webhooks := s.chain(r).Webhooks; webhooks != nil {
+		err = webhooks.CheckURL(r.Context(), webhookURL)
+	} else {
+		err = webhook.CheckURL(r.Context(), webhookURL, false)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_webhook_url", err.Error())
+		return false
+	}
+	return true
+}
+
+// newWebhookSecret returns a random secret for signing webhook deliveries
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// tenant returns the observer view of the tenant the request's key belongs to
+func (s *Server) tenant(r *http.Request) *eth_observer.Tenant {
+	key, _ := auth.KeyFromContext(r.Context())
+	return s.chain(r).Observer.Tenant(key.Tenant)
+}
+
+// validAddress checks the address is a hex encoded 20 byte address and writes a 400 response if not
+func validAddress(w http.ResponseWriter, r *http.Request, address string) bool {
+	if address == "" {
+		writeError(w, r, http.StatusBadRequest, "invalid_address", "address is required")
+		return false
+	}
+	if !addressPattern.MatchString(address) {
+		writeError(w, r, http.StatusBadRequest, "invalid_address", fmt.Sprintf("%q is not a 0x prefixed 20 byte hex address", address))
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleLatestBlock(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, latestBlockResponse{LatestBlock: s.chain(r).Observer.GetCurrentBlock()})
+}
+
+// handleMetrics returns the counters of the observer, such as the share of blocks skipped by their logs bloom
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, metricsResponse{Metrics: s.chain(r).Observer.Metrics()})
+}
+
+// handleTransactions returns the transactions of every subscription of the tenant, optionally filtered by tag
+func (s *Server) handleTransactions(w http.ResponseWriter, r *http.Request) {
+	human, ok := humanFormat(w, r)
+	if !ok {
+		return
+	}
+	filter := eth_observer.SubscriptionFilter{Tag: r.URL.Query().Get("tag")}
+	writeTransactions(w, s.tenant(r).QueryTransactions(filter), human)
+}
+
+func (s *Server) handleAddressTransactions(w http.ResponseWriter, r *http.Request) {
+	s.writeAddressTransactions(w, r, r.PathValue("address"))
+}
+
+func (s *Server) handleLegacyTransactions(w http.ResponseWriter, r *http.Request) {
+	s.writeAddressTransactions(w, r, r.URL.Query().Get("address"))
+}
+
+// writeAddressTransactions writes the transactions of a subscribed address
+// addresses the tenant has not subscribed to are answered with 404
+func (s *Server) writeAddressTransactions(w http.ResponseWriter, r *http.Request, address string) {
+	if !validAddress(w, r, address) {
+		return
+	}
+	human, ok := humanFormat(w, r)
+	if !ok {
+		return
+	}
+	tenant := s.tenant(r)
+	if _, ok := tenant.GetSubscription(address); !ok {
+		writeError(w, r, http.StatusNotFound, "not_subscribed", "address is not subscribed")
+		return
+	}
+	filter := eth_observer.SubscriptionFilter{Address: address, Tag: r.URL.Query().Get("tag")}
+	writeTransactions(w, tenant.QueryTransactions(filter), human)
+}
+
+// humanFormat reads the format query parameter, which is raw by default or human to render amounts in ether
+// and gas prices in gwei next to the raw hex. it writes a 400 response for other formats
+func humanFormat(w http.ResponseWriter, r *http.Request) (human bool, ok bool) {
+	switch r.URL.Query().Get("format") {
+	case "", "raw":
+		return false, true
+	case "human":
+		return true, true
+	}
+	writeError(w, r, http.StatusBadRequest, "invalid_format", "format must be raw or human")
+	return false, false
+}
+
+// writeTransactions writes the transactions, with their amounts rendered for people if human is set
+func writeTransactions(w http.ResponseWriter, transactions []eth_observer.Transaction, human bool) {
+	if !human {
+		writeJSON(w, http.StatusOK, transactionsResponse{Transactions: transactions})
+		return
+	}
+	rendered := make([]humanTransaction, len(transactions))
+	for i, transaction := range transactions {
+		rendered[i] = humanTransaction{
+			Transaction:           transaction,
+			ValueEth:              units.HexToEther(transaction.Value),
+			FeeEth:                units.HexToEther(transaction.Fee),
+			BurntFeeEth:           units.HexToEther(transaction.BurntFee),
+			PriorityFeeEth:        units.HexToEther(transaction.PriorityFee),
+			BlobFeeEth:            units.HexToEther(transaction.BlobFee),
+			L1FeeEth:              units.HexToEther(transaction.L1Fee),
+			GasPriceGwei:          units.HexToGwei(transaction.GasPrice),
+			EffectiveGasPriceGwei: units.HexToGwei(transaction.EffectiveGasPrice),
+		}
+	}
+	writeJSON(w, http.StatusOK, humanTransactionsResponse{Transactions: rendered})
+}
+
+// handleAddressWithdrawals returns the beacon chain withdrawals to a subscribed address
+func (s *Server) handleAddressWithdrawals(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	if !validAddress(w, r, address) {
+		return
+	}
+	tenant := s.tenant(r)
+	if _, ok := tenant.GetSubscription(address); !ok {
+		writeError(w, r, http.StatusNotFound, "not_subscribed", "address is not subscribed")
+		return
+	}
+	writeJSON(w, http.StatusOK, withdrawalsResponse{Withdrawals: tenant.GetWithdrawals(address)})
+}
+
+// handleAddressFees returns the fees paid by a subscribed address between the optional from and to RFC 3339 times
+func (s *Server) handleAddressFees(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	if !validAddress(w, r, address) {
+		return
+	}
+	var bounds [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		bound, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "invalid_time", fmt.Sprintf("%s must be an RFC 3339 time", name))
+			return
+		}
+		bounds[i] = bound
+	}
+	tenant := s.tenant(r)
+	if _, ok := tenant.GetSubscription(address); !ok {
+		writeError(w, r, http.StatusNotFound, "not_subscribed", "address is not subscribed")
+		return
+	}
+	writeJSON(w, http.StatusOK, feeSummaryResponse{Fees: tenant.FeeSummary(address, bounds[0], bounds[1])})
+}
+
+// handleBalance returns the balance of a subscribed address reconciled with its stored records. it asks the
+// ethereum client for the balances so failures of the client are answered with 502
+func (s *Server) handleBalance(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	if !validAddress(w, r, address) {
+		return
+	}
+	tenant := s.tenant(r)
+	if _, ok := tenant.GetSubscription(address); !ok {
+		writeError(w, r, http.StatusNotFound, "not_subscribed", "address is not subscribed")
+		return
+	}
+	report, err := tenant.BalanceReport(address)
+	if err != nil {
+		writeError(w, r, http.StatusBadGateway, "upstream_error", fmt.Sprintf("error querying the ethereum client: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, balanceReportResponse{Balance: report})
+}
+
+// maxTokensPerRequest is the number of token query parameters accepted by handleTokenBalances, as each token missing
+// from the registry costs two eth_calls on top of its balance
+const maxTokensPerRequest = 20
+
+// handleTokenBalances returns the balances of a subscribed address at a block, the last processed block by default,
+// in the tokens given by the token query parameters or in every token of the registry. tokens which could not be read
+// carry their error
+func (s *Server) handleTokenBalances(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	if !validAddress(w, r, address) {
+		return
+	}
+	query := r.URL.Query()
+	if len(query["token"]) > maxTokensPerRequest {
+		writeError(w, r, http.StatusBadRequest, "too_many_tokens", fmt.Sprintf("at most %d tokens may be given", maxTokensPerRequest))
+		return
+	}
+	for _, token := range query["token"] {
+		if !validAddress(w, r, token) {
+			return
+		}
+	}
+	block := s.chain(r).Observer.GetCurrentBlock()
+	if value := query.Get("block"); value != "" {
+		var err error
+		if block, err = strconv.Atoi(value); err != nil || block < 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid_block", "block must be a non negative integer")
+			return
+		}
+	}
+	if _, ok := s.tenant(r).GetSubscription(address); !ok {
+		writeError(w, r, http.StatusNotFound, "not_subscribed", "address is not subscribed")
+		return
+	}
+	balances, err := s.chain(r).Observer.TokenBalances(address, block, query["token"]...)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_address", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, tokenBalancesResponse{
+		Address:  strings.ToLower(address),
+		Block:    block,
+		Balances: balances,
+	})
+}
+
+// handleNonceStatus returns the nonce status of a subscribed address as the tenant sees it. it asks the ethereum client for
+// the transaction counts of the address so failures of the client are answered with 502
+func (s *Server) handleNonceStatus(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	if !validAddress(w, r, address) {
+		return
+	}
+	tenant := s.tenant(r)
+	if _, ok := tenant.GetSubscription(address); !ok {
+		writeError(w, r, http.StatusNotFound, "not_subscribed", "address is not subscribed")
+		return
+	}
+	status, err := tenant.NonceStatus(address)
+	if err != nil {
+		writeError(w, r, http.StatusBadGateway, "upstream_error", fmt.Sprintf("error querying the ethereum client: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, nonceStatusResponse{Nonces: status})
+}
+
+func (s *Server) handleListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	filter := eth_observer.SubscriptionFilter{Tag: r.URL.Query().Get("tag")}
+	writeJSON(w, http.StatusOK, subscriptionsResponse{Subscriptions: redact(s.tenant(r).ListSubscriptions(filter)...)})
+}
+
+func (s *Server) handleGetSubscription(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	if !validAddress(w, r, address) {
+		return
+	}
+	subscription, ok := s.tenant(r).GetSubscription(address)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "not_subscribed", "address is not subscribed")
+		return
+	}
+	writeJSON(w, http.StatusOK, subscriptionResponse{Subscription: redact(subscription)[0]})
+}
+
+// handleCreateSubscription subscribes the tenant to an address within the subscription quota of the key on the chain
+func (s *Server) handleCreateSubscription(w http.ResponseWriter, r *http.Request) {
+	var req subscribeRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_body", fmt.Sprintf("error decoding request: %v", err))
+		return
+	}
+	if !validAddress(w, r, req.Address) {
+		return
+	}
+	if req.WebhookURL != "" {
+		if !s.validWebhookURL(w, r, req.WebhookURL) {
+			return
+		}
+		if req.WebhookSecret == "" {
+			req.WebhookSecret = newWebhookSecret()
+		}
+	}
+	if err := eth_observer.ValidateRules(req.Rules); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid_rules", err.Error())
+		return
+	}
+
+	tenant := s.tenant(r)
+	key, _ := auth.KeyFromContext(r.Context())
+	subscription := eth_observer.Subscription{
+		Address:              req.Address,
+		SubscriptionMetadata: eth_observer.SubscriptionMetadata{Label: strings.TrimSpace(req.Label), Tags: req.Tags},
+		WebhookURL:           req.WebhookURL,
+		WebhookSecret:        req.WebhookSecret,
+		Rules:                req.Rules,
+		MatchLogs:            req.MatchLogs,
+	}
+	switch err := tenant.AddKeySubscription(subscription, key.ID, key.AllowsSubscription); {
+	case errors.Is(err, eth_observer.ErrAlreadySubscribed):
+		writeError(w, r, http.StatusConflict, "already_subscribed", "address is already subscribed")
+		return
+	case errors.Is(err, eth_observer.ErrSubscriptionQuota):
+		writeError(w, r, http.StatusTooManyRequests, "subscription_quota_exceeded", fmt.Sprintf("key is limited to %d subscriptions per chain", key.MaxSubscriptions))
+		return
+	case err != nil:
+		writeError(w, r, http.StatusInternalServerError, "subscription_failed", "subscription could not be saved")
+		return
+	}
+	subscription, _ = tenant.GetSubscription(req.Address)
+	w.Header().Set("Location", "/subscriptions/"+subscription.Address)
+	writeJSON(w, http.StatusCreated, subscriptionResponse{Subscription: subscription})
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aceagles/etherum_parser/pkg/auth"
+	"github.com/aceagles/etherum_parser/pkg/eth_observer"
+	"github.com/stretchr/testify/assert"
+)
+
+// specValidator validates JSON values against the schemas of the OpenAPI document. it supports the
+// subset of JSON schema used by the document. objects are closed unless additionalProperties is true
+// so a field added to a response without documenting it fails validation
+type specValidator struct {
+	doc map[string]any
+}
+
+func newSpecValidator(t *testing.T) *specValidator {
+	var doc map[string]any
+	assert.NoError(t, json.Unmarshal(openAPISpec, &doc))
+	return &specValidator{doc: doc}
+}
+
+// resolve follows $ref pointers within the document
+func (v *specValidator) resolve(node map[string]any) map[string]any {
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		var target any = v.doc
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			target = target.(map[string]any)[part]
+		}
+		node = target.(map[string]any)
+	}
+}
+
+// operation returns the operation documented for the method and request path
+func (v *specValidator) operation(method, path string) (map[string]any, bool) {
+	for template, item := range v.doc["paths"].(map[string]any) {
+		segments := strings.Split(template, "/")
+		for i, segment := range segments {
+			if strings.HasPrefix(segment, "{") {
+				segments[i] = "[^/]+"
+			} else {
+				segments[i] = regexp.QuoteMeta(segment)
+			}
+		}
+		if regexp.MustCompile("^" + strings.Join(segments, "/") + "$").MatchString(path) {
+			op, ok := item.(map[string]any)[strings.ToLower(method)].(map[string]any)
+			return op, ok
+		}
+	}
+	return nil, false
+}
+
+// responseSchema returns the schema documented for a response. undocumented methods on a known
+// path are expected to return the error envelope
+func (v *specValidator) responseSchema(method, path string, status int) (map[string]any, error) {
+	op, ok := v.operation(method, path)
+	if !ok {
+		if status >= 400 {
+			return v.resolve(map[string]any{"$ref": "#/components/schemas/Error"}), nil
+		}
+		return nil, fmt.Errorf("%s %s is not documented", method, path)
+	}
+	response, ok := op["responses"].(map[string]any)[strconv.Itoa(status)].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s %s does not document status %d", method, path, status)
+	}
+	response = v.resolve(response)
+	media, ok := response["content"].(map[string]any)["application/json"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s %s %d has no JSON content", method, path, status)
+	}
+	return v.resolve(media["schema"].(map[string]any)), nil
+}
+
+// validate returns the differences between the value and the schema
+func (v *specValidator) validate(at string, schema map[string]any, value any) []string {
+	schema = v.resolve(schema)
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable {
+			return nil
+		}
+		return []string{at + ": null is not allowed"}
+	}
+	var errs []string
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return []string{at + ": expected object"}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing required property %q", at, name))
+			}
+		}
+		open, _ := schema["additionalProperties"].(bool)
+		for name, field := range object {
+			property, ok := properties[name].(map[string]any)
+			if !ok {
+				if !open {
+					errs = append(errs, fmt.Sprintf("%s: undocumented property %q", at, name))
+				}
+				continue
+			}
+			errs = append(errs, v.validate(at+"."+name, property, field)...)
+		}
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			return []string{at + ": expected array"}
+		}
+		items, _ := schema["items"].(map[string]any)
+		for i, item := range array {
+			errs = append(errs, v.validate(fmt.Sprintf("%s[%d]", at, i), items, item)...)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return []string{at + ": expected string"}
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(str) {
+			errs = append(errs, fmt.Sprintf("%s: %q does not match %s", at, str, pattern))
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) {
+			return []string{at + ": expected integer"}
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return []string{at + ": expected number"}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{at + ": expected boolean"}
+		}
+	}
+	return errs
+}
+
+func TestOpenAPI_routesDocumented(t *testing.T) {
+	v := newSpecValidator(t)
+	server := NewServer(eth_observer.NewEthereumObserver("", nil), auth.NewAuthenticator(nil), nil, nil)
+
+	registered := map[string]bool{}
+	for _, pattern := range server.patterns {
+		method, path, _ := strings.Cut(pattern, " ")
+		registered[strings.ToLower(method)+" "+path] = true
+		item, _ := v.doc["paths"].(map[string]any)[path].(map[string]any)
+		_, ok := item[strings.ToLower(method)]
+		assert.True(t, ok, "route %s is not documented", pattern)
+	}
+	for path, item := range v.doc["paths"].(map[string]any) {
+		for method := range item.(map[string]any) {
+			assert.True(t, registered[method+" "+path], "documented operation %s %s is not served", method, path)
+		}
+	}
+}
+
+func TestOpenAPI_responsesMatchSpec(t *testing.T) {
+	v := newSpecValidator(t)
+	ts := newTestServer(t)
+	requests := []struct {
+		method string
+		path   string
+		key    string
+		body   string
+	}{
+		{method: http.MethodGet, path: "/openapi.json"},
+		{method: http.MethodGet, path: "/blocks/latest", key: "acme-key"},
+		{method: http.MethodGet, path: "/blocks/latest"},
+		{method: http.MethodPut, path: "/blocks/latest", key: "acme-key"},
+		{method: http.MethodGet, path: "/metrics", key: "acme-key"},
+		{method: http.MethodGet, path: "/chains", key: "acme-key"},
+		{method: http.MethodGet, path: "/transactions?chain=goerli", key: "acme-key"},
+		{method: http.MethodGet, path: "/getLatestBlock", key: "acme-key"},
+		{method: http.MethodGet, path: "/transactions", key: "acme-key"},
+		{method: http.MethodGet, path: "/addresses/" + testAddress + "/transactions", key: "acme-key"},
+		{method: http.MethodGet, path: "/addresses/" + testAddress + "/transactions?format=human", key: "acme-key"},
+		{method: http.MethodGet, path: "/transactions?format=yaml", key: "acme-key"},
+		{method: http.MethodGet, path: "/addresses/0x1/transactions", key: "acme-key"},
+		{method: http.MethodGet, path: "/addresses/" + otherAddress + "/transactions", key: "acme-key"},
+		{method: http.MethodGet, path: "/addresses/" + testAddress + "/withdrawals", key: "acme-key"},
+		{method: http.MethodGet, path: "/addresses/" + otherAddress + "/withdrawals", key: "acme-key"},
+		{method: http.MethodGet, path: "/addresses/" + testAddress + "/fees", key: "acme-key"},
+		{method: http.MethodGet, path: "/addresses/" + testAddress + "/fees?from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z", key: "acme-key"},
+		{method: http.MethodGet, path: "/addresses/" + testAddress + "/fees?from=yesterday", key: "acme-key"},
+		{method: http.MethodGet, path: "/addresses/" + testAddress + "/balance", key: "acme-key"},
+		{method: http.MethodGet, path: "/addresses/" + otherAddress + "/balance", key: "acme-key"},
+		{method: http.MethodGet, path: "/addresses/" + testAddress + "/tokens", key: "acme-key"},
+		{method: http.MethodGet, path: "/addresses/" + testAddress + "/tokens?block=7&token=" + otherAddress, key: "acme-key"},
+		{method: http.MethodGet, path: "/addresses/" + testAddress + "/tokens?block=-1", key: "acme-key"},
+		{method: http.MethodGet, path: "/addresses/" + testAddress + "/nonces", key: "acme-key"},
+		{method: http.MethodGet, path: "/addresses/" + otherAddress + "/nonces", key: "acme-key"},
+		{method: http.MethodGet, path: "/getTransactions?address=" + testAddress, key: "acme-key"},
+		{method: http.MethodGet, path: "/getTransactions", key: "acme-key"},
+		{method: http.MethodGet, path: "/subscriptions", key: "acme-key"},
+		{method: http.MethodGet, path: "/subscriptions/" + testAddress, key: "acme-key"},
+		{method: http.MethodGet, path: "/subscriptions/" + otherAddress, key: "acme-key"},
+		{method: http.MethodPost, path: "/subscriptions", key: "acme-read", body: `{"address":"` + otherAddress + `"}`},
+		{method: http.MethodPost, path: "/subscriptions", key: "acme-key", body: `{"address":"` + otherAddress + `","label":"l","tags":["t"],"matchLogs":true}`},
+		{method: http.MethodPost, path: "/subscriptions", key: "acme-key", body: `{"address":"` + otherAddress + `"}`},
+		{method: http.MethodPost, path: "/subscribe", key: "globex-key", body: `{"address":"` + testAddress + `"}`},
+		{method: http.MethodPost, path: "/subscribe", key: "globex-key", body: `{`},
+		{method: http.MethodPost, path: "/subscriptions", key: "globex-key", body: `{"address":"` + otherAddress + `","webhookUrl":"https://93.184.215.14/hook"}`},
+		{method: http.MethodGet, path: "/webhooks/dead-letters", key: "acme-key"},
+		{method: http.MethodPost, path: "/webhooks/dead-letters/nope/replay", key: "acme-key"},
+		{method: http.MethodDelete, path: "/webhooks/dead-letters/nope", key: "acme-key"},
+	}
+	for _, req := range requests {
+		t.Run(req.method+" "+req.path, func(t *testing.T) {
+			r, err := http.NewRequest(req.method, ts.URL+req.path, strings.NewReader(req.body))
+			assert.NoError(t, err)
+			if req.key != "" {
+				r.Header.Set("X-API-Key", req.key)
+			}
+			resp, err := http.DefaultClient.Do(r)
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+
+			schema, err := v.responseSchema(req.method, strings.Split(req.path, "?")[0], resp.StatusCode)
+			if !assert.NoError(t, err) {
+				return
+			}
+			var value any
+			assert.NoError(t, json.Unmarshal(body, &value))
+			assert.Empty(t, v.validate("response", schema, value))
+		})
+	}
+}
+
+func TestOpenAPI_detectsDrift(t *testing.T) {
+	v := newSpecValidator(t)
+	schema := v.resolve(map[string]any{"$ref": "#/components/schemas/LatestBlock"})
+	assert.Empty(t, v.validate("response", schema, map[string]any{"latestBlock": 1.0}))
+	assert.NotEmpty(t, v.validate("response", schema, map[string]any{"latestBlock": "1"}))
+	assert.NotEmpty(t, v.validate("response", schema, map[string]any{}))
+	assert.NotEmpty(t, v.validate("response", schema, map[string]any{"latestBlock": 1.0, "extra": true}))
+}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aceagles/etherum_parser/pkg/auth"
+	"github.com/aceagles/etherum_parser/pkg/eth_observer"
+	"github.com/aceagles/etherum_parser/pkg/websocket"
+)
+
+// socketWriteTimeout bounds every write to a websocket client
+const socketWriteTimeout = 10 * time.Second
+
+// socketProtocol is the websocket subprotocol selected for clients offering it, which browsers offer alongside
+// their API key as auth.KeyProtocolPrefix followed by the key since they cannot set headers on the handshake
+const socketProtocol = "observer"
+
+// socketRequest is a message sent by a websocket client
+type socketRequest struct {
+	Action  string   `json:"action"`
+	Address string   `json:"address"`
+	Label   string   `json:"label"`
+	Tags    []string `json:"tags"`
+	// LastEventID replays the stored transactions after the given ID when subscribing
+	LastEventID *int `json:"lastEventId"`
+}
+
+type socketError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// socketMessage is a message sent to a websocket client
+type socketMessage struct {
+	Type        string                    `json:"type"`
+	Address     string                    `json:"address,omitempty"`
+	ID          int                       `json:"id,omitempty"`
+	Transaction *eth_observer.Transaction `json:"transaction,omitempty"`
+	Error       *socketError              `json:"error,omitempty"`
+}
+
+// socketSession is the state of a websocket connection
+type socketSession struct {
+	server   *Server
+	conn     *websocket.Conn
+	key      auth.Key
+	tenant   *eth_observer.Tenant
+	broker   *Broker
+	client   *streamClient
+	lastSent map[string]int // address -> ID of the last event sent, the addresses watched by the connection
+}
+
+// handleWebSocket serves the websocket push API. clients send subscribe and unsubscribe actions to
+// choose the addresses pushed over the connection, subscribing the tenant to addresses it does not watch yet.
+// the server pings the client every heartbeat and drops it if it does not answer or falls behind
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !s.allowedOrigin(r) {
+		writeError(w, r, http.StatusForbidden, "forbidden_origin", "websockets may not be opened from this origin")
+		return
+	}
+	key, _ := auth.KeyFromContext(r.Context())
+	conn, err := websocket.Upgrade(w, r, socketProtocol)
+	if err != nil {
+		return
+	}
+	session := &socketSession{
+		server:   s,
+		conn:     conn,
+		key:      key,
+		tenant:   s.tenant(r),
+		broker:   s.chain(r).Broker,
+		client:   s.chain(r).Broker.newClient(),
+		lastSent: make(map[string]int),
+	}
+	defer session.close()
+	session.run()
+}
+
+// allowedOrigin reports whether a websocket may be opened by the request. browsers send the origin of the page
+// opening it, which must be the API's own host or one of the allowed origins. requests without an origin do not
+// come from a browser and are allowed
+func (s *Server) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return slices.ContainsFunc(s.AllowedOrigins, func(allowed string) bool {
+		return allowed == "*" || strings.EqualFold(strings.TrimSuffix(strings.TrimSpace(allowed), "/"), origin)
+	})
+}
+
+// run reads client requests on a separate goroutine and serialises every write on the calling one
+func (s *socketSession) run() {
+	requests := make(chan []byte)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	deadline := func() { _ = s.conn.SetReadDeadline(time.Now().Add(2 * s.server.heartbeat)) }
+	deadline()
+	s.conn.PongHandler = func([]byte) { deadline() }
+	go func() {
+		for {
+			_, message, err := s.conn.ReadMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			deadline()
+			select {
+			case requests <- message:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(s.server.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case message := <-requests:
+			err = s.handleRequest(message)
+		case event := <-s.client.events:
+			err = s.sendEvent(event)
+		case <-s.client.dropped:
+			_ = s.conn.Close(websocket.CloseTryAgainLater, "client too slow, resubscribe with lastEventId")
+			return
+		case <-heartbeat.C:
+			_ = s.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+			err = s.conn.WriteControl(websocket.OpPing, nil)
+		case <-readErr:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// close stops pushing events to the connection and closes it
+func (s *socketSession) close() {
+	for address := range s.lastSent {
+		s.broker.unwatch(s.client, address)
+	}
+	_ = s.conn.Close(websocket.CloseGoingAway, "")
+}
+
+// write sends a message to the client
+func (s *socketSession) write(message socketMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	return s.conn.WriteMessage(websocket.OpText, data)
+}
+
+// writeError sends an error message to the client, the connection stays open
+func (s *socketSession) writeError(address, code, message string) error {
+	return s.write(socketMessage{Type: "error", Address: address, Error: &socketError{Code: code, Message: message}})
+}
+
+// handleRequest applies a subscribe or unsubscribe request from the client
+func (s *socketSession) handleRequest(message []byte) error {
+	var req socketRequest
+	if err := json.Unmarshal(message, &req); err != nil {
+		return s.writeError("", "invalid_message", fmt.Sprintf("error decoding message: %v", err))
+	}
+	if !addressPattern.MatchString(req.Address) {
+		return s.writeError(req.Address, "invalid_address", fmt.Sprintf("%q is not a 0x prefixed 20 byte hex address", req.Address))
+	}
+	address := strings.ToLower(req.Address)
+
+	switch req.Action {
+	case "subscribe":
+		return s.subscribe(address, req)
+	case "unsubscribe":
+		if _, ok := s.lastSent[address]; ok {
+			s.broker.unwatch(s.client, address)
+			delete(s.lastSent, address)
+		}
+		return s.write(socketMessage{Type: "unsubscribed", Address: address})
+	default:
+		return s.writeError(address, "invalid_action", "action must be subscribe or unsubscribe")
+	}
+}
+
+// subscribe watches the address on the connection, subscribing the tenant to it first if needed
+func (s *socketSession) subscribe(address string, req socketRequest) error {
+	if _, ok := s.tenant.GetSubscription(address); !ok {
+		if !s.key.HasScope(auth.ScopeSubscribe) {
+			return s.writeError(address, "forbidden", "key lacks the subscribe scope")
+		}
+		subscription := eth_observer.Subscription{
+			Address:              address,
+			SubscriptionMetadata: eth_observer.SubscriptionMetadata{Label: strings.TrimSpace(req.Label), Tags: req.Tags},
+		}
+		switch err := s.tenant.AddKeySubscription(subscription, s.key.ID, s.key.AllowsSubscription); {
+		case errors.Is(err, eth_observer.ErrSubscriptionQuota):
+			return s.writeError(address, "subscription_quota_exceeded", fmt.Sprintf("key is limited to %d subscriptions per chain", s.key.MaxSubscriptions))
+		case err != nil && !errors.Is(err, eth_observer.ErrAlreadySubscribed):
+			return s.writeError(address, "subscription_failed", "subscription could not be saved")
+		}
+	}
+
+	stored := s.broker.watch(s.client, address)
+	s.lastSent[address] = len(stored)
+	if err := s.write(socketMessage{Type: "subscribed", Address: address}); err != nil {
+		return err
+	}
+	if req.LastEventID == nil {
+		return nil
+	}
+	for i := max(*req.LastEventID, 0); i < len(stored); i++ {
+		if err := s.sendTransaction(address, i+1, stored[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendEvent pushes a live event unless the address was unwatched or the event was already replayed
+func (s *socketSession) sendEvent(event streamEvent) error {
+	lastSent, ok := s.lastSent[event.Address]
+	if !ok || event.ID <= lastSent {
+		return nil
+	}
+	s.lastSent[event.Address] = event.ID
+	return s.sendTransaction(event.Address, event.ID, event.Transaction)
+}
+
+// sendTransaction pushes a transaction if the tenant may see it
+func (s *socketSession) sendTransaction(address string, id int, transaction eth_observer.Transaction) error {
+	transaction, ok := s.tenant.Notification(address, transaction)
+	if !ok {
+		return nil
+	}
+	return s.write(socketMessage{Type: "transaction", Address: address, ID: id, Transaction: &transaction})
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aceagles/etherum_parser/pkg/eth_observer"
+	"github.com/aceagles/etherum_parser/pkg/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func dialSocket(t *testing.T, url, key string) *websocket.Conn {
+	conn, _, err := websocket.Dial("ws"+strings.TrimPrefix(url, "http")+"/ws", http.Header{"X-Api-Key": {key}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { conn.Close(websocket.CloseNormal, "") })
+	return conn
+}
+
+func sendSocket(t *testing.T, conn *websocket.Conn, request string) {
+	assert.NoError(t, conn.WriteMessage(websocket.OpText, []byte(request)))
+}
+
+func readSocket(t *testing.T, conn *websocket.Conn) socketMessage {
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	_, data, err := conn.ReadMessage()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	var message socketMessage
+	assert.NoError(t, json.Unmarshal(data, &message))
+	return message
+}
+
+func TestServer_webSocket(t *testing.T) {
+	ts, broker := newTestServerWithBroker(t)
+	conn := dialSocket(t, ts.URL, "acme-key")
+
+	sendSocket(t, conn, `{"action":"subscribe","address":"`+testAddress[:40]+`AA","lastEventId":0}`)
+	assert.Equal(t, socketMessage{Type: "subscribed", Address: testAddress}, readSocket(t, conn))
+	replayed := readSocket(t, conn)
+	assert.Equal(t, "transaction", replayed.Type)
+	assert.Equal(t, 1, replayed.ID)
+	assert.Equal(t, "hot", replayed.Transaction.Subscription.Label)
+
+	broker.AddTransactions(testAddress, []eth_observer.Transaction{{Hash: "0x2", BlockNumber: "0x2"}})
+	live := readSocket(t, conn)
+	assert.Equal(t, 2, live.ID)
+	assert.Equal(t, "0x2", live.Transaction.Hash)
+
+	// subscribing over the socket subscribes the tenant
+	sendSocket(t, conn, `{"action":"subscribe","address":"`+otherAddress+`","label":"new"}`)
+	assert.Equal(t, "subscribed", readSocket(t, conn).Type)
+	resp, _ := do(t, ts, http.MethodGet, "/subscriptions/"+otherAddress, "acme-key", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	sendSocket(t, conn, `{"action":"unsubscribe","address":"`+testAddress+`"}`)
+	assert.Equal(t, "unsubscribed", readSocket(t, conn).Type)
+	broker.AddTransactions(testAddress, []eth_observer.Transaction{{Hash: "0x3", BlockNumber: "0x3"}})
+	broker.AddTransactions(otherAddress, []eth_observer.Transaction{{Hash: "0x4", BlockNumber: "0x3"}})
+	assert.Equal(t, "0x4", readSocket(t, conn).Transaction.Hash)
+}
+
+func TestServer_webSocketProtocolKey(t *testing.T) {
+	ts := newTestServer(t)
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+
+	// browsers offer the key as a subprotocol and the server selects the observer protocol, never echoing the key
+	conn, resp, err := websocket.Dial(url, http.Header{"Sec-Websocket-Protocol": {"observer, apikey.acme-key"}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer conn.Close(websocket.CloseNormal, "")
+	assert.Equal(t, "observer", resp.Header.Get("Sec-WebSocket-Protocol"))
+	sendSocket(t, conn, `{"action":"subscribe","address":"`+testAddress+`"}`)
+	assert.Equal(t, socketMessage{Type: "subscribed", Address: testAddress}, readSocket(t, conn))
+
+	_, resp, err = websocket.Dial(url, http.Header{"Sec-Websocket-Protocol": {"observer, apikey.nope"}})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestServer_webSocketOrigin(t *testing.T) {
+	env := newTestEnv(t)
+	env.server.AllowedOrigins = []string{"https://app.example.com"}
+	url := "ws" + strings.TrimPrefix(env.ts.URL, "http") + "/ws"
+	tests := []struct {
+		name       string
+		origin     string
+		wantStatus int
+	}{
+		{name: "No origin", wantStatus: http.StatusSwitchingProtocols},
+		{name: "Own origin", origin: env.ts.URL, wantStatus: http.StatusSwitchingProtocols},
+		{name: "Allowed origin", origin: "https://APP.example.com", wantStatus: http.StatusSwitchingProtocols},
+		{name: "Other origin", origin: "https://evil.example.com", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{"X-Api-Key": {"acme-key"}}
+			if tt.origin != "" {
+				header.Set("Origin", tt.origin)
+			}
+			conn, resp, err := websocket.Dial(url, header)
+			if assert.NotNil(t, resp) {
+				assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			}
+			if err == nil {
+				conn.Close(websocket.CloseNormal, "")
+			}
+		})
+	}
+
+	env.server.AllowedOrigins = []string{"*"}
+	conn, _, err := websocket.Dial(url, http.Header{"X-Api-Key": {"acme-key"}, "Origin": {"https://evil.example.com"}})
+	if assert.NoError(t, err) {
+		conn.Close(websocket.CloseNormal, "")
+	}
+}
+
+func TestServer_webSocketErrors(t *testing.T) {
+	ts := newTestServer(t)
+	conn := dialSocket(t, ts.URL, "acme-read")
+	tests := []struct {
+		name     string
+		request  string
+		wantCode string
+	}{
+		{name: "Malformed", request: `{`, wantCode: "invalid_message"},
+		{name: "Invalid address", request: `{"action":"subscribe","address":"0x1"}`, wantCode: "invalid_address"},
+		{name: "Unknown action", request: `{"action":"nope","address":"` + testAddress + `"}`, wantCode: "invalid_action"},
+		{name: "Subscribe without scope", request: `{"action":"subscribe","address":"` + otherAddress + `"}`, wantCode: "forbidden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sendSocket(t, conn, tt.request)
+			message := readSocket(t, conn)
+			assert.Equal(t, "error", message.Type)
+			assert.Equal(t, tt.wantCode, message.Error.Code)
+		})
+	}
+
+	_, _, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", nil)
+	assert.Error(t, err, "unauthenticated clients must not be upgraded")
+}
+
+func TestServer_webSocketKeepalive(t *testing.T) {
+	ts := newTestServer(t)
+	conn := dialSocket(t, ts.URL, "acme-key")
+	pings := 0
+	conn.PingHandler = func([]byte) { pings++ }
+
+	// the client answers the server's pings while reading so the connection outlives the read deadline
+	messages := make(chan socketMessage)
+	go func() {
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				close(messages)
+				return
+			}
+			var message socketMessage
+			_ = json.Unmarshal(data, &message)
+			messages <- message
+		}
+	}()
+	time.Sleep(300 * time.Millisecond)
+	sendSocket(t, conn, `{"action":"subscribe","address":"`+testAddress+`"}`)
+	message, ok := <-messages
+	assert.True(t, ok, "connection was closed")
+	assert.Equal(t, "subscribed", message.Type)
+	assert.Greater(t, pings, 2)
+}
+
+func TestServer_webSocketSlowClient(t *testing.T) {
+	ts, broker := newTestServerWithBroker(t)
+	broker.bufferSize = 1
+	conn := dialSocket(t, ts.URL, "acme-key")
+	sendSocket(t, conn, `{"action":"subscribe","address":"`+testAddress+`"}`)
+	assert.Equal(t, "subscribed", readSocket(t, conn).Type)
+
+	burst := make([]eth_observer.Transaction, 10)
+	for i := range burst {
+		burst[i] = eth_observer.Transaction{Hash: "0x2", BlockNumber: "0x2"}
+	}
+	broker.AddTransactions(testAddress, burst)
+
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		assert.True(t, errors.As(err, &closeErr), "unexpected error %v", err)
+		assert.Equal(t, websocket.CloseTryAgainLater, closeErr.Code)
+		return
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultHeartbeat is the interval between heartbeat comments sent to idle streams
+const defaultHeartbeat = 15 * time.Second
+
+// handleStream streams the transactions matched for a subscribed address as Server-Sent Events.
+// each event carries the position of the transaction in the store as its ID so a client reconnecting
+// with Last-Event-ID receives the transactions it missed from the store before the live events
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Query().Get("address")
+	if !validAddress(w, r, address) {
+		return
+	}
+	address = strings.ToLower(address)
+	tenant := s.tenant(r)
+	if _, ok := tenant.GetSubscription(address); !ok {
+		writeError(w, r, http.StatusNotFound, "not_subscribed", "address is not subscribed")
+		return
+	}
+	lastID := 0
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		id, err := strconv.Atoi(header)
+		if err != nil || id < 0 {
+			writeError(w, r, http.StatusBadRequest, "invalid_last_event_id", "Last-Event-ID must be a non negative integer")
+			return
+		}
+		lastID = id
+	}
+
+	broker := s.chain(r).Broker
+	client := broker.newClient()
+	stored := broker.watch(client, address)
+	defer broker.unwatch(client, address)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	controller := http.NewResponseController(w)
+
+	// replay the transactions stored since the last event the client received
+	sent := lastID
+	send := func(event streamEvent) error {
+		if event.ID <= sent {
+			return nil
+		}
+		sent = event.ID
+		transaction, ok := tenant.Notification(address, event.Transaction)
+		if !ok {
+			return nil
+		}
+		data, err := json.Marshal(transaction)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: transaction\ndata: %s\n\n", event.ID, data)
+		return err
+	}
+	if _, err := io.WriteString(w, "retry: 1000\n\n"); err != nil {
+		return
+	}
+	for i := lastID; i < len(stored); i++ {
+		if err := send(streamEvent{ID: i + 1, Address: address, Transaction: stored[i]}); err != nil {
+			return
+		}
+	}
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.dropped:
+			// the client fell behind, it reconnects with Last-Event-ID and resumes from the store
+			return
+		case event := <-client.events:
+			err = send(event)
+		case <-heartbeat.C:
+			_, err = io.WriteString(w, ": heartbeat\n\n")
+		}
+		if err == nil {
+			err = controller.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
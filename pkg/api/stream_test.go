@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aceagles/etherum_parser/pkg/eth_observer"
+	memorystore "github.com/aceagles/etherum_parser/pkg/memory_store"
+	"github.com/stretchr/testify/assert"
+)
+
+// readEvent reads lines from an event stream up to the next blank line
+func readEvent(t *testing.T, reader *bufio.Reader) []string {
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return lines
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func openStream(t *testing.T, url, key, lastEventID string) (*http.Response, *bufio.Reader) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	assert.NoError(t, err)
+	req.Header.Set("X-API-Key", key)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp, bufio.NewReader(resp.Body)
+}
+
+func TestServer_stream(t *testing.T) {
+	ts, broker := newTestServerWithBroker(t)
+	resp, reader := openStream(t, ts.URL+"/stream?address="+testAddress, "acme-key", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	assert.Equal(t, []string{"retry: 1000"}, readEvent(t, reader))
+	// the stored transaction is replayed
+	event := readEvent(t, reader)
+	assert.Equal(t, "id: 1", event[0])
+	assert.Equal(t, "event: transaction", event[1])
+	assert.Contains(t, event[2], `"hash":"0x1"`)
+	assert.Contains(t, event[2], `"label":"hot"`)
+
+	// newly stored transactions are pushed
+	broker.AddTransactions(testAddress, []eth_observer.Transaction{{Hash: "0x2", BlockNumber: "0x2"}})
+	broker.AddTransactions(otherAddress, []eth_observer.Transaction{{Hash: "0x3", BlockNumber: "0x2"}})
+	event = readEvent(t, reader)
+	assert.Equal(t, "id: 2", event[0])
+	assert.Contains(t, event[2], `"hash":"0x2"`)
+
+	// idle streams receive heartbeats
+	assert.Equal(t, []string{": heartbeat"}, readEvent(t, reader))
+}
+
+func TestServer_streamResume(t *testing.T) {
+	ts, broker := newTestServerWithBroker(t)
+	broker.AddTransactions(testAddress, []eth_observer.Transaction{{Hash: "0x2", BlockNumber: "0x2"}, {Hash: "0x3", BlockNumber: "0x2"}})
+
+	_, reader := openStream(t, ts.URL+"/stream?address="+testAddress, "acme-key", "2")
+	readEvent(t, reader)
+	event := readEvent(t, reader)
+	assert.Equal(t, "id: 3", event[0])
+	assert.Contains(t, event[2], `"hash":"0x3"`)
+}
+
+func TestServer_streamErrors(t *testing.T) {
+	ts := newTestServer(t)
+	tests := []struct {
+		name        string
+		path        string
+		key         string
+		lastEventID string
+		wantStatus  int
+	}{
+		{name: "Not subscribed", path: "/stream?address=" + testAddress, key: "globex-key", wantStatus: http.StatusNotFound},
+		{name: "Missing address", path: "/stream", key: "acme-key", wantStatus: http.StatusBadRequest},
+		{name: "Invalid Last-Event-ID", path: "/stream?address=" + testAddress, key: "acme-key", lastEventID: "x", wantStatus: http.StatusBadRequest},
+		{name: "Unauthenticated", path: "/stream?address=" + testAddress, wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := openStream(t, ts.URL+tt.path, tt.key, tt.lastEventID)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		})
+	}
+}
+
+func TestBroker_slowClientDropped(t *testing.T) {
+	broker := NewBroker(memorystore.NewMemStore())
+	broker.bufferSize = 2
+	slow := broker.newClient()
+	broker.watch(slow, testAddress)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			broker.AddTransactions(testAddress, []eth_observer.Transaction{{Hash: "0x1"}})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a slow client blocked the broker")
+	}
+	assert.Len(t, broker.GetTransactions(testAddress), 10)
+	select {
+	case <-slow.dropped:
+	default:
+		t.Fatal("slow client was not dropped")
+	}
+
+	broker.unwatch(slow, testAddress)
+	assert.Empty(t, broker.clients)
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/aceagles/etherum_parser/pkg/auth"
+	"github.com/aceagles/etherum_parser/pkg/webhook"
+)
+
+type deadLettersResponse struct {
+	DeadLetters []webhook.Delivery `json:"deadLetters"`
+}
+
+type deliveryResponse struct {
+	Delivery webhook.Delivery `json:"delivery"`
+}
+
+// webhookOwner returns the owner whose deliveries the request may see. admin keys see every tenant
+func webhookOwner(r *http.Request) string {
+	key, _ := auth.KeyFromContext(r.Context())
+	if key.HasScope(auth.ScopeAdmin) {
+		return ""
+	}
+	return key.Tenant
+}
+
+// redactDeliveries removes the webhook secret of deliveries
+func redactDeliveries(deliveries ...webhook.Delivery) []webhook.Delivery {
+	for i := range deliveries {
+		deliveries[i].Secret = ""
+	}
+	return deliveries
+}
+
+// webhooksEnabled writes a 404 response if the server was created without a webhook dispatcher
+func (s *Server) webhooksEnabled(w http.ResponseWriter, r *http.Request) bool {
+	if s.chain(r).Webhooks == nil {
+		writeError(w, r, http.StatusNotFound, "webhooks_disabled", "webhook delivery is not enabled")
+		return false
+	}
+	return true
+}
+
+// handleDeadLetters lists the webhook deliveries which kept failing
+func (s *Server) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !s.webhooksEnabled(w, r) {
+		return
+	}
+	deadLetters := s.chain(r).Webhooks.Outbox().DeadLetters(webhookOwner(r))
+	writeJSON(w, http.StatusOK, deadLettersResponse{DeadLetters: redactDeliveries(deadLetters...)})
+}
+
+// handleReplayDeadLetter moves a dead delivery back to the outbox so it is delivered again
+func (s *Server) handleReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if !s.webhooksEnabled(w, r) {
+		return
+	}
+	delivery, err := s.chain(r).Webhooks.Outbox().Replay(webhookOwner(r), r.PathValue("id"), time.Now())
+	if errors.Is(err, webhook.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "not_found", "no such dead letter")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "replay_failed", err.Error())
+		return
+	}
+	s.chain(r).Webhooks.Wake()
+	writeJSON(w, http.StatusOK, deliveryResponse{Delivery: redactDeliveries(delivery)[0]})
+}
+
+// handlePurgeDeadLetter removes a dead delivery for good
+func (s *Server) handlePurgeDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if !s.webhooksEnabled(w, r) {
+		return
+	}
+	delivery, err := s.chain(r).Webhooks.Outbox().Purge(webhookOwner(r), r.PathValue("id"))
+	if errors.Is(err, webhook.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, "not_found", "no such dead letter")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "purge_failed", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveryResponse{Delivery: redactDeliveries(delivery)[0]})
+}
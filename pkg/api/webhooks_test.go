@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aceagles/etherum_parser/pkg/eth_observer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_webhooks(t *testing.T) {
+	env := newTestEnv(t)
+	env.dispatcher.MaxAttempts = 1
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	resp, body := do(t, env.ts, http.MethodPost, "/subscriptions", "acme-key", `{"address":"`+otherAddress+`","webhookUrl":"ftp://nope"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "invalid_webhook_url", body["error"].(map[string]any)["code"])
+
+	// internal addresses are refused unless the dispatcher allows private networks
+	env.dispatcher.AllowPrivateNetworks = false
+	resp, body = do(t, env.ts, http.MethodPost, "/subscriptions", "acme-key", `{"address":"`+otherAddress+`","webhookUrl":"http://169.254.169.254/latest"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "invalid_webhook_url", body["error"].(map[string]any)["code"])
+	env.dispatcher.AllowPrivateNetworks = true
+
+	// the generated secret is only returned when the subscription is created
+	resp, body = do(t, env.ts, http.MethodPost, "/subscriptions", "acme-key", `{"address":"`+otherAddress+`","webhookUrl":"`+failing.URL+`"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Len(t, body["subscription"].(map[string]any)["webhookSecret"], 64)
+	_, body = do(t, env.ts, http.MethodGet, "/subscriptions/"+otherAddress, "acme-key", "")
+	assert.NotContains(t, body["subscription"], "webhookSecret")
+	assert.Equal(t, failing.URL, body["subscription"].(map[string]any)["webhookUrl"])
+
+	env.broker.AddTransactions(otherAddress, []eth_observer.Transaction{{Hash: "0x2", BlockNumber: "0x2"}})
+	env.dispatcher.DeliverDue(context.Background())
+
+	_, body = do(t, env.ts, http.MethodGet, "/webhooks/dead-letters", "acme-key", "")
+	deadLetters := body["deadLetters"].([]any)
+	assert.Len(t, deadLetters, 1)
+	deadLetter := deadLetters[0].(map[string]any)
+	assert.NotContains(t, deadLetter, "secret")
+	assert.Contains(t, deadLetter["lastError"], "503")
+
+	_, body = do(t, env.ts, http.MethodGet, "/webhooks/dead-letters", "globex-key", "")
+	assert.Empty(t, body["deadLetters"])
+	resp, _ = do(t, env.ts, http.MethodPost, "/webhooks/dead-letters/"+deadLetter["id"].(string)+"/replay", "globex-key", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, body = do(t, env.ts, http.MethodPost, "/webhooks/dead-letters/"+deadLetter["id"].(string)+"/replay", "acme-key", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 0.0, body["delivery"].(map[string]any)["attempts"])
+	assert.Len(t, env.dispatcher.Outbox().Pending(), 1)
+
+	// a dead letter is removed for good by its owner
+	env.dispatcher.DeliverDue(context.Background())
+	assert.Len(t, env.dispatcher.Outbox().DeadLetters(""), 1)
+	resp, _ = do(t, env.ts, http.MethodDelete, "/webhooks/dead-letters/"+deadLetter["id"].(string), "globex-key", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, body = do(t, env.ts, http.MethodDelete, "/webhooks/dead-letters/"+deadLetter["id"].(string), "acme-key", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NotContains(t, body["delivery"], "secret")
+	assert.Empty(t, env.dispatcher.Outbox().DeadLetters(""))
+	assert.Empty(t, env.dispatcher.Outbox().Pending())
+}
@@ -0,0 +1,43 @@
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	assert.NoError(t, WriteFile(path, []byte(`{"a":1}`)))
+	assert.NoError(t, WriteFile(path, []byte(`{}`)))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `{}`, string(data))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary file is left behind")
+
+	assert.Error(t, WriteFile(filepath.Join(dir, "missing", "state.json"), nil))
+}
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	journal := NewJournal(path)
+	assert.NoError(t, journal.Append([]byte(`{"a":1}`), []byte(`{"a":2}`)))
+	assert.NoError(t, journal.Rewrite([][]byte{[]byte(`{"a":3}`)}))
+	assert.NoError(t, journal.Append([]byte(`{"a":4}`)))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"a\":3}\n{\"a\":4}\n", string(data), "appends after a rewrite go to the new file")
+
+	lines, torn := SplitLines(append(data, []byte("\n{\"a\"")...))
+	assert.Equal(t, [][]byte{[]byte(`{"a":3}`), []byte(`{"a":4}`)}, lines)
+	assert.True(t, torn)
+	_, torn = SplitLines(data)
+	assert.False(t, torn)
+}
@@ -0,0 +1,78 @@
+package atomicfile
+
+import (
+	"bytes"
+	"os"
+)
+
+// Journal is a file of records, one per line, which are appended so that the cost of a change does not grow with
+// the file. each append is synced before it returns, and an append which fails is cut off so the journal never
+// holds a partial record followed by later ones. Rewrite compacts the journal atomically
+type Journal struct {
+	path string
+	file *os.File // opened for appending on the first append
+}
+
+// NewJournal returns the journal of the file at path, which is created on the first append
+func NewJournal(path string) *Journal {
+	return &Journal{path: path}
+}
+
+// SplitLines returns the complete lines of the content of a journal, skipping blank ones, and whether the last
+// line was cut short, as happens when the process stops while it is appended
+func SplitLines(data []byte) (lines [][]byte, torn bool) {
+	split := bytes.Split(data, []byte("\n"))
+	for _, line := range split[:len(split)-1] {
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines, len(bytes.TrimSpace(split[len(split)-1])) > 0
+}
+
+// Append writes records at the end of the journal, a line each, and syncs them
+func (j *Journal) Append(records ...[]byte) error {
+	if j.file == nil {
+		file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+		j.file = file
+	}
+	info, err := j.file.Stat()
+	if err != nil {
+		return err
+	}
+	var data bytes.Buffer
+	for _, record := range records {
+		data.Write(record)
+		data.WriteByte('\n')
+	}
+	if _, err := j.file.Write(data.Bytes()); err != nil {
+		_ = j.file.Truncate(info.Size())
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		_ = j.file.Truncate(info.Size())
+		return err
+	}
+	return nil
+}
+
+// Rewrite replaces the journal with the records given, see WriteFile
+func (j *Journal) Rewrite(records [][]byte) error {
+	var data bytes.Buffer
+	for _, record := range records {
+		data.Write(record)
+		data.WriteByte('\n')
+	}
+	if err := WriteFile(j.path, data.Bytes()); err != nil {
+		return err
+	}
+	// the file appended to so far was replaced
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+	return nil
+}
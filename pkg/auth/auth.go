@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aceagles/etherum_parser/pkg/websocket"
+)
+
+// Scope grants access to a group of endpoints
+type Scope string
+
+const (
+	ScopeRead      Scope = "read"
+	ScopeSubscribe Scope = "subscribe"
+	// ScopeAdmin grants every other scope
+	ScopeAdmin Scope = "admin"
+)
+
+// Key is an API key as stored in the keys file. only the sha256 hash of the key is stored
+type Key struct {
+	ID     string  `json:"id"`
+	Hash   string  `json:"hash"`
+	Tenant string  `json:"tenant"`
+	Scopes []Scope `json:"scopes"`
+	// RateLimit is the number of requests per second allowed for the key, 0 disables the limit
+	RateLimit float64 `json:"rateLimit"`
+	// Burst is the number of requests allowed above the rate limit, defaults to the rate limit
+	Burst int `json:"burst"`
+	// MaxSubscriptions is the number of subscriptions the key may hold, 0 disables the limit
+	MaxSubscriptions int `json:"maxSubscriptions"`
+}
+
+// HasScope reports whether the key grants the scope
+func (k Key) HasScope(scope Scope) bool {
+	return slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, ScopeAdmin)
+}
+
+// AllowsSubscription reports whether the key may add a subscription given its current number of subscriptions
+func (k Key) AllowsSubscription(current int) bool {
+	return k.MaxSubscriptions == 0 || current < k.MaxSubscriptions
+}
+
+// HashKey returns the hex encoded sha256 hash of an API key as stored in the keys file
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// LoadKeys reads the keys held in a JSON keys file. key IDs must be unique
+func LoadKeys(path string) ([]Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		// rate limits are kept by key ID, so keys sharing an ID would share a limit
+		if ids[key.ID] {
+			return nil, fmt.Errorf("key %q: duplicate key ID", key.ID)
+		}
+		ids[key.ID] = true
+		if _, err := hex.DecodeString(key.Hash); err != nil || len(key.Hash) != sha256.Size*2 {
+			return nil, fmt.Errorf("key %q: hash must be a hex encoded sha256 digest", key.ID)
+		}
+		if key.Tenant == "" {
+			return nil, fmt.Errorf("key %q: missing tenant", key.ID)
+		}
+	}
+	return keys, nil
+}
+
+// bucket is a token bucket limiting the request rate of a key
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// ErrorWriter writes an error response for a rejected request
+type ErrorWriter func(w http.ResponseWriter, r *http.Request, status int, code, message string)
+
+// Authenticator authenticates requests by API key and enforces the scope and rate limit of the key
+type Authenticator struct {
+	keys    []Key
+	mux     sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+// NewAuthenticator creates a new Authenticator accepting the given keys
+func NewAuthenticator(keys []Key) *Authenticator {
+	return &Authenticator{keys: keys, buckets: make(map[string]*bucket), now: time.Now}
+}
+
+type contextKey struct{}
+
+// KeyFromContext returns the key which authenticated the request
+func KeyFromContext(ctx context.Context) (Key, bool) {
+	key, ok := ctx.Value(contextKey{}).(Key)
+	return key, ok
+}
+
+// KeyProtocolPrefix prefixes the API key offered as a websocket subprotocol, by browsers which cannot set
+// headers on a websocket handshake
+const KeyProtocolPrefix = "apikey."
+
+// Require wraps a handler so it is only served to requests presenting a key with the scope, rejected requests are
+// answered with WriteError. the key is read from the Authorization bearer token, the X-API-Key header or a subprotocol
+// offered in the Sec-WebSocket-Protocol header of a websocket handshake as KeyProtocolPrefix followed by the key
+func (a *Authenticator) Require(scope Scope, next http.Handler) http.Handler {
+	return a.RequireWith(scope, func(w http.ResponseWriter, _ *http.Request, status int, code, message string) {
+		WriteError(w, status, code, message)
+	}, next)
+}
+
+// RequireWith is Require answering rejected requests with writeError, so that a server sharing the authenticator
+// with others can use its own error envelope
+func (a *Authenticator) RequireWith(scope Scope, writeError ErrorWriter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := a.authenticate(r)
+		if err != nil {
+			writeError(w, r, http.StatusUnauthorized, "unauthorized", err.Error())
+			return
+		}
+		if !key.HasScope(scope) {
+			writeError(w, r, http.StatusForbidden, "forbidden", fmt.Sprintf("key lacks the %s scope", scope))
+			return
+		}
+		if retryAfter, ok := a.allow(key); !ok {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(retryAfter.Seconds())+1))
+			writeError(w, r, http.StatusTooManyRequests, "rate_limited", "request rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, key)))
+	})
+}
+
+// authenticate returns the key matching the one presented in the request
+func (a *Authenticator) authenticate(r *http.Request) (Key, error) {
+	presented := r.Header.Get("X-API-Key")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		presented = bearer
+	}
+	for _, protocol := range websocket.Protocols(r.Header) {
+		if key, ok := strings.CutPrefix(protocol, KeyProtocolPrefix); ok && presented == "" {
+			presented = key
+			break
+		}
+	}
+	if presented == "" {
+		return Key{}, errors.New("missing API key")
+	}
+	hash := HashKey(presented)
+	for _, key := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(key.Hash)) == 1 {
+			return key, nil
+		}
+	}
+	return Key{}, errors.New("invalid API key")
+}
+
+// allow takes a token from the key's bucket. if the bucket is empty it returns
+// false along with the time until the next token is available
+func (a *Authenticator) allow(key Key) (time.Duration, bool) {
+	if key.RateLimit <= 0 {
+		return 0, true
+	}
+	burst := float64(key.Burst)
+	if burst < 1 {
+		burst = max(key.RateLimit, 1)
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	now := a.now()
+	b, ok := a.buckets[key.ID]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		a.buckets[key.ID] = b
+	}
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*key.RateLimit)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / key.RateLimit * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// WriteError writes a JSON error response
+func WriteError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	response := struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+	response.Error.Code = code
+	response.Error.Message = message
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticator_Require(t *testing.T) {
+	keys := []Key{
+		{ID: "reader", Hash: HashKey("read-key"), Tenant: "acme", Scopes: []Scope{ScopeRead}},
+		{ID: "admin", Hash: HashKey("admin-key"), Tenant: "acme", Scopes: []Scope{ScopeAdmin}},
+	}
+	tests := []struct {
+		name       string
+		scope      Scope
+		headers    map[string]string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "Missing key", scope: ScopeRead, wantStatus: http.StatusUnauthorized, wantCode: "unauthorized"},
+		{name: "Invalid key", scope: ScopeRead, headers: map[string]string{"X-API-Key": "nope"}, wantStatus: http.StatusUnauthorized, wantCode: "unauthorized"},
+		{name: "Bearer key", scope: ScopeRead, headers: map[string]string{"Authorization": "Bearer read-key"}, wantStatus: http.StatusOK},
+		{name: "Header key", scope: ScopeRead, headers: map[string]string{"X-API-Key": "read-key"}, wantStatus: http.StatusOK},
+		{name: "Websocket protocol key", scope: ScopeRead, headers: map[string]string{"Sec-WebSocket-Protocol": "observer, apikey.read-key"}, wantStatus: http.StatusOK},
+		{name: "Invalid websocket protocol key", scope: ScopeRead, headers: map[string]string{"Sec-WebSocket-Protocol": "observer, apikey.nope"}, wantStatus: http.StatusUnauthorized, wantCode: "unauthorized"},
+		{name: "Missing scope", scope: ScopeSubscribe, headers: map[string]string{"X-API-Key": "read-key"}, wantStatus: http.StatusForbidden, wantCode: "forbidden"},
+		{name: "Admin has every scope", scope: ScopeSubscribe, headers: map[string]string{"X-API-Key": "admin-key"}, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAuthenticator(keys)
+			handler := a.Require(tt.scope, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				key, ok := KeyFromContext(r.Context())
+				assert.True(t, ok)
+				assert.Equal(t, "acme", key.Tenant)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantCode != "" {
+				var body struct {
+					Error struct {
+						Code string `json:"code"`
+					} `json:"error"`
+				}
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+				assert.Equal(t, tt.wantCode, body.Error.Code)
+				assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestAuthenticator_rateLimit(t *testing.T) {
+	a := NewAuthenticator([]Key{{ID: "k", Hash: HashKey("k"), Tenant: "acme", Scopes: []Scope{ScopeRead}, RateLimit: 1, Burst: 2}})
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+	handler := a.Require(ScopeRead, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-Key", "k")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	assert.Equal(t, http.StatusOK, serve().Code)
+	assert.Equal(t, http.StatusOK, serve().Code)
+	limited := serve()
+	assert.Equal(t, http.StatusTooManyRequests, limited.Code)
+	assert.Equal(t, "2", limited.Header().Get("Retry-After"))
+
+	now = now.Add(time.Second)
+	assert.Equal(t, http.StatusOK, serve().Code)
+}
+
+func TestKey_AllowsSubscription(t *testing.T) {
+	assert.True(t, Key{}.AllowsSubscription(1000))
+	assert.True(t, Key{MaxSubscriptions: 2}.AllowsSubscription(1))
+	assert.False(t, Key{MaxSubscriptions: 2}.AllowsSubscription(2))
+}
+
+func TestLoadKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "Valid", content: `[{"id":"a","hash":"` + HashKey("a") + `","tenant":"acme","scopes":["read"]}]`},
+		{name: "Plain text key", content: `[{"id":"a","hash":"a","tenant":"acme"}]`, wantErr: true},
+		{name: "Missing tenant", content: `[{"id":"a","hash":"` + HashKey("a") + `"}]`, wantErr: true},
+		{name: "Malformed", content: `[`, wantErr: true},
+		{
+			name:    "Duplicate ID",
+			content: `[{"id":"a","hash":"` + HashKey("a") + `","tenant":"acme"},{"id":"a","hash":"` + HashKey("b") + `","tenant":"globex"}]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys.json")
+			assert.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+			_, err := LoadKeys(path)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
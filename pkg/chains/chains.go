@@ -0,0 +1,167 @@
+// Package chains describes the EVM networks an observer process follows
+package chains
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Config is a chain to observe: its name, used by the API's chain parameter, the chain ID its endpoint must
+// report and the files its subscriptions, webhook outbox and known tokens are kept in
+type Config struct {
+	Name    string `json:"name"`
+	ChainId uint64 `json:"chainId"`
+	RPC     string `json:"rpc"`
+	// Subscriptions and Outbox default to <name>-subscriptions.json and <name>-outbox.json
+	Subscriptions string `json:"subscriptions,omitempty"`
+	Outbox        string `json:"outbox,omitempty"`
+	// Tokens is an optional JSON file of known ERC-20 tokens on the chain
+	Tokens string `json:"tokens,omitempty"`
+	// Stack is the client stack of the chain, which defaults to the stack of a well known chain ID
+	Stack Stack `json:"stack,omitempty"`
+	// Mempool enables following the pending transactions of the chain when set
+	Mempool *Mempool `json:"mempool,omitempty"`
+}
+
+// Mempool configures how the pending transactions of a chain are followed: over the client's websocket when
+// WebSocket is set, by polling txpool_content from the rpc endpoint otherwise
+type Mempool struct {
+	// WebSocket is the ws:// or wss:// URL of the client, subscribed to newPendingTransactions
+	WebSocket string `json:"websocket,omitempty"`
+	// PollInterval between txpool_content requests and websocket reconnection attempts, defaults to 2s
+	PollInterval Duration `json:"pollInterval,omitempty"`
+	// DropAfter is how long a transaction may go unseen before it is checked and reported dropped, defaults to 30m
+	DropAfter Duration `json:"dropAfter,omitempty"`
+}
+
+// Duration is a time.Duration read from a JSON string such as "2s" or "30m"
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"2s\": %w", err)
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if duration < 0 {
+		return fmt.Errorf("duration %q is negative", s)
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// MarshalJSON formats the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Stack is the family of clients a chain runs, which decides the transaction types and fee fields of its blocks
+type Stack string
+
+const (
+	// StackEthereum chains follow the transactions and fees of Ethereum mainnet
+	StackEthereum Stack = "ethereum"
+	// StackOP chains run the OP Stack: they add deposit transactions (type 0x7e) minting ETH bridged from L1, and an
+	// l1Fee paid on top of the L2 gas fee for the L1 data of every other transaction
+	StackOP Stack = "op-stack"
+	// StackArbitrum chains run Arbitrum Nitro: they add their own deposit, retryable and internal transaction types, and
+	// count the gas paying for L1 data in gasUsed, reporting it as gasUsedForL1
+	StackArbitrum Stack = "arbitrum"
+)
+
+// DefaultStack returns the stack of a well known chain ID, Ethereum for other chains
+func DefaultStack(chainId uint64) Stack {
+	switch chainId {
+	case Optimism, Base:
+		return StackOP
+	case Arbitrum:
+		return StackArbitrum
+	}
+	return StackEthereum
+}
+
+// Well known chain IDs
+const (
+	Mainnet  uint64 = 1
+	Optimism uint64 = 10
+	Polygon  uint64 = 137
+	Base     uint64 = 8453
+	Arbitrum uint64 = 42161
+	Sepolia  uint64 = 11155111
+)
+
+// namePattern matches the names accepted for a chain, which are used in URLs and file names
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Load reads the chains held in a JSON file, an array of Config, and fills in the default file names
+func Load(path string) ([]Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []Config
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, err
+	}
+	if err := Validate(configs); err != nil {
+		return nil, err
+	}
+	for i := range configs {
+		if configs[i].Subscriptions == "" {
+			configs[i].Subscriptions = configs[i].Name + "-subscriptions.json"
+		}
+		if configs[i].Outbox == "" {
+			configs[i].Outbox = configs[i].Name + "-outbox.json"
+		}
+		if configs[i].Stack == "" {
+			configs[i].Stack = DefaultStack(configs[i].ChainId)
+		}
+	}
+	return configs, nil
+}
+
+// Validate checks that there is at least one chain and that every chain has a valid name, a chain ID, an
+// endpoint, a known stack and a websocket URL for its mempool if any, with no two chains sharing a name or a chain ID
+func Validate(configs []Config) error {
+	if len(configs) == 0 {
+		return fmt.Errorf("no chains configured")
+	}
+	names := make(map[string]bool)
+	ids := make(map[uint64]bool)
+	for i, config := range configs {
+		if !namePattern.MatchString(config.Name) {
+			return fmt.Errorf("chain %d: name %q must be lowercase letters, digits and dashes", i, config.Name)
+		}
+		if config.ChainId == 0 {
+			return fmt.Errorf("chain %q: chainId is required", config.Name)
+		}
+		if config.RPC == "" {
+			return fmt.Errorf("chain %q: rpc is required", config.Name)
+		}
+		switch config.Stack {
+		case "", StackEthereum, StackOP, StackArbitrum:
+		default:
+			return fmt.Errorf("chain %q: stack must be ethereum, op-stack or arbitrum", config.Name)
+		}
+		if config.Mempool != nil && config.Mempool.WebSocket != "" &&
+			!strings.HasPrefix(config.Mempool.WebSocket, "ws://") && !strings.HasPrefix(config.Mempool.WebSocket, "wss://") {
+			return fmt.Errorf("chain %q: mempool websocket must be a ws:// or wss:// URL", config.Name)
+		}
+		if names[config.Name] {
+			return fmt.Errorf("chain %q: duplicate name", config.Name)
+		}
+		if ids[config.ChainId] {
+			return fmt.Errorf("chain %q: duplicate chainId %d", config.Name, config.ChainId)
+		}
+		names[config.Name], ids[config.ChainId] = true, true
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package chains
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	configs, err := Load(filepath.Join("testdata", "chains.json"))
+	require.NoError(t, err)
+	require.Len(t, configs, 2)
+	assert.Equal(t, Config{Name: "mainnet", ChainId: Mainnet, RPC: "https://cloudflare-eth.com", Subscriptions: "subscriptions.json", Outbox: "outbox.json", Stack: StackEthereum}, configs[0])
+	assert.Equal(t, Config{Name: "base", ChainId: Base, RPC: "https://mainnet.base.org", Subscriptions: "base-subscriptions.json", Outbox: "base-outbox.json", Tokens: "base-tokens.json", Stack: StackOP,
+		Mempool: &Mempool{WebSocket: "wss://mainnet.base.org/ws", DropAfter: Duration(10 * time.Minute)}}, configs[1])
+}
+
+func TestLoad_invalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"not json":       `{`,
+		"empty":          `[]`,
+		"bad name":       `[{"name": "Main Net", "chainId": 1, "rpc": "http://localhost:8545"}]`,
+		"missing id":     `[{"name": "mainnet", "rpc": "http://localhost:8545"}]`,
+		"missing rpc":    `[{"name": "mainnet", "chainId": 1}]`,
+		"unknown stack":  `[{"name": "zksync", "chainId": 324, "rpc": "http://localhost:8545", "stack": "zk"}]`,
+		"duplicate name": `[{"name": "mainnet", "chainId": 1, "rpc": "http://a"}, {"name": "mainnet", "chainId": 10, "rpc": "http://b"}]`,
+		"bad websocket":  `[{"name": "mainnet", "chainId": 1, "rpc": "http://a", "mempool": {"websocket": "http://a"}}]`,
+		"bad duration":   `[{"name": "mainnet", "chainId": 1, "rpc": "http://a", "mempool": {"pollInterval": "soon"}}]`,
+		"duplicate id":   `[{"name": "mainnet", "chainId": 1, "rpc": "http://a"}, {"name": "other", "chainId": 1, "rpc": "http://b"}]`,
+	} {
+		path := filepath.Join(t.TempDir(), "chains.json")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		_, err := Load(path)
+		assert.Error(t, err, name)
+	}
+	_, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestDefaultStack(t *testing.T) {
+	assert.Equal(t, StackEthereum, DefaultStack(Mainnet))
+	assert.Equal(t, StackEthereum, DefaultStack(Polygon))
+	assert.Equal(t, StackOP, DefaultStack(Optimism))
+	assert.Equal(t, StackOP, DefaultStack(Base))
+	assert.Equal(t, StackArbitrum, DefaultStack(Arbitrum))
+}
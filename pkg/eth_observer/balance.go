@@ -0,0 +1,169 @@
+package eth_observer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/aceagles/etherum_parser/pkg/units"
+)
+
+// errNotSubscribed is returned for balance reports of addresses without a subscription
+var errNotSubscribed = errors.New("address is not subscribed")
+
+// BalanceReport reconciles the balance of an address reported by the ethereum client with the balance reconstructed
+// from the records stored for it. amounts are decimal strings of wei
+type BalanceReport struct {
+	Address string `json:"address"`
+	// Block is the last processed block, the balances are read at its end. StartBlock is the first block the records cover
+	Block      int `json:"block"`
+	StartBlock int `json:"startBlock"`
+	// StartBalance is the balance at the end of the block before StartBlock
+	StartBalance string `json:"startBalance"`
+	// Inflow is the value received, Outflow the value sent and Fees the fees paid by successful and failed transactions.
+	// Withdrawals is the value of the beacon chain withdrawals, converted from gwei
+	Inflow      string `json:"inflow"`
+	Outflow     string `json:"outflow"`
+	Fees        string `json:"fees"`
+	Withdrawals string `json:"withdrawals"`
+	// Reconstructed is StartBalance plus Inflow and Withdrawals less Outflow and Fees
+	Reconstructed string `json:"reconstructed"`
+	Balance       string `json:"balance"`
+	BalanceEth    string `json:"balanceEth"`
+	// Discrepancy is Balance less Reconstructed. it is usually value moved by internal transfers of contracts,
+	// block rewards or blocks which were not read
+	Discrepancy string `json:"discrepancy"`
+	Reconciled  bool   `json:"reconciled"`
+}
+
+// GetBalance returns the balance of an address in wei at the last processed block
+func (e *EthereumObserver) GetBalance(address string) (*big.Int, error) {
+	return e.getBalance(strings.ToLower(address), e.GetCurrentBlock())
+}
+
+// BalanceReport reconciles the balance of a subscribed address with every record stored for it
+// since the earliest start block of its subscriptions, regardless of tenant
+func (e *EthereumObserver) BalanceReport(address string) (BalanceReport, error) {
+	subscriptions := e.ListSubscriptions(SubscriptionFilter{Address: address})
+	if len(subscriptions) == 0 {
+		return BalanceReport{}, errNotSubscribed
+	}
+	startBlock := subscriptions[0].StartBlock
+	for _, subscription := range subscriptions[1:] {
+		startBlock = min(startBlock, subscription.StartBlock)
+	}
+	return e.reconcileBalance(address, startBlock, e.GetTransactions(address), e.GetWithdrawals(address))
+}
+
+// BalanceReport reconciles the balance of an address the tenant is subscribed to with
+// the records the tenant can see, from the start block of its subscription
+func (t *Tenant) BalanceReport(address string) (BalanceReport, error) {
+	subscription, ok := t.GetSubscription(address)
+	if !ok {
+		return BalanceReport{}, errNotSubscribed
+	}
+	return t.observer.reconcileBalance(address, subscription.StartBlock, t.GetTransactions(address), t.GetWithdrawals(address))
+}
+
+// reconcileBalance reconstructs the balance of the address at the last processed block from its balance before the
+// start block and the records of the blocks in between, and compares it with the balance reported by the client
+func (e *EthereumObserver) reconcileBalance(address string, startBlock int, transactions []Transaction, withdrawals []Withdrawal) (BalanceReport, error) {
+	address = strings.ToLower(address)
+	block := e.GetCurrentBlock()
+	// a subscription starting after the last processed block has no records yet
+	startBlock = min(max(startBlock, 1), block+1)
+	startBalance, err := e.getBalance(address, startBlock-1)
+	if err != nil {
+		return BalanceReport{}, err
+	}
+	balance, err := e.getBalance(address, block)
+	if err != nil {
+		return BalanceReport{}, err
+	}
+
+	inRange := func(blockNumber string) bool {
+		number, err := parseHexInt(blockNumber)
+		return err == nil && number >= startBlock && number <= block
+	}
+	stack := e.Stack()
+	inflow, outflow, fees, withdrawn := new(big.Int), new(big.Int), new(big.Int), new(big.Int)
+	seen := make(map[string]bool)
+	for _, transaction := range transactions {
+		// self transfers are stored twice for the same address
+		if seen[transaction.Hash] || !inRange(transaction.BlockNumber) {
+			continue
+		}
+		seen[transaction.Hash] = true
+		// reverted transactions move no value but still pay their fee
+		succeeded := transaction.Status != "0x0"
+		if succeeded && strings.EqualFold(transaction.To, address) {
+			addWei(inflow, transaction.Value)
+		}
+		if strings.EqualFold(transaction.From, address) {
+			// the ETH bridged by a deposit is minted to its sender whether it reverts or not
+			if mint := minted(transaction, stack); mint != nil {
+				inflow.Add(inflow, mint)
+			}
+			if succeeded {
+				addWei(outflow, transaction.Value)
+			}
+			addWei(fees, transaction.Fee)
+		}
+	}
+	for _, withdrawal := range withdrawals {
+		if inRange(withdrawal.BlockNumber) {
+			if amount, err := parseWei(withdrawal.Amount); err == nil && amount != nil {
+				withdrawn.Add(withdrawn, units.GweiToWei(amount))
+			}
+		}
+	}
+
+	reconstructed := new(big.Int).Add(startBalance, inflow)
+	reconstructed.Add(reconstructed, withdrawn)
+	reconstructed.Sub(reconstructed, outflow)
+	reconstructed.Sub(reconstructed, fees)
+	discrepancy := new(big.Int).Sub(balance, reconstructed)
+	return BalanceReport{
+		Address:       address,
+		Block:         block,
+		StartBlock:    startBlock,
+		StartBalance:  startBalance.String(),
+		Inflow:        inflow.String(),
+		Outflow:       outflow.String(),
+		Fees:          fees.String(),
+		Withdrawals:   withdrawn.String(),
+		Reconstructed: reconstructed.String(),
+		Balance:       balance.String(),
+		BalanceEth:    units.FormatEther(balance),
+		Discrepancy:   discrepancy.String(),
+		Reconciled:    discrepancy.Sign() == 0,
+	}, nil
+}
+
+// getBalance returns the balance of an address in wei at the end of a block
+func (e *EthereumObserver) getBalance(address string, blockNum int) (*big.Int, error) {
+	balanceReq := EthRequestStruct{
+		Jsonrpc: "2.0",
+		Method:  "eth_getBalance",
+		Params:  []interface{}{address, fmt.Sprintf("0x%x", blockNum)},
+		Id:      0,
+	}
+
+	response, err := e.QueryEthClient(balanceReq)
+	if err != nil {
+		return nil, err
+	}
+
+	var balance string
+	err = json.Unmarshal(response.Result, &balance)
+	if err != nil {
+		return nil, err
+	}
+	wei, err := parseWei(balance)
+	if err != nil || wei == nil {
+		return nil, fmt.Errorf("invalid balance %q", balance)
+	}
+	return wei, nil
+}
@@ -0,0 +1,96 @@
+package eth_observer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newBalanceServer answers eth_getBalance with the balances by block number
+func newBalanceServer(t *testing.T, balances map[string]string) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req EthRequestStruct
+		json.NewDecoder(r.Body).Decode(&req)
+		balance, ok := balances[req.Params[1].(string)]
+		if req.Method != "eth_getBalance" || !ok {
+			json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Error: &EthErrorStruct{Code: -32000, Message: "missing trie node"}})
+			return
+		}
+		json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Result: []byte(`"` + balance + `"`)})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestEthereumObserver_BalanceReport(t *testing.T) {
+	const (
+		address = "0x00000000000000000000000000000000000000aa"
+		other   = "0x00000000000000000000000000000000000000bb"
+	)
+	balances := map[string]string{"0x0": "0x3e8", "0x1": "0x44c", "0x3": "0x77359811"}
+	store := fakeStore{address: {
+		{Hash: "0xa", From: other, To: address, Value: "0x64", Status: "0x1", BlockNumber: "0x1"},
+		{Hash: "0xb", From: address, To: other, Value: "0x32", Fee: "0x5", Status: "0x1", BlockNumber: "0x2"},
+		// reverted, only the fee is paid
+		{Hash: "0xc", From: address, To: other, Value: "0x10", Fee: "0x3", Status: "0x0", BlockNumber: "0x2"},
+		// a self transfer is stored twice, it is counted once both ways
+		{Hash: "0xd", From: address, To: address, Value: "0x7", Fee: "0x1", Status: "0x1", BlockNumber: "0x3"},
+		{Hash: "0xd", From: address, To: address, Value: "0x7", Fee: "0x1", Status: "0x1", BlockNumber: "0x3"},
+		// after the last processed block
+		{Hash: "0xe", From: other, To: address, Value: "0x1000", Status: "0x1", BlockNumber: "0x4"},
+	}}
+	withdrawals := fakeWithdrawalsStore{address: {{Index: "0x1", Address: address, Amount: "0x2", BlockNumber: "0x3"}}}
+	e := NewEthereumObserver(newBalanceServer(t, balances).URL, store)
+	e.UseWithdrawalsStore(withdrawals)
+	e.Tenant("acme").Subscribe(address)
+	e.Tenant("globex").AddSubscription(Subscription{Address: address, StartBlock: 2})
+	e.updateLatestBlock(3)
+
+	balance, err := e.GetBalance(address)
+	require.NoError(t, err)
+	assert.Equal(t, "2000001041", balance.String())
+
+	report, err := e.BalanceReport(address)
+	require.NoError(t, err)
+	assert.Equal(t, BalanceReport{
+		Address:       address,
+		Block:         3,
+		StartBlock:    1,
+		StartBalance:  "1000",
+		Inflow:        "107",
+		Outflow:       "57",
+		Fees:          "9",
+		Withdrawals:   "2000000000",
+		Reconstructed: "2000001041",
+		Balance:       "2000001041",
+		BalanceEth:    "0.000000002000001041",
+		Discrepancy:   "0",
+		Reconciled:    true,
+	}, report)
+
+	// the tenant view starts from its own subscription
+	report, err = e.Tenant("globex").BalanceReport(address)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.StartBlock)
+	assert.Equal(t, "1100", report.StartBalance)
+	assert.True(t, report.Reconciled)
+
+	// an internal transfer the records do not show
+	balances["0x3"] = "0x7735981b"
+	report, err = e.BalanceReport(address)
+	require.NoError(t, err)
+	assert.Equal(t, "10", report.Discrepancy)
+	assert.False(t, report.Reconciled)
+
+	_, err = e.Tenant("initech").BalanceReport(address)
+	assert.ErrorIs(t, err, errNotSubscribed)
+	_, err = e.BalanceReport(other)
+	assert.ErrorIs(t, err, errNotSubscribed)
+	delete(balances, "0x0")
+	_, err = e.BalanceReport(address)
+	assert.Error(t, err)
+}
@@ -0,0 +1,159 @@
+package eth_observer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"strings"
+
+	"github.com/aceagles/etherum_parser/pkg/keccak"
+)
+
+// bloomSize is the size in bytes of the 2048 bit logs bloom of a block header
+const bloomSize = 256
+
+// bloomBits are the three bits an item sets in a logs bloom
+type bloomBits [3]uint16
+
+// addressBloom holds the bloom bits of a subscribed address, both as the address of a contract emitting logs
+// and as an indexed topic, where addresses are left padded to 32 bytes
+type addressBloom struct {
+	address bloomBits
+	topic   bloomBits
+}
+
+// newBloomBits returns the bits set for an item: the low 11 bits of each of the first three pairs of bytes of its hash
+func newBloomBits(item []byte) bloomBits {
+	hash := keccak.Sum256(item)
+	var bits bloomBits
+	for i := range bits {
+		bits[i] = (uint16(hash[2*i])<<8 | uint16(hash[2*i+1])) & (bloomSize*8 - 1)
+	}
+	return bits
+}
+
+// newAddressBloom returns the bloom bits of an address. it returns false if the address is not 20 bytes of hex
+func newAddressBloom(address string) (addressBloom, bool) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(address, "0x"))
+	if err != nil || len(raw) != 20 {
+		return addressBloom{}, false
+	}
+	topic := make([]byte, 32)
+	copy(topic[12:], raw)
+	return addressBloom{address: newBloomBits(raw), topic: newBloomBits(topic)}, true
+}
+
+// in reports whether every bit is set in the bloom. bits are counted from the last byte of the bloom
+func (bits bloomBits) in(bloom []byte) bool {
+	for _, bit := range bits {
+		if bloom[bloomSize-1-int(bit/8)]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// mayContain reports whether the block may hold logs emitted by the address or carrying it as a topic.
+// false positives are possible, false negatives are not
+func (b addressBloom) mayContain(bloom []byte) bool {
+	return b.address.in(bloom) || b.topic.in(bloom)
+}
+
+// parseBloom decodes the logs bloom of a block header. it returns false if the bloom is missing or malformed
+func parseBloom(logsBloom string) ([]byte, bool) {
+	bloom, err := hex.DecodeString(strings.TrimPrefix(logsBloom, "0x"))
+	if err != nil || len(bloom) != bloomSize {
+		return nil, false
+	}
+	return bloom, true
+}
+
+// blockLog is a log returned by eth_getLogs, along with the transaction which emitted it
+type blockLog struct {
+	Log
+	TransactionHash string `json:"transactionHash"`
+}
+
+// getLogs returns the logs of a block. the block hash is used when known so that the logs match the block read
+func (e *EthereumObserver) getLogs(blk block) ([]blockLog, error) {
+	filter := map[string]string{"fromBlock": blk.Number, "toBlock": blk.Number}
+	if blk.Hash != "" {
+		filter = map[string]string{"blockHash": blk.Hash}
+	}
+	logsReq := EthRequestStruct{
+		Jsonrpc: "2.0",
+		Method:  "eth_getLogs",
+		Params:  []interface{}{filter},
+		Id:      0,
+	}
+
+	response, err := e.QueryEthClient(logsReq)
+	if err != nil {
+		return nil, err
+	}
+
+	var logs []blockLog
+	err = json.Unmarshal(response.Result, &logs)
+	if err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// mayHaveLogs reports whether the block may hold logs involving an address subscribed to with MatchLogs according to
+// its logs bloom. blocks without a valid bloom may hold logs for any such address
+func (e *EthereumObserver) mayHaveLogs(blk block) bool {
+	bloom, _ := parseBloom(blk.LogsBloom)
+	return e.subscriptions.mayBeInBloom(bloom)
+}
+
+// matchLogs adds to transactionsByAddress the transactions of the block whose logs were emitted by an address
+// subscribed to with MatchLogs or carry one as an indexed topic, such as the sender or recipient of an ERC-20 transfer.
+// the logs of the block are only fetched when its logs bloom shows such an address may be involved
+func (e *EthereumObserver) matchLogs(blk block, transactionsByAddress map[string][]Transaction) error {
+	if !e.mayHaveLogs(blk) {
+		e.metrics.bloomSkipped.Add(1)
+		return nil
+	}
+	e.metrics.logFetches.Add(1)
+	logs, err := e.getLogs(blk)
+	if err != nil {
+		return err
+	}
+
+	transactions := make(map[string]Transaction, len(blk.Transactions))
+	for _, transaction := range blk.Transactions {
+		transactions[transaction.Hash] = transaction
+	}
+	for _, log := range logs {
+		involved := []string{strings.ToLower(log.Address)}
+		for _, topic := range log.Topics[min(1, len(log.Topics)):] {
+			if strings.HasPrefix(topic, "0x000000000000000000000000") && len(topic) == 66 {
+				involved = append(involved, "0x"+strings.ToLower(topic[26:]))
+			}
+		}
+		for _, address := range involved {
+			if !e.subscriptions.matchesLogs(address) {
+				continue
+			}
+			transaction, ok := transactions[log.TransactionHash]
+			if !ok || containsTransaction(transactionsByAddress[address], transaction.Hash) {
+				continue
+			}
+			transaction.MatchedByLogs = true
+			transactionsByAddress[address] = append(transactionsByAddress[address], transaction)
+			slog.Debug("Transaction added from log", "transaction", transaction.Hash, "address", address)
+		}
+	}
+	return nil
+}
+
+// containsTransaction reports whether a transaction with the hash is in the list
+func containsTransaction(transactions []Transaction, hash string) bool {
+	for _, transaction := range transactions {
+		if transaction.Hash == hash {
+			return true
+		}
+	}
+	return false
+}
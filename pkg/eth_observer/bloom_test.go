@@ -0,0 +1,132 @@
+package eth_observer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bloomOf returns the hex logs bloom of a block whose logs carry the addresses as topics
+func bloomOf(t *testing.T, addresses ...string) string {
+	bloom := make([]byte, bloomSize)
+	for _, address := range addresses {
+		bits, ok := newAddressBloom(address)
+		require.True(t, ok)
+		for _, bit := range bits.topic {
+			bloom[bloomSize-1-int(bit/8)] |= 1 << (bit % 8)
+		}
+	}
+	return "0x" + hex.EncodeToString(bloom)
+}
+
+func TestBloomBits_in(t *testing.T) {
+	bloom := make([]byte, bloomSize)
+	bloom[bloomSize-1] = 0x01
+	bloom[0] = 0x80
+	assert.True(t, bloomBits{0, 0, 2047}.in(bloom), "bit 0 is the low bit of the last byte, bit 2047 the high bit of the first")
+	assert.False(t, bloomBits{0, 1, 2047}.in(bloom))
+
+	const address = "0x00000000000000000000000000000000000000aa"
+	bits, ok := newAddressBloom(address)
+	require.True(t, ok)
+	parsed, ok := parseBloom(bloomOf(t, address))
+	require.True(t, ok)
+	assert.True(t, bits.mayContain(parsed))
+	empty, ok := parseBloom(bloomOf(t))
+	require.True(t, ok)
+	assert.False(t, bits.mayContain(empty))
+
+	_, ok = newAddressBloom("0xaa")
+	assert.False(t, ok)
+	_, ok = parseBloom("0x00")
+	assert.False(t, ok)
+}
+
+func TestEthereumObserver_matchLogs(t *testing.T) {
+	const (
+		address = "0x00000000000000000000000000000000000000aa"
+		sender  = "0x00000000000000000000000000000000000000cc"
+		token   = "0x00000000000000000000000000000000000000dd"
+	)
+	blocks := map[string]string{
+		"0x1": `{"number":"0x1","hash":"0xb1","logsBloom":"` + bloomOf(t) + `","transactions":[
+			{"hash":"0xa","from":"` + sender + `","to":"` + token + `","blockNumber":"0x1"}]}`,
+		"0x2": `{"number":"0x2","hash":"0xb2","logsBloom":"` + bloomOf(t, sender, address) + `","transactions":[
+			{"hash":"0xb","from":"` + sender + `","to":"` + token + `","blockNumber":"0x2"},
+			{"hash":"0xc","from":"` + sender + `","to":"` + token + `","blockNumber":"0x2"},
+			{"hash":"0xd","from":"` + address + `","to":"` + token + `","blockNumber":"0x2"}]}`,
+	}
+	var logFetches atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req EthRequestStruct
+		json.NewDecoder(r.Body).Decode(&req)
+		var result string
+		switch req.Method {
+		case "eth_getLogs":
+			logFetches.Add(1)
+			assert.Equal(t, map[string]interface{}{"blockHash": "0xb2"}, req.Params[0])
+			result = `[{"address":"` + token + `","transactionHash":"0xb","logIndex":"0x0","data":"0x",
+				"topics":["0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+					"0x000000000000000000000000` + sender[2:] + `","0x000000000000000000000000` + strings.ToUpper(address[2:]) + `"]}]`
+		case "eth_getTransactionReceipt":
+			result = `{"transactionHash":"` + req.Params[0].(string) + `","status":"0x1","gasUsed":"0x5208"}`
+		default:
+			result = blocks[req.Params[0].(string)]
+		}
+		json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Result: []byte(result)})
+	}))
+	defer ts.Close()
+
+	// without a subscription matching logs they are never fetched, and only the transaction sent by the address is matched
+	store := fakeStore{}
+	e := NewEthereumObserver(ts.URL, store)
+	e.Tenant("globex").Subscribe(address)
+	e.UpdateTransactions(2)
+	assert.Zero(t, logFetches.Load())
+	require.Len(t, store[address], 1)
+	assert.Equal(t, "0xd", store[address][0].Hash)
+
+	store = fakeStore{}
+	e = NewEthereumObserver(ts.URL, store)
+	e.Tenant("globex").Subscribe(address)
+	assert.True(t, e.Tenant("acme").AddSubscription(Subscription{Address: address, MatchLogs: true}))
+	e.UpdateTransactions(1)
+	assert.Zero(t, logFetches.Load(), "logs are not fetched when the bloom rules out every subscribed address")
+	e.UpdateTransactions(2)
+	assert.EqualValues(t, 1, logFetches.Load())
+
+	transactions := store[address]
+	require.Len(t, transactions, 2, "only the transactions sent by the address or whose log carries it are matched")
+	assert.Equal(t, "0xd", transactions[0].Hash)
+	assert.False(t, transactions[0].MatchedByLogs)
+	assert.Equal(t, "0xb", transactions[1].Hash)
+	assert.True(t, transactions[1].MatchedByLogs)
+	assert.Equal(t, "0x1", transactions[1].Status, "transactions matched by their logs have their receipt read")
+
+	// the transaction matched by its log is only seen by the subscription matching logs
+	hashes := func(transactions []Transaction) []string {
+		var hashes []string
+		for _, transaction := range transactions {
+			hashes = append(hashes, transaction.Hash)
+		}
+		return hashes
+	}
+	assert.Equal(t, []string{"0xd", "0xb"}, hashes(e.Tenant("acme").GetTransactions(address)))
+	assert.Equal(t, []string{"0xd"}, hashes(e.Tenant("globex").GetTransactions(address)))
+	_, visible := e.Tenant("globex").Visible(address, transactions[1])
+	assert.False(t, visible)
+
+	metrics := e.Metrics()
+	assert.Equal(t, 2, metrics.LatestBlock)
+	assert.EqualValues(t, 2, metrics.BlocksRead)
+	assert.EqualValues(t, 1, metrics.BloomSkipped)
+	assert.EqualValues(t, 1, metrics.LogFetches)
+	assert.Equal(t, 0.5, metrics.BloomSkipRate)
+}
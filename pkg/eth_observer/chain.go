@@ -0,0 +1,61 @@
+package eth_observer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aceagles/etherum_parser/pkg/chains"
+)
+
+// GetChainId returns the ID of the chain served by the ethereum client
+func (e *EthereumObserver) GetChainId() (uint64, error) {
+	chainIdReq := EthRequestStruct{
+		Jsonrpc: "2.0",
+		Method:  "eth_chainId",
+		Id:      0,
+	}
+
+	response, err := e.QueryEthClient(chainIdReq)
+	if err != nil {
+		return 0, err
+	}
+
+	var chainId string
+	err = json.Unmarshal(response.Result, &chainId)
+	if err != nil {
+		return 0, err
+	}
+	if !strings.HasPrefix(chainId, "0x") {
+		return 0, fmt.Errorf("invalid chain ID %q", chainId)
+	}
+	return strconv.ParseUint(chainId[2:], 16, 64)
+}
+
+// VerifyChain checks that the ethereum client serves the chain with the ID expected, so that an endpoint configured
+// for the wrong network is refused at startup rather than mixing its records with another chain's, and records the ID.
+// the stack of a well known chain is used unless UseStack set one
+func (e *EthereumObserver) VerifyChain(chainId uint64) error {
+	actual, err := e.GetChainId()
+	if err != nil {
+		return fmt.Errorf("reading chain ID: %w", err)
+	}
+	if actual != chainId {
+		return fmt.Errorf("endpoint serves chain %d, expected chain %d", actual, chainId)
+	}
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.chainId = chainId
+	if e.stack == "" {
+		e.stack = chains.DefaultStack(chainId)
+	}
+	return nil
+}
+
+// ChainId returns the ID of the chain observed once checked by VerifyChain, or 0
+func (e *EthereumObserver) ChainId() uint64 {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.chainId
+}
@@ -0,0 +1,43 @@
+package eth_observer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aceagles/etherum_parser/pkg/chains"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEthereumObserver_VerifyChain(t *testing.T) {
+	result := `"0x2105"`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req EthRequestStruct
+		json.NewDecoder(r.Body).Decode(&req)
+		assert.Equal(t, "eth_chainId", req.Method)
+		json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Result: []byte(result)})
+	}))
+	defer ts.Close()
+
+	e := NewEthereumObserver(ts.URL, fakeStore{})
+	chainId, err := e.GetChainId()
+	require.NoError(t, err)
+	assert.EqualValues(t, 8453, chainId)
+
+	assert.EqualError(t, e.VerifyChain(1), "endpoint serves chain 8453, expected chain 1")
+	assert.Zero(t, e.ChainId(), "the chain ID is only recorded once verified")
+	assert.Equal(t, chains.StackEthereum, e.Stack())
+	require.NoError(t, e.VerifyChain(8453))
+	assert.EqualValues(t, 8453, e.ChainId())
+	assert.Equal(t, chains.StackOP, e.Stack(), "well known chains default to their stack")
+
+	e = NewEthereumObserver(ts.URL, fakeStore{})
+	e.UseStack(chains.StackEthereum)
+	require.NoError(t, e.VerifyChain(8453))
+	assert.Equal(t, chains.StackEthereum, e.Stack(), "a stack set is kept")
+
+	result = `"8453"`
+	assert.Error(t, e.VerifyChain(8453))
+}
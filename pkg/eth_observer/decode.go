@@ -0,0 +1,57 @@
+package eth_observer
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/aceagles/etherum_parser/pkg/abi"
+)
+
+// UseABIs decodes the contract calls and event logs of the transactions matched from now on with the registry.
+// transactions already stored are not decoded
+func (e *EthereumObserver) UseABIs(registry *abi.Registry) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.abis = registry
+}
+
+// decodeTransactions annotates the input and logs of the matched transactions with the signatures of the built-in
+// table and decodes them with the ABI registry
+func (e *EthereumObserver) decodeTransactions(transactionsByAddress map[string][]Transaction) {
+	e.mux.Lock()
+	registry := e.abis
+	e.mux.Unlock()
+
+	for _, transactions := range transactionsByAddress {
+		for i := range transactions {
+			transaction := &transactions[i]
+			transaction.Method = abi.GuessMethod(transaction.Input)
+			for j := range transaction.Logs {
+				if log := &transaction.Logs[j]; len(log.Topics) > 0 {
+					log.Event = abi.GuessEvent(log.Topics[0])
+				}
+			}
+			if registry == nil {
+				continue
+			}
+
+			if call, err := registry.DecodeCall(transaction.To, transaction.Input); err == nil {
+				transaction.Decoded = call
+			} else if !errors.Is(err, abi.ErrUnknown) {
+				slog.Debug("Failed to decode transaction input", "hash", transaction.Hash, "error", err)
+			}
+			for j := range transaction.Logs {
+				// the logs are shared with the copy of the transaction matched for its other address
+				log := &transaction.Logs[j]
+				if log.Decoded != nil {
+					continue
+				}
+				if event, err := registry.DecodeLog(log.Address, log.Topics, log.Data); err == nil {
+					log.Decoded = event
+				} else if !errors.Is(err, abi.ErrUnknown) {
+					slog.Debug("Failed to decode log", "hash", transaction.Hash, "log", log.LogIndex, "error", err)
+				}
+			}
+		}
+	}
+}
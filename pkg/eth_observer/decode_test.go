@@ -0,0 +1,79 @@
+package eth_observer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aceagles/etherum_parser/pkg/abi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEthereumObserver_UseABIs(t *testing.T) {
+	const (
+		sender = "0x00000000000000000000000000000000000000aa"
+		token  = "0x00000000000000000000000000000000000000cc"
+		word   = "00000000000000000000000000000000000000000000000000000000000000"
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req EthRequestStruct
+		json.NewDecoder(r.Body).Decode(&req)
+		result := `{"number":"0x1","transactions":[{"hash":"0xa","from":"` + sender + `","to":"` + token + `","blockNumber":"0x1",
+			"input":"0xa9059cbb` + word + `bb` + word + `05"}]}`
+		if req.Method == "eth_getTransactionReceipt" {
+			result = `{"transactionHash":"0xa","status":"0x1","logs":[{"address":"` + token + `","logIndex":"0x0",
+				"topics":["0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef","0x` + word + `aa","0x` + word + `bb"],
+				"data":"0x` + word + `05"},{"address":"` + token + `","logIndex":"0x1","topics":["0x01"],"data":"0x"}]}`
+		}
+		if req.Method == "eth_getLogs" {
+			result = `[]`
+		}
+		json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Result: []byte(result)})
+	}))
+	defer ts.Close()
+
+	erc20, err := abi.Parse([]byte(`[
+		{"type": "function", "name": "transfer", "inputs": [{"name": "to", "type": "address"}, {"name": "amount", "type": "uint256"}]},
+		{"type": "event", "name": "Transfer", "inputs": [{"name": "from", "type": "address", "indexed": true}, {"name": "to", "type": "address", "indexed": true}, {"name": "value", "type": "uint256"}]}
+	]`))
+	require.NoError(t, err)
+	registry := abi.NewRegistry()
+	registry.AddContract(token, erc20)
+
+	store := fakeStore{}
+	e := NewEthereumObserver(ts.URL, store)
+	e.Subscribe(sender)
+	e.UseABIs(registry)
+	e.UpdateTransactions(1)
+
+	transactions := e.GetTransactions(sender)
+	require.Len(t, transactions, 1)
+	require.NotNil(t, transactions[0].Decoded)
+	assert.Equal(t, "transfer(address,uint256)", transactions[0].Decoded.Signature)
+	assert.Equal(t, "5", transactions[0].Decoded.Args[1].Value)
+	require.Len(t, transactions[0].Logs, 2)
+	assert.Equal(t, "Transfer", transactions[0].Logs[0].Decoded.Event)
+	assert.Nil(t, transactions[0].Logs[1].Decoded, "unknown events are left undecoded")
+	assert.Equal(t, &abi.Guess{Signature: "transfer(address,uint256)"}, transactions[0].Method)
+	assert.Equal(t, &abi.Guess{Signature: "Transfer(address,address,uint256)"}, transactions[0].Logs[0].Event)
+	assert.Nil(t, transactions[0].Logs[1].Event)
+}
+
+func TestEthereumObserver_decodeTransactions_withoutABIs(t *testing.T) {
+	e := NewEthereumObserver("", fakeStore{})
+	transactions := map[string][]Transaction{"0x1": {
+		{Hash: "0xa", Input: "0x42966c68"},
+		{Hash: "0xb", Input: "0x"},
+	}}
+	e.decodeTransactions(transactions)
+
+	assert.Nil(t, transactions["0x1"][0].Decoded)
+	assert.Equal(t, &abi.Guess{
+		Signature:  "burn(uint256)",
+		Ambiguous:  true,
+		Candidates: []string{"burn(uint256)", "collate_propagate_storage(bytes16)"},
+	}, transactions["0x1"][0].Method)
+	assert.Nil(t, transactions["0x1"][1].Method)
+}
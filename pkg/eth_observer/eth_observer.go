@@ -12,6 +12,10 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/aceagles/etherum_parser/pkg/abi"
+	"github.com/aceagles/etherum_parser/pkg/chains"
+	"github.com/aceagles/etherum_parser/pkg/tokens"
 )
 
 // Parser interface for parsing ethereum transactions
@@ -45,10 +49,102 @@ type Transaction struct {
 	R                    string        `json:"r"`
 	S                    string        `json:"s"`
 	YParity              string        `json:"yParity"`
+
+	// MaxFeePerBlobGas and BlobVersionedHashes are set on EIP-4844 blob transactions (type 0x3)
+	MaxFeePerBlobGas    string   `json:"maxFeePerBlobGas,omitempty"`
+	BlobVersionedHashes []string `json:"blobVersionedHashes,omitempty"`
+	// AuthorizationList is set on EIP-7702 set code transactions (type 0x4)
+	AuthorizationList []Authorization `json:"authorizationList,omitempty"`
+	// SourceHash, Mint and IsSystemTx are set on OP Stack deposit transactions (type 0x7e). Mint is the ETH bridged
+	// from L1 and credited to the sender
+	SourceHash string `json:"sourceHash,omitempty"`
+	Mint       string `json:"mint,omitempty"`
+	IsSystemTx bool   `json:"isSystemTx,omitempty"`
+	// RequestId is set on Arbitrum transactions sent from L1 and TicketId on the retries of retryable tickets
+	RequestId string `json:"requestId,omitempty"`
+	TicketId  string `json:"ticketId,omitempty"`
+
+	// Status, GasUsed, EffectiveGasPrice, BlobGasUsed and BlobGasPrice are read from the receipt of a matched transaction
+	Status            string `json:"status,omitempty"`
+	GasUsed           string `json:"gasUsed,omitempty"`
+	EffectiveGasPrice string `json:"effectiveGasPrice,omitempty"`
+	BlobGasUsed       string `json:"blobGasUsed,omitempty"`
+	BlobGasPrice      string `json:"blobGasPrice,omitempty"`
+	Logs              []Log  `json:"logs,omitempty"`
+	L2Fields
+	// Fee is the total fee paid in wei, the execution gas plus the blob gas of blob transactions and the L1 fee of
+	// OP Stack chains. it is split into the base fee burnt, the priority fee paid to the block builder, the blob fee,
+	// which is burnt as well, and the L1 fee
+	Fee         string `json:"fee,omitempty"`
+	BurntFee    string `json:"burntFee,omitempty"`
+	PriorityFee string `json:"priorityFee,omitempty"`
+	BlobFee     string `json:"blobFee,omitempty"`
+	// Timestamp is the time of the block the transaction was mined in, in seconds
+	Timestamp string `json:"timestamp,omitempty"`
+
+	// Decoded is the contract call decoded with the ABIs given to UseABIs
+	Decoded *abi.Call `json:"decoded,omitempty"`
+	// Method is the best guess of the method called, looked up by selector in the built-in signature table
+	Method *abi.Guess `json:"method,omitempty"`
+
+	// Subscription holds the metadata of the subscription the transaction was matched for
+	Subscription *SubscriptionMetadata `json:"subscription,omitempty"`
+	// Rules holds the names of the subscription's rules which fired for the transaction
+	Rules []string `json:"rules,omitempty"`
+	// MatchedByLogs is set when the transaction was matched for the address through its logs alone, it is only seen by
+	// subscriptions with MatchLogs
+	MatchedByLogs bool `json:"matchedByLogs,omitempty"`
+}
+
+// Log is an event emitted by a transaction, read from its receipt
+type Log struct {
+	Address  string   `json:"address"`
+	Topics   []string `json:"topics"`
+	Data     string   `json:"data"`
+	LogIndex string   `json:"logIndex"`
+
+	// Decoded is the event decoded with the ABIs given to UseABIs
+	Decoded *abi.DecodedEvent `json:"decoded,omitempty"`
+	// Event is the best guess of the event, looked up by its first topic in the built-in signature table
+	Event *abi.Guess `json:"event,omitempty"`
+}
+
+// Authorization is an entry of the authorization list of a set code transaction, delegating
+// the code of the signing account to the contract at Address
+type Authorization struct {
+	ChainId string `json:"chainId"`
+	Address string `json:"address"`
+	Nonce   string `json:"nonce"`
+	YParity string `json:"yParity"`
+	R       string `json:"r"`
+	S       string `json:"s"`
+}
+
+type receipt struct {
+	TransactionHash   string `json:"transactionHash"`
+	Status            string `json:"status"`
+	GasUsed           string `json:"gasUsed"`
+	EffectiveGasPrice string `json:"effectiveGasPrice"`
+	BlobGasUsed       string `json:"blobGasUsed"`
+	BlobGasPrice      string `json:"blobGasPrice"`
+	Logs              []Log  `json:"logs"`
+	L2Fields
 }
 
 type block struct {
-	Transactions []Transaction `json:"transactions"`
+	Number        string `json:"number"`
+	Hash          string `json:"hash"`
+	ParentHash    string `json:"parentHash"`
+	Timestamp     string `json:"timestamp"`
+	BaseFeePerGas string `json:"baseFeePerGas"`
+	// LogsBloom is the bloom filter of the addresses and topics of every log in the block
+	LogsBloom string `json:"logsBloom"`
+	// BlobGasUsed and ExcessBlobGas are set from the Cancun upgrade on
+	BlobGasUsed   string        `json:"blobGasUsed"`
+	ExcessBlobGas string        `json:"excessBlobGas"`
+	Transactions  []Transaction `json:"transactions"`
+	// Withdrawals are set from the Shanghai upgrade on
+	Withdrawals []Withdrawal `json:"withdrawals"`
 }
 type EthRequestStruct struct {
 	Jsonrpc string        `json:"jsonrpc"`
@@ -75,19 +171,37 @@ type TransactionsStore interface {
 
 type EthereumObserver struct {
 	endpoint          string
+	chainId           uint64       // ID of the chain served by the endpoint, set by VerifyChain
+	stack             chains.Stack // client stack of the chain, deciding its transaction types and fees
 	mux               sync.Mutex
 	latestBlock       int
 	blocksToRead      map[int]struct{}
-	subscribedAddress map[string]struct{}
+	subscriptions     subscriptionSet
+	subscribeMux      sync.Mutex     // serialises subscription writes and guards registry and keySubscriptions, so disk I/O never holds mux
+	keySubscriptions  map[string]int // number of subscriptions held by each API key, by owner and key ID
 	transactionsStore TransactionsStore
+	withdrawalsStore  WithdrawalsStore
+	registry          SubscriptionRegistry
+	abis              *abi.Registry
+	tokens            *tokens.Registry
+	lookedUpTokens    *tokens.Registry // tokens read with eth_call, kept apart from the known tokens listed by TokenBalances
+	events            eventBus
+	mempool           mempool
+	stalls            stalls
+	head              int            // latest block number reported by the ethereum client
+	lag               int            // blocks between head and latestBlock when last reported
+	blockHashes       map[int]string // hashes of recently processed blocks, used to detect reorgs
+	metrics           metrics
 }
 
+// reorgWindow is the number of processed block hashes kept to detect reorgs
+const reorgWindow = 128
+
 func NewEthereumObserver(endpoint string, txStore TransactionsStore) *EthereumObserver {
 	return &EthereumObserver{
 		endpoint:          endpoint,
 		latestBlock:       0,
 		blocksToRead:      make(map[int]struct{}),
-		subscribedAddress: make(map[string]struct{}),
 		transactionsStore: txStore,
 	}
 }
@@ -161,6 +275,15 @@ func (e *EthereumObserver) GetBlockNumber() (string, error) {
 // GetBlockByNumber returns a list of transactions in a block given the block number
 // transactions are returned as a list of Transaction structs. blockNum is a hex string
 func (e *EthereumObserver) GetBlockByNumber(blockNum string) ([]Transaction, error) {
+	blk, err := e.getBlock(blockNum)
+	if err != nil {
+		return nil, err
+	}
+	return blk.Transactions, nil
+}
+
+// getBlock returns the block with its transactions given the block number as a hex string
+func (e *EthereumObserver) getBlock(blockNum string) (block, error) {
 	blockNumReq := EthRequestStruct{
 		Jsonrpc: "2.0",
 		Method:  "eth_getBlockByNumber",
@@ -170,25 +293,82 @@ func (e *EthereumObserver) GetBlockByNumber(blockNum string) ([]Transaction, err
 
 	response, err := e.QueryEthClient(blockNumReq)
 	if err != nil {
-		return nil, err
+		return block{}, err
 	}
 
 	var blk block
 	err = json.Unmarshal(response.Result, &blk)
 	if err != nil {
-		return nil, err
+		return block{}, err
+	}
+	return blk, nil
+}
+
+// getReceipt returns the receipt of a transaction given its hash
+func (e *EthereumObserver) getReceipt(hash string) (receipt, error) {
+	receiptReq := EthRequestStruct{
+		Jsonrpc: "2.0",
+		Method:  "eth_getTransactionReceipt",
+		Params:  []interface{}{hash},
+		Id:      0,
 	}
 
-	return blk.Transactions, nil
+	response, err := e.QueryEthClient(receiptReq)
+	if err != nil {
+		return receipt{}, err
+	}
+
+	var rcpt receipt
+	err = json.Unmarshal(response.Result, &rcpt)
+	if err != nil {
+		return receipt{}, err
+	}
+	if rcpt.TransactionHash == "" {
+		return receipt{}, fmt.Errorf("no receipt for transaction %s", hash)
+	}
+	return rcpt, nil
+}
+
+// addReceipts reads the receipt of every matched transaction of the block and copies its status and gas used into
+// the transactions, along with their fees and the block time
+func (e *EthereumObserver) addReceipts(transactionsByAddress map[string][]Transaction, blk block) error {
+	stack := e.Stack()
+	receipts := make(map[string]receipt)
+	for _, transactions := range transactionsByAddress {
+		for i := range transactions {
+			rcpt, ok := receipts[transactions[i].Hash]
+			if !ok {
+				var err error
+				rcpt, err = e.getReceipt(transactions[i].Hash)
+				if err != nil {
+					return err
+				}
+				receipts[transactions[i].Hash] = rcpt
+			}
+			transactions[i].Status = rcpt.Status
+			transactions[i].GasUsed = rcpt.GasUsed
+			transactions[i].EffectiveGasPrice = rcpt.EffectiveGasPrice
+			if transactions[i].EffectiveGasPrice == "" {
+				transactions[i].EffectiveGasPrice = effectiveGasPrice(transactions[i], blk.BaseFeePerGas)
+			}
+			transactions[i].BlobGasUsed = rcpt.BlobGasUsed
+			transactions[i].BlobGasPrice = rcpt.BlobGasPrice
+			transactions[i].Logs = rcpt.Logs
+			transactions[i].L2Fields = rcpt.L2Fields
+			transactions[i].Timestamp = blk.Timestamp
+			setFees(&transactions[i], blk.BaseFeePerGas, stack)
+		}
+	}
+	return nil
 }
 
 // collectSubscribedAddresses returns a map of transactions by address. it filters transactions
-// by the subscribed addresses in the observer
+// by the subscribed addresses in the observer and is safe to call while subscriptions are added
 func (e *EthereumObserver) collectSubscribedAddresses(transactions []Transaction) map[string][]Transaction {
 	transactionsByAddress := make(map[string][]Transaction)
 	for _, transaction := range transactions {
 		for _, address := range []string{transaction.From, transaction.To} {
-			if _, ok := e.subscribedAddress[address]; ok {
+			if e.subscriptions.contains(address) {
 				transactionsByAddress[address] = append(transactionsByAddress[address], transaction)
 				slog.Debug("Transaction added", "transaction", transaction)
 			}
@@ -205,7 +385,8 @@ func (e *EthereumObserver) addBlockToRead(blockNum int) {
 }
 
 // UpdateTransactions updates the transactions in the observer for a given block number
-// it collects transactions by number, filters them by subscribed addresses and adds them to the transaction store
+// it collects transactions by number, filters them by subscribed addresses, or by the logs of the block when its
+// logs bloom shows they may involve a subscribed address, and adds them to the transaction store
 // if there are errors fetching the transactions, the block is added back to the list of blocks to read
 // if the block number is greater than the latest block, the latest block is updated
 func (e *EthereumObserver) UpdateTransactions(blockNum int) {
@@ -213,22 +394,116 @@ func (e *EthereumObserver) UpdateTransactions(blockNum int) {
 
 	// Format to hex string
 	blockNumStr := fmt.Sprintf("0x%x", blockNum)
-	transactions, err := e.GetBlockByNumber(blockNumStr)
+	blk, err := e.getBlock(blockNumStr)
 	if err != nil {
 		slog.Error(err.Error())
+		e.emit(Event{Type: EventError, BlockNumber: blockNum, Err: err})
 		// if error, add block back to read list
 		e.addBlockToRead(blockNum)
 		return
 	}
+	e.checkReorg(blockNum, blk)
 
-	transactionsByAddress := e.collectSubscribedAddresses(transactions)
+	transactionsByAddress := e.collectSubscribedAddresses(blk.Transactions)
+	if err := e.matchLogs(blk, transactionsByAddress); err != nil {
+		slog.Error(err.Error())
+		e.emit(Event{Type: EventError, BlockNumber: blockNum, Err: err})
+		e.addBlockToRead(blockNum)
+		return
+	}
+	if err := e.addReceipts(transactionsByAddress, blk); err != nil {
+		slog.Error(err.Error())
+		e.emit(Event{Type: EventError, BlockNumber: blockNum, Err: err})
+		e.addBlockToRead(blockNum)
+		return
+	}
+	e.decodeTransactions(transactionsByAddress)
 	// iterate over transactions by address and add them to the transaction store
 	for address, transactions := range transactionsByAddress {
 		e.transactionsStore.AddTransactions(address, transactions)
+		e.emitTransactions(blockNum, address, transactions)
 	}
+	e.storeWithdrawals(blk)
+	e.minePending(blockNum, blk.Transactions)
+	e.metrics.blocksRead.Add(1)
 	e.updateLatestBlock(blockNum)
 }
 
+// checkReorg compares the block with the hashes of the blocks processed before it and emits a reorg
+// event if its parent, or an earlier version of the block itself, was replaced on chain.
+// transactions already stored for replaced blocks are left in the store
+func (e *EthereumObserver) checkReorg(blockNum int, blk block) {
+	var reorgs []Event
+	e.mux.Lock()
+	if e.blockHashes == nil {
+		e.blockHashes = make(map[int]string)
+	}
+	if parent, ok := e.blockHashes[blockNum-1]; ok && blk.ParentHash != "" && parent != blk.ParentHash {
+		reorgs = append(reorgs, Event{Type: EventReorg, BlockNumber: blockNum - 1, OldHash: parent, NewHash: blk.ParentHash})
+		e.blockHashes[blockNum-1] = blk.ParentHash
+	}
+	if previous, ok := e.blockHashes[blockNum]; ok && blk.Hash != "" && previous != blk.Hash {
+		reorgs = append(reorgs, Event{Type: EventReorg, BlockNumber: blockNum, OldHash: previous, NewHash: blk.Hash})
+	}
+	if blk.Hash != "" {
+		e.blockHashes[blockNum] = blk.Hash
+	}
+	for number := range e.blockHashes {
+		if number <= blockNum-reorgWindow {
+			delete(e.blockHashes, number)
+		}
+	}
+	e.mux.Unlock()
+
+	for _, reorg := range reorgs {
+		slog.Warn("Chain reorganisation", "block", reorg.BlockNumber, "old", reorg.OldHash, "new", reorg.NewHash)
+		e.emit(reorg)
+	}
+}
+
+// emitTransactions emits a transaction event for each transaction stored for an address
+// along with the subscriptions that can see it and whose rules fired
+func (e *EthereumObserver) emitTransactions(blockNum int, address string, transactions []Transaction) {
+	subscriptions := e.ListSubscriptions(SubscriptionFilter{Address: address})
+	for _, transaction := range transactions {
+		var visible []Subscription
+		for _, subscription := range subscriptions {
+			if annotated := annotate([]Transaction{transaction}, subscription); len(annotated) == 1 && subscription.Notifies(annotated[0]) {
+				visible = append(visible, subscription)
+			}
+		}
+		transaction := transaction
+		e.emit(Event{Type: EventTransaction, BlockNumber: blockNum, Address: address, Transaction: &transaction, Subscriptions: visible})
+	}
+}
+
+// observeHead records the chain head reported by the ethereum client, emitting a new head event
+// when it advances, and emits a lag event when the distance to the last parsed block changes
+func (e *EthereumObserver) observeHead(head int) {
+	e.mux.Lock()
+	advanced := head > e.head
+	if advanced {
+		e.head = head
+	}
+	e.mux.Unlock()
+	if advanced {
+		e.emit(Event{Type: EventNewHead, BlockNumber: head})
+	}
+	e.updateLag()
+}
+
+// updateLag emits a lag event when the number of blocks between the head and the last parsed block changes
+func (e *EthereumObserver) updateLag() {
+	e.mux.Lock()
+	lag := max(e.head-e.latestBlock, 0)
+	changed := lag != e.lag
+	e.lag = lag
+	e.mux.Unlock()
+	if changed {
+		e.emit(Event{Type: EventLag, BlockNumber: e.GetCurrentBlock(), Lag: lag})
+	}
+}
+
 // updateLatestBlock updates the latest block in the observer
 // if the block number is greater than the current latest block
 // it returns true if the block number was updated
@@ -247,15 +522,7 @@ func (e *EthereumObserver) updateLatestBlock(blockNum int) bool {
 // it sets the address to lowercase as the input address may have EIP55 checksum encoding
 // while the transactions are returned in lowercase
 func (e *EthereumObserver) Subscribe(address string) bool {
-	e.mux.Lock()
-	defer e.mux.Unlock()
-	if _, ok := e.subscribedAddress[strings.ToLower(address)]; ok {
-		slog.Debug("Already subscribed to address", "address", address)
-		return false
-	}
-	e.subscribedAddress[strings.ToLower(address)] = struct{}{}
-	slog.Debug("Subscribed to address", "address", address)
-	return true
+	return e.AddSubscription(Subscription{Address: address})
 }
 
 // GetCurrentBlock returns the current block number in the observer
@@ -263,7 +530,8 @@ func (e *EthereumObserver) GetCurrentBlock() int {
 	return e.latestBlock
 }
 
-// GetTransactions returns transactions for a given address
+// GetTransactions returns every stored transaction for a given address regardless of tenant
+// use Tenant to read the transactions scoped to a tenant's subscriptions
 func (e *EthereumObserver) GetTransactions(address string) []Transaction {
 	return e.transactionsStore.GetTransactions(strings.ToLower(address))
 }
@@ -302,14 +570,17 @@ func (e *EthereumObserver) ObserveChain() {
 		blockNum, err := e.GetBlockNumber()
 		if err != nil {
 			slog.Error(err.Error())
+			e.emit(Event{Type: EventError, Err: err})
 			continue
 		}
 		// convert from hex string to int
 		blockNumInt, err := strconv.ParseInt(blockNum[2:], 16, 64)
 		if err != nil {
 			slog.Error(err.Error())
+			e.emit(Event{Type: EventError, Err: err})
 			continue
 		}
+		e.observeHead(int(blockNumInt))
 
 		// add blocks to read. Looping ensures no blocks are missed
 		for i := e.latestBlock + 1; i < int(blockNumInt); i++ {
@@ -321,6 +592,7 @@ func (e *EthereumObserver) ObserveChain() {
 			e.removeBlockToRead(blockNum)
 			e.UpdateTransactions(blockNum)
 		}
+		e.updateLag()
 
 		// wait 10s if no blocks to read (they will have been added in the case of read failre in Update Transactions).
 		// Avg time between blocks is 13s.
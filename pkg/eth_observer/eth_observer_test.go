@@ -2,12 +2,18 @@ package eth_observer
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_QueryEthClient(t *testing.T) {
@@ -201,6 +207,15 @@ func Test_GetBlockByNumber(t *testing.T) {
 	}
 }
 
+// subscribedTo returns an observer subscribed to the addresses
+func subscribedTo(addresses ...string) *EthereumObserver {
+	e := &EthereumObserver{}
+	for _, address := range addresses {
+		e.subscriptions.put(Subscription{Address: address})
+	}
+	return e
+}
+
 func TestEthereumObserver_collectSubscribedAddresses(t *testing.T) {
 	type args struct {
 		transactions []Transaction
@@ -213,7 +228,7 @@ func TestEthereumObserver_collectSubscribedAddresses(t *testing.T) {
 	}{
 		{
 			name: "Test collectSubscribedAddresses",
-			e:    &EthereumObserver{subscribedAddress: map[string]struct{}{"0x2": struct{}{}}},
+			e:    subscribedTo("0x2"),
 			args: args{
 				transactions: []Transaction{
 					{
@@ -233,7 +248,7 @@ func TestEthereumObserver_collectSubscribedAddresses(t *testing.T) {
 		},
 		{
 			name: "Test collectSubscribedAddresses no match",
-			e:    &EthereumObserver{subscribedAddress: map[string]struct{}{"0x4": struct{}{}}},
+			e:    subscribedTo("0x4"),
 			args: args{
 				transactions: []Transaction{
 					{
@@ -383,37 +398,132 @@ func TestEthereumObserver_Subscribe(t *testing.T) {
 		address string
 	}
 	tests := []struct {
-		name              string
-		e                 *EthereumObserver
-		args              args
-		want              bool
-		wantSubscriptions map[string]struct{}
+		name          string
+		e             *EthereumObserver
+		args          args
+		want          bool
+		wantAddresses []string
 	}{
 		{
-			name:              "Test Subscribe",
-			e:                 &EthereumObserver{subscribedAddress: map[string]struct{}{}},
-			args:              args{address: "0x1"},
-			want:              true,
-			wantSubscriptions: map[string]struct{}{"0x1": {}},
+			name:          "Test Subscribe",
+			e:             subscribedTo(),
+			args:          args{address: "0x1"},
+			want:          true,
+			wantAddresses: []string{"0x1"},
+		},
+		{
+			name:          "Test Subscribe duplicate",
+			e:             subscribedTo("0x1"),
+			args:          args{address: "0x1"},
+			want:          false,
+			wantAddresses: []string{"0x1"},
 		},
 		{
-			name:              "Test Subscribe duplicate",
-			e:                 &EthereumObserver{subscribedAddress: map[string]struct{}{"0x1": {}}},
-			args:              args{address: "0x1"},
-			want:              false,
-			wantSubscriptions: map[string]struct{}{"0x1": {}},
+			name:          "Test Subscribe checksum address",
+			e:             subscribedTo(),
+			args:          args{address: "0xAbC"},
+			want:          true,
+			wantAddresses: []string{"0xabc"},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			if got := tt.e.Subscribe(tt.args.address); got != tt.want {
 				t.Errorf("EthereumObserver.Subscribe() = %v, want %v", got, tt.want)
-				assert.Equal(t, tt.wantSubscriptions, tt.e.subscribedAddress)
+			}
+			assert.Equal(t, len(tt.wantAddresses), tt.e.subscriptions.len())
+			for _, address := range tt.wantAddresses {
+				assert.True(t, tt.e.subscriptions.contains(address))
 			}
 		})
 	}
 }
 
+type fakeRegistry struct {
+	subscriptions []Subscription
+	saveErr       error
+}
+
+func (f *fakeRegistry) LoadSubscriptions() ([]Subscription, error) {
+	return f.subscriptions, nil
+}
+
+func (f *fakeRegistry) SaveSubscription(subscription Subscription) error {
+	if f.saveErr != nil {
+		return f.saveErr
+	}
+	f.subscriptions = append(f.subscriptions, subscription)
+	return nil
+}
+
+func TestEthereumObserver_UseRegistry(t *testing.T) {
+	registry := &fakeRegistry{subscriptions: []Subscription{{Address: "0xAA", SubscriptionMetadata: SubscriptionMetadata{Label: "treasury"}, StartBlock: 10}}}
+	e := NewEthereumObserver("", nil)
+	e.latestBlock = 20
+	assert.NoError(t, e.UseRegistry(registry))
+
+	sub, ok := e.GetSubscription("", "0xaa")
+	assert.True(t, ok)
+	assert.Equal(t, "treasury", sub.Label)
+	assert.Equal(t, 10, sub.StartBlock)
+
+	// new subscriptions are persisted with defaults filled in
+	assert.True(t, e.Subscribe("0xBB"))
+	assert.Len(t, registry.subscriptions, 2)
+	assert.Equal(t, "0xbb", registry.subscriptions[1].Address)
+	assert.Equal(t, 21, registry.subscriptions[1].StartBlock)
+	assert.False(t, registry.subscriptions[1].CreatedAt.IsZero())
+
+	// a subscription which cannot be persisted is not added
+	registry.saveErr = errors.New("disk full")
+	assert.False(t, e.Subscribe("0xcc"))
+	_, ok = e.GetSubscription("", "0xcc")
+	assert.False(t, ok)
+}
+
+// blockingRegistry holds every save until release is closed
+type blockingRegistry struct {
+	saving  chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingRegistry) LoadSubscriptions() ([]Subscription, error) {
+	return nil, nil
+}
+
+func (b *blockingRegistry) SaveSubscription(Subscription) error {
+	b.saving <- struct{}{}
+	<-b.release
+	return nil
+}
+
+func TestEthereumObserver_AddSubscription_persistsOutsideLock(t *testing.T) {
+	registry := &blockingRegistry{saving: make(chan struct{}), release: make(chan struct{})}
+	e := NewEthereumObserver("", nil)
+	require.NoError(t, e.UseRegistry(registry))
+
+	subscribed := make(chan bool)
+	go func() { subscribed <- e.Subscribe("0xaa") }()
+	<-registry.saving
+
+	// matching and readers carry on while the subscription is written to disk
+	done := make(chan struct{})
+	go func() {
+		e.updateLatestBlock(5)
+		e.Stack()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("observer blocked by a subscription being persisted")
+	}
+
+	close(registry.release)
+	assert.True(t, <-subscribed)
+	assert.True(t, e.subscriptions.contains("0xaa"))
+}
+
 func TestEthereumObserver_removeBlockToRead(t *testing.T) {
 	type args struct {
 		blockNum int
@@ -446,3 +556,115 @@ func TestEthereumObserver_removeBlockToRead(t *testing.T) {
 		})
 	}
 }
+
+type fakeStore map[string][]Transaction
+
+func (f fakeStore) GetTransactions(address string) []Transaction {
+	return f[address]
+}
+
+func (f fakeStore) AddTransactions(address string, transactions []Transaction) {
+	f[address] = append(f[address], transactions...)
+}
+
+func TestEthereumObserver_QueryTransactions(t *testing.T) {
+	store := fakeStore{
+		"0x1": {{Hash: "0xa"}},
+		"0x2": {{Hash: "0xb"}},
+		"0x3": {{Hash: "0xc"}},
+	}
+	e := NewEthereumObserver("", store)
+	e.SubscribeWithMetadata("0x1", SubscriptionMetadata{Label: "hot", Owner: "acme", Tags: []string{"exchange", " exchange", ""}})
+	e.SubscribeWithMetadata("0x2", SubscriptionMetadata{Owner: "acme", Tags: []string{"treasury"}})
+	e.SubscribeWithMetadata("0x3", SubscriptionMetadata{Owner: "globex", Tags: []string{"exchange"}})
+
+	tests := []struct {
+		name       string
+		filter     SubscriptionFilter
+		wantHashes []string
+	}{
+		{name: "All", wantHashes: []string{"0xa", "0xb", "0xc"}},
+		{name: "By owner", filter: SubscriptionFilter{Owner: "acme"}, wantHashes: []string{"0xa", "0xb"}},
+		{name: "By tag", filter: SubscriptionFilter{Tag: "exchange"}, wantHashes: []string{"0xa", "0xc"}},
+		{name: "By owner and tag", filter: SubscriptionFilter{Owner: "acme", Tag: "exchange"}, wantHashes: []string{"0xa"}},
+		{name: "Address filtered out", filter: SubscriptionFilter{Address: "0x3", Owner: "acme"}, wantHashes: []string{}},
+		{name: "Address", filter: SubscriptionFilter{Address: "0x2"}, wantHashes: []string{"0xb"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hashes := []string{}
+			for _, transaction := range e.QueryTransactions(tt.filter) {
+				assert.NotNil(t, transaction.Subscription)
+				hashes = append(hashes, transaction.Hash)
+			}
+			assert.Equal(t, tt.wantHashes, hashes)
+		})
+	}
+
+	got := e.Tenant("acme").GetTransactions("0x1")
+	assert.Equal(t, &SubscriptionMetadata{Label: "hot", Owner: "acme", Tags: []string{"exchange"}}, got[0].Subscription)
+	assert.Nil(t, store["0x1"][0].Subscription, "stored transactions must not be modified")
+	assert.Len(t, e.ListSubscriptions(SubscriptionFilter{Tag: "treasury"}), 1)
+}
+
+func TestTenant_isolation(t *testing.T) {
+	store := fakeStore{}
+	e := NewEthereumObserver("", store)
+	acme, globex := e.Tenant("acme"), e.Tenant("globex")
+
+	e.latestBlock = 9
+	assert.True(t, acme.Subscribe("0x1"))
+	assert.False(t, acme.Subscribe("0x1"))
+	e.latestBlock = 19
+	assert.True(t, globex.SubscribeWithMetadata("0x1", SubscriptionMetadata{Owner: "acme", Label: "shared"}))
+
+	// both tenants share one entry in the observer so the address is ingested once
+	assert.Equal(t, 1, e.subscriptions.len())
+	assert.Len(t, e.collectSubscribedAddresses([]Transaction{{Hash: "0xa", From: "0x1"}}), 1)
+
+	store.AddTransactions("0x1", []Transaction{{Hash: "0xa", BlockNumber: "0xa"}, {Hash: "0xb", BlockNumber: "0x14"}})
+	store.AddTransactions("0x2", []Transaction{{Hash: "0xc", BlockNumber: "0x14"}})
+
+	assert.Len(t, acme.GetTransactions("0x1"), 2)
+	// globex subscribed at block 20 so does not see the earlier transaction gathered for acme
+	globexTransactions := globex.GetTransactions("0x1")
+	assert.Len(t, globexTransactions, 1)
+	assert.Equal(t, "globex", globexTransactions[0].Subscription.Owner)
+	assert.Equal(t, "shared", globexTransactions[0].Subscription.Label)
+	// addresses the tenant is not subscribed to are not readable
+	assert.Empty(t, acme.GetTransactions("0x2"))
+	assert.Empty(t, acme.GetTransactions(""))
+	assert.Len(t, acme.ListSubscriptions(SubscriptionFilter{}), 1)
+
+	_, ok := globex.GetSubscription("0x1")
+	assert.True(t, ok)
+	assert.Equal(t, "globex", globex.ID())
+}
+
+func TestTenant_AddKeySubscription(t *testing.T) {
+	e := NewEthereumObserver("", nil)
+	tenant := e.Tenant("acme")
+	allows := func(current int) bool { return current < 5 }
+
+	// concurrent subscriptions of one key never exceed its quota
+	var wg sync.WaitGroup
+	var added atomic.Int64
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if tenant.AddKeySubscription(Subscription{Address: fmt.Sprintf("0x%040x", i)}, "ops", allows) == nil {
+				added.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 5, added.Load())
+
+	assert.ErrorIs(t, tenant.AddKeySubscription(Subscription{Address: "0xaa"}, "ops", allows), ErrSubscriptionQuota)
+	assert.NoError(t, tenant.AddKeySubscription(Subscription{Address: "0xaa"}, "admin", allows), "keys have quotas of their own")
+	assert.ErrorIs(t, tenant.AddKeySubscription(Subscription{Address: "0xaa"}, "other", allows), ErrAlreadySubscribed)
+	assert.NoError(t, e.Tenant("globex").AddKeySubscription(Subscription{Address: "0xaa"}, "ops", allows), "quotas are kept per tenant")
+	subscription, _ := tenant.GetSubscription("0xaa")
+	assert.Equal(t, "admin", subscription.KeyID)
+}
@@ -0,0 +1,212 @@
+package eth_observer
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies what an Event reports
+type EventType string
+
+const (
+	// EventTransaction reports a transaction matched for a subscribed address, emitted once it is stored
+	EventTransaction EventType = "transaction"
+	// EventNewHead reports a new chain head seen by the observer
+	EventNewHead EventType = "newHead"
+	// EventReorg reports a processed block which is no longer part of the chain
+	EventReorg EventType = "reorg"
+	// EventError reports an error fetching from the ethereum client
+	EventError EventType = "error"
+	// EventLag reports a change in the number of blocks between the chain head and the last parsed block
+	EventLag EventType = "lag"
+	// EventPending reports a transaction from or to a subscribed address seen in the mempool
+	EventPending EventType = "pending"
+	// EventPendingMined reports a pending transaction included in a block
+	EventPendingMined EventType = "pendingMined"
+	// EventPendingReplaced reports a pending transaction replaced by another with the same nonce
+	EventPendingReplaced EventType = "pendingReplaced"
+	// EventPendingDropped reports a pending transaction which left the mempool without being mined
+	EventPendingDropped EventType = "pendingDropped"
+)
+
+// Event is emitted by the observer. the fields set depend on the type
+type Event struct {
+	Type        EventType `json:"type"`
+	Time        time.Time `json:"time"`
+	BlockNumber int       `json:"blockNumber,omitempty"`
+
+	// Address, Transaction and Subscriptions are set for EventTransaction. Subscriptions holds the
+	// subscriptions of every tenant watching the address which can see the transaction and whose rules select it
+	Address       string         `json:"address,omitempty"`
+	Transaction   *Transaction   `json:"transaction,omitempty"`
+	Subscriptions []Subscription `json:"subscriptions,omitempty"`
+
+	// OldHash is the hash the observer processed and NewHash the hash now on chain for EventReorg
+	OldHash string `json:"oldHash,omitempty"`
+	NewHash string `json:"newHash,omitempty"`
+
+	// Err is set for EventError
+	Err error `json:"-"`
+
+	// Lag is set for EventLag
+	Lag int `json:"lag,omitempty"`
+
+	// Pending is set for the pending events along with Address and Subscriptions
+	Pending *PendingTransaction `json:"pending,omitempty"`
+}
+
+// DeliveryPolicy chooses what happens when a subscriber's buffer is full
+type DeliveryPolicy int
+
+const (
+	// DropNewest drops events which do not fit in the subscriber's buffer. the observer never waits
+	// for the subscriber and the number of dropped events is reported by Dropped
+	DropNewest DeliveryPolicy = iota
+	// Block makes the observer wait until the subscriber has room for the event. a slow subscriber
+	// stalls block processing but never misses an event
+	Block
+)
+
+// EventOptions configure an event subscription
+type EventOptions struct {
+	// BufferSize is the number of events buffered for the subscriber, defaults to 256
+	BufferSize int
+	Policy     DeliveryPolicy
+	// Types limits the subscription to the given event types, all types are delivered if empty
+	Types []EventType
+}
+
+// EventSubscription is a subscription to the observer's events
+//
+// Delivery guarantees: events are delivered in the order they are emitted. transaction events are emitted
+// after the transaction is stored, so GetTransactions already returns it. with the Block policy every event
+// emitted while subscribed is delivered exactly once. with DropNewest events are dropped while the buffer is
+// full. events are only emitted for the lifetime of the process, missed transactions can be read from the store
+type EventSubscription struct {
+	bus     *eventBus
+	events  chan Event
+	done    chan struct{}
+	options EventOptions
+	dropped atomic.Uint64
+	mux     sync.RWMutex
+	closed  bool
+	once    sync.Once
+}
+
+// C returns the channel events are delivered on. it is closed by Cancel
+func (s *EventSubscription) C() <-chan Event {
+	return s.events
+}
+
+// Dropped returns the number of events dropped because the buffer was full
+func (s *EventSubscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// wants reports whether the subscription receives events of the type
+func (s *EventSubscription) wants(eventType EventType) bool {
+	return len(s.options.Types) == 0 || slices.Contains(s.options.Types, eventType)
+}
+
+// deliver passes an event to the subscriber following its delivery policy
+func (s *EventSubscription) deliver(event Event) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if s.closed {
+		return
+	}
+	if s.options.Policy == Block {
+		select {
+		case s.events <- event:
+		case <-s.done:
+		}
+		return
+	}
+	select {
+	case s.events <- event:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// eventBus fans the observer's events out to its subscribers
+type eventBus struct {
+	mux         sync.Mutex
+	subscribers map[*EventSubscription]struct{}
+}
+
+// Events subscribes to the observer's events. call Cancel on the subscription once done with it
+func (e *EthereumObserver) Events(options EventOptions) *EventSubscription {
+	if options.BufferSize <= 0 {
+		options.BufferSize = 256
+	}
+	subscription := &EventSubscription{
+		bus:     &e.events,
+		events:  make(chan Event, options.BufferSize),
+		done:    make(chan struct{}),
+		options: options,
+	}
+	e.events.mux.Lock()
+	defer e.events.mux.Unlock()
+	if e.events.subscribers == nil {
+		e.events.subscribers = make(map[*EventSubscription]struct{})
+	}
+	e.events.subscribers[subscription] = struct{}{}
+	return subscription
+}
+
+// OnTransaction calls handler with every transaction event on a dedicated goroutine. it returns
+// the subscription, whose Cancel stops the handler
+func (e *EthereumObserver) OnTransaction(handler func(Event), options EventOptions) *EventSubscription {
+	options.Types = []EventType{EventTransaction}
+	return e.OnEvent(handler, options)
+}
+
+// OnEvent calls handler with every event passing the options on a dedicated goroutine. it returns
+// the subscription, whose Cancel stops the handler
+func (e *EthereumObserver) OnEvent(handler func(Event), options EventOptions) *EventSubscription {
+	subscription := e.Events(options)
+	go func() {
+		for event := range subscription.events {
+			handler(event)
+		}
+	}()
+	return subscription
+}
+
+// Cancel stops delivering events to the subscription and closes its channel
+func (s *EventSubscription) Cancel() {
+	s.bus.mux.Lock()
+	delete(s.bus.subscribers, s)
+	s.bus.mux.Unlock()
+
+	s.once.Do(func() {
+		// release a blocked delivery before waiting for it to finish
+		close(s.done)
+		s.mux.Lock()
+		defer s.mux.Unlock()
+		s.closed = true
+		close(s.events)
+	})
+}
+
+// emit delivers an event to every subscriber that wants it
+func (e *EthereumObserver) emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	e.events.mux.Lock()
+	subscribers := make([]*EventSubscription, 0, len(e.events.subscribers))
+	for subscriber := range e.events.subscribers {
+		if subscriber.wants(event.Type) {
+			subscribers = append(subscribers, subscriber)
+		}
+	}
+	e.events.mux.Unlock()
+
+	for _, subscriber := range subscribers {
+		subscriber.deliver(event)
+	}
+}
@@ -0,0 +1,206 @@
+package eth_observer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newBlockServer serves eth_getBlockByNumber from the blocks given, keyed by hex block number, and
+// eth_getTransactionReceipt with a receipt of a successful transaction
+func newBlockServer(t *testing.T, blocks map[string]string) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req EthRequestStruct
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Method == "eth_getTransactionReceipt" {
+			json.NewEncoder(w).Encode(EthResponseStruct{
+				Jsonrpc: "2.0",
+				Result:  []byte(`{"transactionHash":"` + req.Params[0].(string) + `","status":"0x1","gasUsed":"0x5208"}`),
+			})
+			return
+		}
+		if req.Method == "eth_getLogs" {
+			json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Result: []byte(`[]`)})
+			return
+		}
+		blk, ok := blocks[req.Params[0].(string)]
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Result: []byte(blk)})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// receive waits for the next event on the subscription
+func receive(t *testing.T, subscription *EventSubscription) Event {
+	t.Helper()
+	select {
+	case event, ok := <-subscription.C():
+		require.True(t, ok, "event channel closed")
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+		return Event{}
+	}
+}
+
+func TestEthereumObserver_transactionEvents(t *testing.T) {
+	ts := newBlockServer(t, map[string]string{
+		"0x5": `{"number":"0x5","hash":"0xb5","parentHash":"0xb4","transactions":[
+			{"hash":"0xa","from":"0x1","to":"0x9","blockNumber":"0x5"},
+			{"hash":"0xb","from":"0x8","to":"0x9","blockNumber":"0x5"}]}`,
+	})
+	store := fakeStore{}
+	e := NewEthereumObserver(ts.URL, store)
+	e.latestBlock = 4
+	e.Tenant("acme").SubscribeWithMetadata("0x1", SubscriptionMetadata{Label: "hot"})
+	e.latestBlock = 9
+	e.Tenant("globex").Subscribe("0x1")
+
+	events := e.Events(EventOptions{})
+	defer events.Cancel()
+	e.UpdateTransactions(5)
+
+	event := receive(t, events)
+	assert.Equal(t, EventTransaction, event.Type)
+	assert.Equal(t, 5, event.BlockNumber)
+	assert.Equal(t, "0x1", event.Address)
+	assert.Equal(t, "0xa", event.Transaction.Hash)
+	assert.False(t, event.Time.IsZero())
+	// globex subscribed after block 5 so the transaction is only visible to acme
+	require.Len(t, event.Subscriptions, 1)
+	assert.Equal(t, "acme", event.Subscriptions[0].Owner)
+	// the transaction is stored before its event is emitted
+	assert.Len(t, store["0x1"], 1)
+	assert.Empty(t, events.C())
+}
+
+func TestEthereumObserver_OnTransaction(t *testing.T) {
+	ts := newBlockServer(t, map[string]string{
+		"0x1": `{"number":"0x1","hash":"0xb1","transactions":[{"hash":"0xa","from":"0x1","blockNumber":"0x1"}]}`,
+	})
+	e := NewEthereumObserver(ts.URL, fakeStore{})
+	e.Subscribe("0x1")
+
+	received := make(chan Event, 1)
+	subscription := e.OnTransaction(func(event Event) { received <- event }, EventOptions{})
+	defer subscription.Cancel()
+	e.emit(Event{Type: EventNewHead, BlockNumber: 1})
+	e.UpdateTransactions(1)
+
+	select {
+	case event := <-received:
+		assert.Equal(t, EventTransaction, event.Type)
+		assert.Equal(t, "0xa", event.Transaction.Hash)
+	case <-time.After(time.Second):
+		t.Fatal("handler not called")
+	}
+}
+
+func TestEthereumObserver_reorgEvents(t *testing.T) {
+	blocks := map[string]string{
+		"0x1": `{"number":"0x1","hash":"0xb1","parentHash":"0xb0","transactions":[]}`,
+		"0x2": `{"number":"0x2","hash":"0xc2","parentHash":"0xc1","transactions":[]}`,
+	}
+	e := NewEthereumObserver(newBlockServer(t, blocks).URL, fakeStore{})
+	events := e.Events(EventOptions{Types: []EventType{EventReorg, EventError}})
+	defer events.Cancel()
+
+	e.UpdateTransactions(1)
+	e.UpdateTransactions(2)
+	event := receive(t, events)
+	assert.Equal(t, EventReorg, event.Type)
+	assert.Equal(t, 1, event.BlockNumber)
+	assert.Equal(t, "0xb1", event.OldHash)
+	assert.Equal(t, "0xc1", event.NewHash)
+
+	// reading a block again which has since been replaced
+	blocks["0x2"] = `{"number":"0x2","hash":"0xd2","parentHash":"0xc1","transactions":[]}`
+	e.UpdateTransactions(2)
+	event = receive(t, events)
+	assert.Equal(t, EventReorg, event.Type)
+	assert.Equal(t, 2, event.BlockNumber)
+	assert.Equal(t, "0xc2", event.OldHash)
+	assert.Equal(t, "0xd2", event.NewHash)
+
+	e.UpdateTransactions(3)
+	event = receive(t, events)
+	assert.Equal(t, EventError, event.Type)
+	assert.Equal(t, 3, event.BlockNumber)
+	assert.Error(t, event.Err)
+}
+
+func TestEthereumObserver_headAndLagEvents(t *testing.T) {
+	e := NewEthereumObserver("", fakeStore{})
+	e.latestBlock = 8
+	events := e.Events(EventOptions{})
+	defer events.Cancel()
+
+	e.observeHead(10)
+	assert.Equal(t, Event{Type: EventNewHead, BlockNumber: 10}, withoutTime(receive(t, events)))
+	assert.Equal(t, Event{Type: EventLag, BlockNumber: 8, Lag: 2}, withoutTime(receive(t, events)))
+
+	// an unchanged head and lag emit nothing
+	e.observeHead(10)
+	e.updateLatestBlock(10)
+	e.updateLag()
+	assert.Equal(t, Event{Type: EventLag, BlockNumber: 10}, withoutTime(receive(t, events)))
+	assert.Empty(t, events.C())
+}
+
+func withoutTime(event Event) Event {
+	event.Time = time.Time{}
+	return event
+}
+
+func TestEventSubscription_policies(t *testing.T) {
+	e := NewEthereumObserver("", nil)
+
+	dropping := e.Events(EventOptions{BufferSize: 2})
+	for i := 0; i < 5; i++ {
+		e.emit(Event{Type: EventNewHead, BlockNumber: i})
+	}
+	assert.Equal(t, uint64(3), dropping.Dropped())
+	assert.Equal(t, 0, receive(t, dropping).BlockNumber)
+	assert.Equal(t, 1, receive(t, dropping).BlockNumber)
+	dropping.Cancel()
+
+	blocking := e.Events(EventOptions{BufferSize: 1, Policy: Block})
+	emitted := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			e.emit(Event{Type: EventNewHead, BlockNumber: i})
+		}
+		close(emitted)
+	}()
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, i, receive(t, blocking).BlockNumber)
+	}
+	<-emitted
+	assert.Zero(t, blocking.Dropped())
+
+	// cancelling releases an emit blocked on a full buffer and closes the channel
+	e.emit(Event{Type: EventNewHead})
+	released := make(chan struct{})
+	go func() {
+		e.emit(Event{Type: EventNewHead})
+		close(released)
+	}()
+	blocking.Cancel()
+	<-released
+	blocking.Cancel()
+	for range blocking.C() {
+	}
+	e.emit(Event{Type: EventNewHead})
+}
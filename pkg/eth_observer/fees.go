@@ -0,0 +1,166 @@
+package eth_observer
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/aceagles/etherum_parser/pkg/chains"
+	"github.com/aceagles/etherum_parser/pkg/units"
+)
+
+// FeeSummary aggregates the fees paid by the transactions sent from an address. amounts are decimal strings of wei
+type FeeSummary struct {
+	Address string `json:"address"`
+	// From and To bound the block times of the transactions counted, From inclusive and To exclusive. nil bounds are open
+	From         *time.Time `json:"from,omitempty"`
+	To           *time.Time `json:"to,omitempty"`
+	Transactions int        `json:"transactions"`
+	Fee          string     `json:"fee"`
+	FeeEth       string     `json:"feeEth"`
+	BurntFee     string     `json:"burntFee"`
+	PriorityFee  string     `json:"priorityFee"`
+	BlobFee      string     `json:"blobFee"`
+	// L1Fee is the part of Fee paid for posting the transactions to L1 on OP Stack chains
+	L1Fee string `json:"l1Fee"`
+}
+
+// FeeSummary returns the fees paid by every stored transaction sent from an address in the time range regardless of tenant.
+// zero times leave the range open
+func (e *EthereumObserver) FeeSummary(address string, from, to time.Time) FeeSummary {
+	return summariseFees(address, e.GetTransactions(address), from, to)
+}
+
+// FeeSummary returns the fees paid by the transactions sent from an address the tenant is subscribed to in the time range
+func (t *Tenant) FeeSummary(address string, from, to time.Time) FeeSummary {
+	return summariseFees(address, t.GetTransactions(address), from, to)
+}
+
+// summariseFees adds up the fees of the transactions sent from the address with a block time in the range.
+// transactions without a fee or, when the range is bounded, without a timestamp are skipped
+func summariseFees(address string, transactions []Transaction, from, to time.Time) FeeSummary {
+	address = strings.ToLower(address)
+	summary := FeeSummary{Address: address}
+	if !from.IsZero() {
+		summary.From = &from
+	}
+	if !to.IsZero() {
+		summary.To = &to
+	}
+
+	fee, burnt, tip, blob, l1 := new(big.Int), new(big.Int), new(big.Int), new(big.Int), new(big.Int)
+	seen := make(map[string]bool)
+	for _, transaction := range transactions {
+		// self transfers are stored twice for the same address
+		if seen[transaction.Hash] || !strings.EqualFold(transaction.From, address) || transaction.Fee == "" {
+			continue
+		}
+		if summary.From != nil || summary.To != nil {
+			timestamp, err := parseHexInt(transaction.Timestamp)
+			if err != nil {
+				continue
+			}
+			blockTime := time.Unix(int64(timestamp), 0)
+			if summary.From != nil && blockTime.Before(from) || summary.To != nil && !blockTime.Before(to) {
+				continue
+			}
+		}
+		seen[transaction.Hash] = true
+		summary.Transactions++
+		addWei(fee, transaction.Fee)
+		addWei(burnt, transaction.BurntFee)
+		addWei(tip, transaction.PriorityFee)
+		addWei(blob, transaction.BlobFee)
+		addWei(l1, transaction.L1Fee)
+	}
+	summary.Fee, summary.FeeEth = fee.String(), units.FormatEther(fee)
+	summary.BurntFee, summary.PriorityFee, summary.BlobFee, summary.L1Fee = burnt.String(), tip.String(), blob.String(), l1.String()
+	return summary
+}
+
+// addWei adds a quantity to the sum, ignoring missing and invalid ones
+func addWei(sum *big.Int, value string) {
+	if wei, err := parseWei(value); err == nil && wei != nil {
+		sum.Add(sum, wei)
+	}
+}
+
+// effectiveGasPrice returns the gas price paid per unit of gas by a transaction in a block with the base fee, as a hex
+// quantity. legacy and access list transactions pay their gas price, later types the base fee plus their priority fee
+// capped at their max fee. it returns an empty string when the fields it needs are missing
+func effectiveGasPrice(transaction Transaction, baseFee string) string {
+	maxFee, err := parseWei(transaction.MaxFeePerGas)
+	if err != nil || maxFee == nil {
+		return transaction.GasPrice
+	}
+	tip, err := parseWei(transaction.MaxPriorityFeePerGas)
+	if err != nil || tip == nil {
+		return ""
+	}
+	base, err := parseWei(baseFee)
+	if err != nil || base == nil {
+		return ""
+	}
+	price := new(big.Int).Add(base, tip)
+	if price.Cmp(maxFee) > 0 {
+		price = maxFee
+	}
+	return fmt.Sprintf("0x%x", price)
+}
+
+// setFees sets the fee of a mined transaction and its breakdown as hex quantities of wei. the fee is its gas used at
+// the effective gas price plus, for blob transactions, its blob gas used at the blob gas price and, on OP Stack chains,
+// its L1 fee. of the gas fee the base fee of the block is burnt, or paid to the base fee vault of an L2, and the rest
+// tips the block builder, blocks before the London upgrade burn nothing. deposits from L1 pay no fee.
+// the fees are left empty when the receipt fields they need are missing
+func setFees(transaction *Transaction, baseFee string, stack chains.Stack) {
+	if isDeposit(*transaction, stack) {
+		transaction.Fee, transaction.BurntFee, transaction.PriorityFee, transaction.BlobFee = "0x0", "0x0", "0x0", "0x0"
+		return
+	}
+	gas, ok := mulWei(transaction.GasUsed, transaction.EffectiveGasPrice)
+	if !ok {
+		return
+	}
+	burnt := new(big.Int)
+	if base, err := parseWei(baseFee); err == nil && base != nil {
+		burnt, _ = mulWei(transaction.GasUsed, baseFee)
+		if burnt.Cmp(gas) > 0 {
+			burnt.Set(gas)
+		}
+	}
+	blob := new(big.Int)
+	if transaction.BlobGasUsed != "" {
+		if blob, ok = mulWei(transaction.BlobGasUsed, transaction.BlobGasPrice); !ok {
+			return
+		}
+	}
+
+	fee := new(big.Int).Add(gas, blob)
+	if stack == chains.StackOP && transaction.L1Fee != "" {
+		l1Fee, err := parseWei(transaction.L1Fee)
+		if err != nil {
+			return
+		}
+		fee.Add(fee, l1Fee)
+	}
+
+	transaction.Fee = fmt.Sprintf("0x%x", fee)
+	transaction.BurntFee = fmt.Sprintf("0x%x", burnt)
+	transaction.PriorityFee = fmt.Sprintf("0x%x", new(big.Int).Sub(gas, burnt))
+	transaction.BlobFee = fmt.Sprintf("0x%x", blob)
+}
+
+// mulWei multiplies two quantities, reporting false if either is missing or invalid
+func mulWei(a, b string) (*big.Int, bool) {
+	x, err := parseWei(a)
+	if err != nil || x == nil {
+		return nil, false
+	}
+	y, err := parseWei(b)
+	if err != nil || y == nil {
+		return nil, false
+	}
+	return x.Mul(x, y), true
+}
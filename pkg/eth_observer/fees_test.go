@@ -0,0 +1,178 @@
+package eth_observer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aceagles/etherum_parser/pkg/chains"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_effectiveGasPrice(t *testing.T) {
+	tests := []struct {
+		name        string
+		transaction Transaction
+		baseFee     string
+		want        string
+	}{
+		{name: "Legacy", transaction: Transaction{Type: "0x0", GasPrice: "0x64"}, baseFee: "0x10", want: "0x64"},
+		{name: "Base fee plus tip", transaction: Transaction{Type: "0x2", MaxFeePerGas: "0x64", MaxPriorityFeePerGas: "0x2"}, baseFee: "0x10", want: "0x12"},
+		{name: "Capped at max fee", transaction: Transaction{Type: "0x3", MaxFeePerGas: "0x11", MaxPriorityFeePerGas: "0x2"}, baseFee: "0x10", want: "0x11"},
+		{name: "Missing base fee", transaction: Transaction{Type: "0x4", MaxFeePerGas: "0x11", MaxPriorityFeePerGas: "0x2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, effectiveGasPrice(tt.transaction, tt.baseFee))
+		})
+	}
+}
+
+func Test_setFees(t *testing.T) {
+	tests := []struct {
+		name        string
+		transaction Transaction
+		baseFee     string
+		stack       chains.Stack
+		want        Transaction
+	}{
+		{
+			name:        "Base fee and tip",
+			transaction: Transaction{GasUsed: "0x5208", EffectiveGasPrice: "0x3"},
+			baseFee:     "0x2",
+			want:        Transaction{GasUsed: "0x5208", EffectiveGasPrice: "0x3", Fee: "0xf618", BurntFee: "0xa410", PriorityFee: "0x5208", BlobFee: "0x0"},
+		},
+		{
+			name:        "Blob gas",
+			transaction: Transaction{GasUsed: "0x5208", EffectiveGasPrice: "0x2", BlobGasUsed: "0x20000", BlobGasPrice: "0x3"},
+			baseFee:     "0x2",
+			want: Transaction{GasUsed: "0x5208", EffectiveGasPrice: "0x2", BlobGasUsed: "0x20000", BlobGasPrice: "0x3",
+				Fee: "0x6a410", BurntFee: "0xa410", PriorityFee: "0x0", BlobFee: "0x60000"},
+		},
+		{
+			name:        "Before London",
+			transaction: Transaction{GasUsed: "0x5208", EffectiveGasPrice: "0x2"},
+			want:        Transaction{GasUsed: "0x5208", EffectiveGasPrice: "0x2", Fee: "0xa410", BurntFee: "0x0", PriorityFee: "0xa410", BlobFee: "0x0"},
+		},
+		{
+			name:        "Missing blob gas price",
+			transaction: Transaction{GasUsed: "0x5208", EffectiveGasPrice: "0x2", BlobGasUsed: "0x20000"},
+			want:        Transaction{GasUsed: "0x5208", EffectiveGasPrice: "0x2", BlobGasUsed: "0x20000"},
+		},
+		{name: "No receipt", transaction: Transaction{GasPrice: "0x2"}, baseFee: "0x1", want: Transaction{GasPrice: "0x2"}},
+		{
+			name:        "OP Stack L1 fee",
+			transaction: Transaction{GasUsed: "0x5208", EffectiveGasPrice: "0x3", L2Fields: L2Fields{L1Fee: "0x10"}},
+			baseFee:     "0x2",
+			stack:       chains.StackOP,
+			want: Transaction{GasUsed: "0x5208", EffectiveGasPrice: "0x3", L2Fields: L2Fields{L1Fee: "0x10"},
+				Fee: "0xf628", BurntFee: "0xa410", PriorityFee: "0x5208", BlobFee: "0x0"},
+		},
+		{
+			name:        "OP Stack deposit",
+			transaction: Transaction{Type: "0x7e", GasUsed: "0x5208", Mint: "0x100"},
+			stack:       chains.StackOP,
+			want:        Transaction{Type: "0x7e", GasUsed: "0x5208", Mint: "0x100", Fee: "0x0", BurntFee: "0x0", PriorityFee: "0x0", BlobFee: "0x0"},
+		},
+		{
+			name:        "Deposit type on Ethereum",
+			transaction: Transaction{Type: "0x7e", GasUsed: "0x5208", EffectiveGasPrice: "0x2"},
+			stack:       chains.StackEthereum,
+			want:        Transaction{Type: "0x7e", GasUsed: "0x5208", EffectiveGasPrice: "0x2", Fee: "0xa410", BurntFee: "0x0", PriorityFee: "0xa410", BlobFee: "0x0"},
+		},
+		{
+			name:        "Arbitrum L1 gas counted in gas used",
+			transaction: Transaction{GasUsed: "0x5208", EffectiveGasPrice: "0x2", L2Fields: L2Fields{GasUsedForL1: "0x1000"}},
+			baseFee:     "0x2",
+			stack:       chains.StackArbitrum,
+			want: Transaction{GasUsed: "0x5208", EffectiveGasPrice: "0x2", L2Fields: L2Fields{GasUsedForL1: "0x1000"},
+				Fee: "0xa410", BurntFee: "0xa410", PriorityFee: "0x0", BlobFee: "0x0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFees(&tt.transaction, tt.baseFee, tt.stack)
+			assert.Equal(t, tt.want, tt.transaction)
+		})
+	}
+}
+
+func Test_summariseFees(t *testing.T) {
+	const address = "0x00000000000000000000000000000000000000aa"
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	at := func(offset time.Duration) string { return fmt.Sprintf("0x%x", day.Add(offset).Unix()) }
+	transactions := []Transaction{
+		{Hash: "0x1", From: address, Timestamp: at(-time.Hour), Fee: "0x10", BurntFee: "0x8", PriorityFee: "0x8", BlobFee: "0x0"},
+		{Hash: "0x2", From: address, Timestamp: at(0), Fee: "0xde0b6b3a7640000", BurntFee: "0xde0b6b3a7640000", PriorityFee: "0x0", BlobFee: "0x0"},
+		// a self transfer is stored twice
+		{Hash: "0x3", From: address, To: address, Timestamp: at(time.Hour), Fee: "0x38", BurntFee: "0x10", PriorityFee: "0x10", BlobFee: "0x10", L2Fields: L2Fields{L1Fee: "0x8"}},
+		{Hash: "0x3", From: address, To: address, Timestamp: at(time.Hour), Fee: "0x38", BurntFee: "0x10", PriorityFee: "0x10", BlobFee: "0x10", L2Fields: L2Fields{L1Fee: "0x8"}},
+		// incoming transactions are paid by their sender
+		{Hash: "0x4", From: "0x00000000000000000000000000000000000000bb", To: address, Timestamp: at(0), Fee: "0x40"},
+		{Hash: "0x5", From: address, Timestamp: at(24 * time.Hour), Fee: "0x50", BurntFee: "0x50", PriorityFee: "0x0", BlobFee: "0x0"},
+		{Hash: "0x6", From: address, Fee: "0x60", BurntFee: "0x60", PriorityFee: "0x0", BlobFee: "0x0"},
+	}
+
+	summary := summariseFees(address, transactions, day, day.Add(24*time.Hour))
+	assert.Equal(t, FeeSummary{
+		Address:      address,
+		From:         &day,
+		To:           &[]time.Time{day.Add(24 * time.Hour)}[0],
+		Transactions: 2,
+		Fee:          "1000000000000000056",
+		FeeEth:       "1.000000000000000056",
+		BurntFee:     "1000000000000000016",
+		PriorityFee:  "16",
+		BlobFee:      "16",
+		L1Fee:        "8",
+	}, summary)
+
+	all := summariseFees(address, transactions, time.Time{}, time.Time{})
+	assert.Equal(t, 5, all.Transactions, "transactions without a timestamp count when the range is open")
+	assert.Nil(t, all.From)
+	assert.Nil(t, all.To)
+}
+
+func TestEthereumObserver_UpdateTransactions_transactionTypes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req EthRequestStruct
+		json.NewDecoder(r.Body).Decode(&req)
+		result := `{"number":"0x1","timestamp":"0x65e1f100","baseFeePerGas":"0x10","blobGasUsed":"0x20000","excessBlobGas":"0x0","transactions":[
+			{"hash":"0xa","type":"0x3","from":"0x1","to":"0x2","maxFeePerGas":"0x64","maxPriorityFeePerGas":"0x2",
+				"maxFeePerBlobGas":"0x5","blobVersionedHashes":["0x01aa"]},
+			{"hash":"0xb","type":"0x4","from":"0x1","to":"0x2","maxFeePerGas":"0x64","maxPriorityFeePerGas":"0x2",
+				"authorizationList":[{"chainId":"0x1","address":"0x3","nonce":"0x7","yParity":"0x1","r":"0x4","s":"0x5"}]}
+		]}`
+		switch {
+		case req.Method == "eth_getTransactionReceipt" && req.Params[0] == "0xa":
+			result = `{"transactionHash":"0xa","status":"0x1","gasUsed":"0x5208","effectiveGasPrice":"0x12","blobGasUsed":"0x20000","blobGasPrice":"0x1"}`
+		case req.Method == "eth_getTransactionReceipt":
+			// receipts of older clients lack the effective gas price
+			result = `{"transactionHash":"0xb","status":"0x1","gasUsed":"0x1"}`
+		}
+		json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Result: []byte(result)})
+	}))
+	defer ts.Close()
+
+	e := NewEthereumObserver(ts.URL, fakeStore{})
+	e.Subscribe("0x1")
+	e.UpdateTransactions(1)
+
+	transactions := e.GetTransactions("0x1")
+	require.Len(t, transactions, 2)
+	assert.Equal(t, "0x5", transactions[0].MaxFeePerBlobGas)
+	assert.Equal(t, []string{"0x01aa"}, transactions[0].BlobVersionedHashes)
+	assert.Equal(t, "0x20000", transactions[0].BlobGasUsed)
+	assert.Equal(t, "0x7c490", transactions[0].Fee)
+	assert.Equal(t, "0x52080", transactions[0].BurntFee)
+	assert.Equal(t, "0xa410", transactions[0].PriorityFee)
+	assert.Equal(t, "0x20000", transactions[0].BlobFee)
+	assert.Equal(t, "0x65e1f100", transactions[0].Timestamp)
+	assert.Equal(t, []Authorization{{ChainId: "0x1", Address: "0x3", Nonce: "0x7", YParity: "0x1", R: "0x4", S: "0x5"}}, transactions[1].AuthorizationList)
+	assert.Equal(t, "0x12", transactions[1].EffectiveGasPrice)
+	assert.Equal(t, "0x12", transactions[1].Fee)
+}
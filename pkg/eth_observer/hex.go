@@ -0,0 +1,19 @@
+package eth_observer
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// parseHexInt parses a 0x prefixed hex quantity as returned by the ethereum client
+func parseHexInt(hex string) (int, error) {
+	if !strings.HasPrefix(hex, "0x") {
+		return 0, errors.New("missing 0x prefix")
+	}
+	value, err := strconv.ParseInt(hex[2:], 16, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int(value), nil
+}
@@ -0,0 +1,82 @@
+package eth_observer
+
+import (
+	"math/big"
+
+	"github.com/aceagles/etherum_parser/pkg/chains"
+)
+
+const (
+	// opDepositType is the type of OP Stack deposit transactions, sent from L1 through the bridge
+	opDepositType = "0x7e"
+	// arbitrumDepositType is the type of Arbitrum transactions depositing ETH from L1 to their recipient
+	arbitrumDepositType = "0x64"
+)
+
+// L2Fields are the receipt fields added by L2 stacks. they are empty on Ethereum
+type L2Fields struct {
+	// L1Fee is the fee paid on OP Stack chains for posting the transaction to L1, on top of its L2 gas fee.
+	// L1GasUsed, L1GasPrice, L1BlobBaseFee and the scalars are the inputs it was computed from
+	L1Fee               string `json:"l1Fee,omitempty"`
+	L1GasUsed           string `json:"l1GasUsed,omitempty"`
+	L1GasPrice          string `json:"l1GasPrice,omitempty"`
+	L1BlobBaseFee       string `json:"l1BlobBaseFee,omitempty"`
+	L1FeeScalar         string `json:"l1FeeScalar,omitempty"`
+	L1BaseFeeScalar     string `json:"l1BaseFeeScalar,omitempty"`
+	L1BlobBaseFeeScalar string `json:"l1BlobBaseFeeScalar,omitempty"`
+	// DepositNonce and DepositReceiptVersion are set on the receipts of OP Stack deposit transactions
+	DepositNonce          string `json:"depositNonce,omitempty"`
+	DepositReceiptVersion string `json:"depositReceiptVersion,omitempty"`
+	// GasUsedForL1 is the part of gasUsed which paid for posting the transaction to L1 on Arbitrum. it is
+	// already counted in gasUsed. L1BlockNumber is the L1 block the transaction was sequenced at
+	GasUsedForL1  string `json:"gasUsedForL1,omitempty"`
+	L1BlockNumber string `json:"l1BlockNumber,omitempty"`
+}
+
+// UseStack parses the transactions and accounts the fees of the chain as run by the client stack given.
+// VerifyChain sets the stack of a well known chain when none was set
+func (e *EthereumObserver) UseStack(stack chains.Stack) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.stack = stack
+}
+
+// Stack returns the client stack of the chain observed, Ethereum unless set
+func (e *EthereumObserver) Stack() chains.Stack {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.stack == "" {
+		return chains.StackEthereum
+	}
+	return e.stack
+}
+
+// isDeposit reports whether the transaction was sent from L1 through the bridge of the stack. deposits buy their
+// gas on L1 and pay no fee on the L2
+func isDeposit(transaction Transaction, stack chains.Stack) bool {
+	switch stack {
+	case chains.StackOP:
+		return transaction.Type == opDepositType
+	case chains.StackArbitrum:
+		return transaction.Type == arbitrumDepositType
+	}
+	return false
+}
+
+// minted returns the ETH bridged from L1 by a deposit, which is credited to its sender even if the transaction
+// reverts: the mint of an OP Stack deposit or the value of an Arbitrum deposit, which the sender then transfers to
+// the recipient. it returns nil for other transactions
+func minted(transaction Transaction, stack chains.Stack) *big.Int {
+	if !isDeposit(transaction, stack) {
+		return nil
+	}
+	amount := transaction.Mint
+	if stack == chains.StackArbitrum {
+		amount = transaction.Value
+	}
+	mint, err := parseWei(amount)
+	if err != nil {
+		return nil
+	}
+	return mint
+}
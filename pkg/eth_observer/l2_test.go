@@ -0,0 +1,111 @@
+package eth_observer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aceagles/etherum_parser/pkg/chains"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newL2Server answers with the block given and the receipts by transaction hash
+func newL2Server(t *testing.T, blk string, receipts map[string]string) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req EthRequestStruct
+		json.NewDecoder(r.Body).Decode(&req)
+		result := blk
+		switch req.Method {
+		case "eth_getTransactionReceipt":
+			result = receipts[req.Params[0].(string)]
+		case "eth_getLogs":
+			result = `[]`
+		}
+		json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Result: []byte(result)})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestEthereumObserver_UpdateTransactions_opStack(t *testing.T) {
+	ts := newL2Server(t, `{"number":"0x1","timestamp":"0x65e1f100","baseFeePerGas":"0x10","transactions":[
+		{"hash":"0xa","type":"0x7e","from":"0x1","to":"0x2","value":"0x64","gas":"0xf4240","sourceHash":"0x5a","mint":"0x64","isSystemTx":false},
+		{"hash":"0xb","type":"0x2","from":"0x1","to":"0x2","maxFeePerGas":"0x64","maxPriorityFeePerGas":"0x2"}
+	]}`, map[string]string{
+		"0xa": `{"transactionHash":"0xa","status":"0x1","gasUsed":"0xb1a8","effectiveGasPrice":"0x0","depositNonce":"0x3","depositReceiptVersion":"0x1"}`,
+		"0xb": `{"transactionHash":"0xb","status":"0x1","gasUsed":"0x5208","effectiveGasPrice":"0x12","l1Fee":"0x100","l1GasUsed":"0x640",
+			"l1GasPrice":"0x3b9aca00","l1BlobBaseFee":"0x1","l1BaseFeeScalar":"0x558","l1BlobBaseFeeScalar":"0xc5fc5"}`,
+	})
+
+	e := NewEthereumObserver(ts.URL, fakeStore{})
+	e.UseStack(chains.StackOP)
+	e.Subscribe("0x1")
+	e.UpdateTransactions(1)
+
+	transactions := e.GetTransactions("0x1")
+	require.Len(t, transactions, 2)
+	deposit := transactions[0]
+	assert.Equal(t, "0x5a", deposit.SourceHash)
+	assert.Equal(t, "0x64", deposit.Mint)
+	assert.Equal(t, "0x3", deposit.DepositNonce)
+	assert.Equal(t, "0x1", deposit.DepositReceiptVersion)
+	assert.Equal(t, "0x0", deposit.Fee, "deposits buy their gas on L1")
+
+	transaction := transactions[1]
+	assert.Equal(t, L2Fields{L1Fee: "0x100", L1GasUsed: "0x640", L1GasPrice: "0x3b9aca00", L1BlobBaseFee: "0x1",
+		L1BaseFeeScalar: "0x558", L1BlobBaseFeeScalar: "0xc5fc5"}, transaction.L2Fields)
+	assert.Equal(t, "0x5c590", transaction.Fee, "the L1 fee is part of the fee")
+	assert.Equal(t, "0x52080", transaction.BurntFee)
+	assert.Equal(t, "0xa410", transaction.PriorityFee)
+}
+
+func TestEthereumObserver_UpdateTransactions_arbitrum(t *testing.T) {
+	ts := newL2Server(t, `{"number":"0x1","timestamp":"0x65e1f100","baseFeePerGas":"0x10","transactions":[
+		{"hash":"0xa","type":"0x64","from":"0x1","to":"0x2","value":"0x64","requestId":"0x7"},
+		{"hash":"0xb","type":"0x2","from":"0x1","to":"0x2","maxFeePerGas":"0x64","maxPriorityFeePerGas":"0x0"}
+	]}`, map[string]string{
+		"0xa": `{"transactionHash":"0xa","status":"0x1","gasUsed":"0x0","effectiveGasPrice":"0x10","gasUsedForL1":"0x0","l1BlockNumber":"0x12d687"}`,
+		"0xb": `{"transactionHash":"0xb","status":"0x1","gasUsed":"0x7530","effectiveGasPrice":"0x10","gasUsedForL1":"0x2328","l1BlockNumber":"0x12d687"}`,
+	})
+
+	e := NewEthereumObserver(ts.URL, fakeStore{})
+	e.UseStack(chains.StackArbitrum)
+	e.Subscribe("0x1")
+	e.UpdateTransactions(1)
+
+	transactions := e.GetTransactions("0x1")
+	require.Len(t, transactions, 2)
+	assert.Equal(t, "0x7", transactions[0].RequestId)
+	assert.Equal(t, "0x0", transactions[0].Fee)
+	assert.Equal(t, "0x2328", transactions[1].GasUsedForL1)
+	assert.Equal(t, "0x12d687", transactions[1].L1BlockNumber)
+	assert.Equal(t, "0x75300", transactions[1].Fee, "the L1 gas is already counted in the gas used")
+}
+
+func TestEthereumObserver_BalanceReport_deposits(t *testing.T) {
+	const (
+		address = "0x00000000000000000000000000000000000000aa"
+		other   = "0x00000000000000000000000000000000000000bb"
+	)
+	balances := map[string]string{"0x0": "0x0", "0x2": "0x3c"}
+	store := fakeStore{address: {
+		// the mint is credited to the sender, which then sends the value on
+		{Hash: "0xa", Type: "0x7e", From: address, To: other, Mint: "0x64", Value: "0x32", Fee: "0x0", Status: "0x1", BlockNumber: "0x1"},
+		// a reverted deposit keeps its mint
+		{Hash: "0xb", Type: "0x7e", From: address, To: other, Mint: "0x10", Value: "0x10", Fee: "0x0", Status: "0x0", BlockNumber: "0x2"},
+		{Hash: "0xc", From: address, To: other, Value: "0x1", Fee: "0x5", Status: "0x1", BlockNumber: "0x2"},
+	}}
+	e := NewEthereumObserver(newBalanceServer(t, balances).URL, store)
+	e.UseStack(chains.StackOP)
+	e.Subscribe(address)
+	e.updateLatestBlock(2)
+
+	report, err := e.BalanceReport(address)
+	require.NoError(t, err)
+	assert.Equal(t, "116", report.Inflow)
+	assert.Equal(t, "51", report.Outflow)
+	assert.Equal(t, "5", report.Fees)
+	assert.True(t, report.Reconciled, report.Discrepancy)
+}
@@ -0,0 +1,486 @@
+package eth_observer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aceagles/etherum_parser/pkg/units"
+	"github.com/aceagles/etherum_parser/pkg/websocket"
+)
+
+// PendingStatus is the state of a transaction seen in the mempool
+type PendingStatus string
+
+const (
+	// PendingStatusPending is a transaction waiting to be mined
+	PendingStatusPending PendingStatus = "pending"
+	// PendingStatusMined is a transaction included in a block read by the observer
+	PendingStatusMined PendingStatus = "mined"
+	// PendingStatusReplaced is a transaction superseded by another with the same sender and nonce,
+	// usually a speed up or cancellation paying a higher fee
+	PendingStatusReplaced PendingStatus = "replaced"
+	// PendingStatusDropped is a transaction which left the mempool without being mined
+	PendingStatusDropped PendingStatus = "dropped"
+)
+
+// PendingTransaction is a transaction from or to a subscribed address seen before it was mined
+type PendingTransaction struct {
+	Transaction
+	// Addresses are the subscribed addresses the transaction was matched for
+	Addresses []string      `json:"addresses"`
+	Status    PendingStatus `json:"status"`
+	FirstSeen time.Time     `json:"firstSeen"`
+	LastSeen  time.Time     `json:"lastSeen"`
+	// ReplacedBy is the hash of the transaction which replaced it
+	ReplacedBy string `json:"replacedBy,omitempty"`
+}
+
+// MempoolOptions configure WatchMempool
+type MempoolOptions struct {
+	// WebSocketURL of the ethereum client is used to subscribe to newPendingTransactions.
+	// when empty txpool_content is polled from the observer's endpoint instead
+	WebSocketURL string
+	// PollInterval between txpool_content requests and reconnection attempts, defaults to 2s
+	PollInterval time.Duration
+	// DropAfter is how long a transaction may go unseen in the mempool before the client is asked for it, and
+	// it is reported dropped if the client no longer knows it. defaults to 30m
+	DropAfter time.Duration
+}
+
+// hash only notifications of pending transactions are looked up with eth_getTransactionByHash by a few workers off the
+// socket's read loop. hashes arriving while pendingLookupQueue lookups are waiting are dropped, as a busy mempool
+// announces far more transactions than a client answers lookups for, and nearly all of them are of other addresses
+const (
+	pendingLookupWorkers = 4
+	pendingLookupQueue   = 1024
+)
+
+// mempool tracks the pending transactions matched for subscribed addresses until they are mined, replaced or dropped
+type mempool struct {
+	mux     sync.Mutex
+	pending map[string]*PendingTransaction // hash -> transaction
+	nonces  map[string]string              // sender/nonce -> hash
+	// replacements holds the most recent replacements of transactions sent from subscribed addresses
+	replacements map[string][]Replacement // sender -> replacements
+}
+
+// WatchMempool follows the pending transactions of the ethereum client and emits events for those from or to
+// subscribed addresses: EventPending when first seen, then one of EventPendingMined, EventPendingReplaced
+// or EventPendingDropped. it blocks until the context is cancelled
+func (e *EthereumObserver) WatchMempool(ctx context.Context, options MempoolOptions) error {
+	if options.PollInterval <= 0 {
+		options.PollInterval = 2 * time.Second
+	}
+	if options.DropAfter <= 0 {
+		options.DropAfter = 30 * time.Minute
+	}
+
+	ticker := time.NewTicker(options.PollInterval)
+	defer ticker.Stop()
+	socketErrs := make(chan error, 1)
+	connect := func() {
+		go func() {
+			err := e.watchPendingSocket(ctx, options.WebSocketURL)
+			select {
+			case socketErrs <- err:
+			case <-ctx.Done():
+			}
+		}()
+	}
+	if options.WebSocketURL != "" {
+		connect()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-socketErrs:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			slog.Error("Pending transaction subscription failed", "error", err)
+			e.emit(Event{Type: EventError, Err: err})
+			// reconnect after the poll interval
+			time.AfterFunc(options.PollInterval, connect)
+		case <-ticker.C:
+			if options.WebSocketURL == "" {
+				if err := e.pollTxpool(); err != nil {
+					slog.Error(err.Error())
+					e.emit(Event{Type: EventError, Err: err})
+				}
+			}
+			e.dropPending(time.Now().UTC().Add(-options.DropAfter))
+		}
+	}
+}
+
+// PendingTransactions returns the transactions waiting to be mined from or to an address, oldest first
+func (e *EthereumObserver) PendingTransactions(address string) []PendingTransaction {
+	e.mempool.mux.Lock()
+	defer e.mempool.mux.Unlock()
+	pending := []PendingTransaction{}
+	for _, transaction := range e.mempool.pending {
+		if slices.Contains(transaction.Addresses, address) {
+			pending = append(pending, *transaction)
+		}
+	}
+	slices.SortFunc(pending, func(a, b PendingTransaction) int {
+		return a.FirstSeen.Compare(b.FirstSeen)
+	})
+	return pending
+}
+
+// pollTxpool reads the pending and queued transactions with txpool_content
+func (e *EthereumObserver) pollTxpool() error {
+	txpoolReq := EthRequestStruct{
+		Jsonrpc: "2.0",
+		Method:  "txpool_content",
+		Params:  []interface{}{},
+		Id:      0,
+	}
+
+	response, err := e.QueryEthClient(txpoolReq)
+	if err != nil {
+		return err
+	}
+
+	// sender -> nonce -> transaction
+	var content struct {
+		Pending map[string]map[string]Transaction `json:"pending"`
+		Queued  map[string]map[string]Transaction `json:"queued"`
+	}
+	err = json.Unmarshal(response.Result, &content)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, pool := range []map[string]map[string]Transaction{content.Pending, content.Queued} {
+		for _, transactions := range pool {
+			for _, transaction := range transactions {
+				e.seePending(transaction, now)
+			}
+		}
+	}
+	return nil
+}
+
+// watchPendingSocket subscribes to newPendingTransactions over a websocket until the connection fails or the context is cancelled
+func (e *EthereumObserver) watchPendingSocket(ctx context.Context, url string) error {
+	conn, _, err := websocket.Dial(url, nil)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close(websocket.CloseGoingAway, "") })
+	defer stop()
+	defer conn.Close(websocket.CloseNormal, "")
+
+	subscribe := func(params ...interface{}) error {
+		request, _ := json.Marshal(EthRequestStruct{
+			Jsonrpc: "2.0",
+			Method:  "eth_subscribe",
+			Params:  append([]interface{}{"newPendingTransactions"}, params...),
+			Id:      len(params),
+		})
+		return conn.WriteMessage(websocket.OpText, request)
+	}
+	// ask for full transaction objects, clients which only send hashes are handled below
+	if err := subscribe(true); err != nil {
+		return err
+	}
+	lookups := make(chan string, pendingLookupQueue)
+	defer close(lookups)
+	for i := 0; i < pendingLookupWorkers; i++ {
+		go e.lookupPending(lookups)
+	}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var message struct {
+			EthResponseStruct
+			Method string `json:"method"`
+			Params struct {
+				Result json.RawMessage `json:"result"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(data, &message); err != nil {
+			return err
+		}
+		if message.Error != nil && message.Id == 1 {
+			// the client does not support full transaction objects, subscribe to hashes instead
+			if err := subscribe(); err != nil {
+				return err
+			}
+			continue
+		}
+		if message.Error != nil {
+			return fmt.Errorf("eth_subscribe error: %d %s", message.Error.Code, message.Error.Message)
+		}
+		if message.Method != "eth_subscription" {
+			continue
+		}
+
+		if bytes.HasPrefix(message.Params.Result, []byte(`"`)) {
+			var hash string
+			if err := json.Unmarshal(message.Params.Result, &hash); err != nil {
+				return err
+			}
+			e.queueLookup(lookups, hash)
+			continue
+		}
+		var transaction Transaction
+		if err := json.Unmarshal(message.Params.Result, &transaction); err != nil {
+			return err
+		}
+		e.seePending(transaction, time.Now().UTC())
+	}
+}
+
+// queueLookup queues the lookup of a pending transaction announced by its hash, dropping it when the queue is full
+func (e *EthereumObserver) queueLookup(lookups chan<- string, hash string) {
+	select {
+	case lookups <- hash:
+	default:
+		e.metrics.pendingLookupsDropped.Add(1)
+	}
+}
+
+// lookupPending reads the pending transactions of the hashes queued until the channel is closed
+func (e *EthereumObserver) lookupPending(lookups <-chan string) {
+	for hash := range lookups {
+		transaction, err := e.getTransaction(hash)
+		if errors.Is(err, errTransactionNotFound) {
+			continue
+		}
+		if err != nil {
+			slog.Error(err.Error())
+			continue
+		}
+		e.seePending(transaction, time.Now().UTC())
+	}
+}
+
+// errTransactionNotFound is returned by getTransaction when the client does not know the transaction
+var errTransactionNotFound = errors.New("transaction not found")
+
+// getTransaction returns a transaction given its hash
+func (e *EthereumObserver) getTransaction(hash string) (Transaction, error) {
+	transactionReq := EthRequestStruct{
+		Jsonrpc: "2.0",
+		Method:  "eth_getTransactionByHash",
+		Params:  []interface{}{hash},
+		Id:      0,
+	}
+
+	response, err := e.QueryEthClient(transactionReq)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if bytes.Equal(response.Result, []byte("null")) {
+		return Transaction{}, errTransactionNotFound
+	}
+
+	var transaction Transaction
+	err = json.Unmarshal(response.Result, &transaction)
+	if err != nil {
+		return Transaction{}, err
+	}
+	return transaction, nil
+}
+
+// seePending records a transaction seen in the mempool. a transaction from or to a subscribed address is tracked
+// and emitted as pending, and a tracked transaction with the same sender and nonce is reported replaced when the
+// new one pays a higher fee. a transaction with the nonce of a tracked one which does not pay more is ignored, as
+// the client would refuse it
+func (e *EthereumObserver) seePending(transaction Transaction, now time.Time) {
+	var addresses []string
+	for address := range e.collectSubscribedAddresses([]Transaction{transaction}) {
+		addresses = append(addresses, address)
+	}
+	slices.Sort(addresses)
+
+	var events []Event
+	e.mempool.mux.Lock()
+	if e.mempool.pending == nil {
+		e.mempool.pending = make(map[string]*PendingTransaction)
+		e.mempool.nonces = make(map[string]string)
+	}
+	if seen, ok := e.mempool.pending[transaction.Hash]; ok {
+		seen.LastSeen = now
+		e.mempool.mux.Unlock()
+		return
+	}
+	key := nonceKey(transaction)
+	if hash, ok := e.mempool.nonces[key]; ok {
+		if !paysMore(transaction, e.mempool.pending[hash].Transaction) {
+			e.mempool.mux.Unlock()
+			return
+		}
+		e.recordReplacement(hash, transaction, 0, now)
+		events = append(events, e.resolvePending(hash, PendingStatusReplaced, transaction.Hash, 0)...)
+	}
+	if len(addresses) > 0 {
+		pending := &PendingTransaction{
+			Transaction: transaction,
+			Addresses:   addresses,
+			Status:      PendingStatusPending,
+			FirstSeen:   now,
+			LastSeen:    now,
+		}
+		e.mempool.pending[transaction.Hash] = pending
+		e.mempool.nonces[key] = transaction.Hash
+		events = append(events, pendingEvents(EventPending, *pending, 0)...)
+	}
+	e.mempool.mux.Unlock()
+
+	e.emitPending(events)
+}
+
+// nonceKey identifies the transactions of a sender with the same nonce in the mempool
+func nonceKey(transaction Transaction) string {
+	return strings.ToLower(transaction.From) + "/" + transaction.Nonce
+}
+
+// paysMore reports whether a transaction pays a higher fee than the transaction with the same nonce it replaces: both a
+// higher fee cap and a higher priority fee, which is the gas price of legacy transactions. transactions whose fees are
+// missing or malformed cannot be compared and are taken to pay more
+func paysMore(replacement, replaced Transaction) bool {
+	newCap, newTip, ok := gasFees(replacement)
+	if !ok {
+		return true
+	}
+	oldCap, oldTip, ok := gasFees(replaced)
+	if !ok {
+		return true
+	}
+	return newCap.Cmp(oldCap) > 0 && newTip.Cmp(oldTip) > 0
+}
+
+// gasFees returns the fee cap and priority fee per gas of a transaction, both the gas price for legacy transactions
+func gasFees(transaction Transaction) (feeCap, tip *big.Int, ok bool) {
+	if transaction.MaxFeePerGas != "" {
+		feeCap, err := units.ParseQuantity(transaction.MaxFeePerGas)
+		if err != nil {
+			return nil, nil, false
+		}
+		tip, err := units.ParseQuantity(transaction.MaxPriorityFeePerGas)
+		if err != nil {
+			return nil, nil, false
+		}
+		return feeCap, tip, true
+	}
+	price, err := units.ParseQuantity(transaction.GasPrice)
+	if err != nil {
+		return nil, nil, false
+	}
+	return price, price, true
+}
+
+// minePending resolves the tracked transactions included in a block, or replaced by a transaction in it
+func (e *EthereumObserver) minePending(blockNum int, transactions []Transaction) {
+	var events []Event
+	e.mempool.mux.Lock()
+	if len(e.mempool.pending) == 0 {
+		e.mempool.mux.Unlock()
+		return
+	}
+	for _, transaction := range transactions {
+		if _, ok := e.mempool.pending[transaction.Hash]; ok {
+			events = append(events, e.resolvePending(transaction.Hash, PendingStatusMined, "", blockNum)...)
+		} else if hash, ok := e.mempool.nonces[nonceKey(transaction)]; ok {
+			e.recordReplacement(hash, transaction, blockNum, time.Now().UTC())
+			events = append(events, e.resolvePending(hash, PendingStatusReplaced, transaction.Hash, blockNum)...)
+		}
+	}
+	e.mempool.mux.Unlock()
+
+	e.emitPending(events)
+}
+
+// dropPending reports the tracked transactions last seen before the cutoff and unknown to the client as dropped.
+// the websocket only announces a transaction once, so a stale transaction is looked up with eth_getTransactionByHash
+// first and kept, with LastSeen refreshed, while the client still knows it
+func (e *EthereumObserver) dropPending(cutoff time.Time) {
+	var stale []string
+	e.mempool.mux.Lock()
+	for hash, pending := range e.mempool.pending {
+		if pending.LastSeen.Before(cutoff) {
+			stale = append(stale, hash)
+		}
+	}
+	e.mempool.mux.Unlock()
+
+	var events []Event
+	for _, hash := range stale {
+		_, err := e.getTransaction(hash)
+		if err != nil && !errors.Is(err, errTransactionNotFound) {
+			// try again on the next tick rather than report a transaction dropped because the client failed
+			slog.Error(err.Error())
+			continue
+		}
+		e.mempool.mux.Lock()
+		if pending, ok := e.mempool.pending[hash]; ok && pending.LastSeen.Before(cutoff) {
+			if err == nil {
+				pending.LastSeen = time.Now().UTC()
+			} else {
+				events = append(events, e.resolvePending(hash, PendingStatusDropped, "", 0)...)
+			}
+		}
+		e.mempool.mux.Unlock()
+	}
+
+	e.emitPending(events)
+}
+
+// resolvePending stops tracking a transaction and returns the events reporting its new status. the caller must hold e.mempool.mux
+func (e *EthereumObserver) resolvePending(hash string, status PendingStatus, replacedBy string, blockNum int) []Event {
+	pending, ok := e.mempool.pending[hash]
+	if !ok {
+		return nil
+	}
+	delete(e.mempool.pending, hash)
+	delete(e.mempool.nonces, nonceKey(pending.Transaction))
+	pending.Status = status
+	pending.ReplacedBy = replacedBy
+
+	eventType := map[PendingStatus]EventType{
+		PendingStatusMined:    EventPendingMined,
+		PendingStatusReplaced: EventPendingReplaced,
+		PendingStatusDropped:  EventPendingDropped,
+	}[status]
+	return pendingEvents(eventType, *pending, blockNum)
+}
+
+// pendingEvents returns an event for each subscribed address of a pending transaction
+func pendingEvents(eventType EventType, pending PendingTransaction, blockNum int) []Event {
+	events := make([]Event, 0, len(pending.Addresses))
+	for _, address := range pending.Addresses {
+		pending := pending
+		events = append(events, Event{Type: eventType, BlockNumber: blockNum, Address: address, Pending: &pending})
+	}
+	return events
+}
+
+// emitPending emits the events of pending transactions along with the subscriptions of the address whose rules select them
+func (e *EthereumObserver) emitPending(events []Event) {
+	for _, event := range events {
+		for _, subscription := range e.ListSubscriptions(SubscriptionFilter{Address: event.Address}) {
+			if annotated := annotate([]Transaction{event.Pending.Transaction}, subscription); len(annotated) == 1 && subscription.Notifies(annotated[0]) {
+				event.Subscriptions = append(event.Subscriptions, subscription)
+			}
+		}
+		e.emit(event)
+	}
+}
@@ -0,0 +1,196 @@
+package eth_observer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aceagles/etherum_parser/pkg/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEthereumObserver_pendingLifecycle(t *testing.T) {
+	// the client still knows 0xf0 and has forgotten every other transaction
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req EthRequestStruct
+		json.NewDecoder(r.Body).Decode(&req)
+		result := `null`
+		if req.Method == "eth_getTransactionByHash" && req.Params[0] == "0xf0" {
+			result = `{"hash": "0xf0", "from": "0x1", "to": "0x9", "nonce": "0x3", "blockNumber": null}`
+		}
+		json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Result: []byte(result)})
+	}))
+	defer ts.Close()
+	e := NewEthereumObserver(ts.URL, fakeStore{})
+	e.Tenant("acme").Subscribe("0x1")
+	events := e.Events(EventOptions{})
+	defer events.Cancel()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// transactions of other addresses are ignored
+	e.seePending(Transaction{Hash: "0xf", From: "0x8", To: "0x9", Nonce: "0x1"}, now)
+	e.seePending(Transaction{Hash: "0xa", From: "0x1", To: "0x9", Nonce: "0x1"}, now)
+	event := receive(t, events)
+	assert.Equal(t, EventPending, event.Type)
+	assert.Equal(t, "0x1", event.Address)
+	assert.Equal(t, "0xa", event.Pending.Hash)
+	assert.Equal(t, PendingStatusPending, event.Pending.Status)
+	require.Len(t, event.Subscriptions, 1)
+	assert.Equal(t, "acme", event.Subscriptions[0].Owner)
+	assert.Len(t, e.PendingTransactions("0x1"), 1)
+
+	// seen again, then sped up with the same nonce
+	e.seePending(Transaction{Hash: "0xa", From: "0x1", To: "0x9", Nonce: "0x1"}, now.Add(time.Minute))
+	e.seePending(Transaction{Hash: "0xb", From: "0x1", To: "0x9", Nonce: "0x1"}, now.Add(time.Minute))
+	event = receive(t, events)
+	assert.Equal(t, EventPendingReplaced, event.Type)
+	assert.Equal(t, "0xa", event.Pending.Hash)
+	assert.Equal(t, "0xb", event.Pending.ReplacedBy)
+	assert.Equal(t, now.Add(time.Minute), event.Pending.LastSeen)
+	assert.Equal(t, EventPending, receive(t, events).Type)
+
+	e.seePending(Transaction{Hash: "0xc", From: "0x7", To: "0x1", Nonce: "0x4"}, now)
+	assert.Equal(t, EventPending, receive(t, events).Type)
+	e.seePending(Transaction{Hash: "0xd", From: "0x1", To: "0x9", Nonce: "0x2"}, now)
+	assert.Equal(t, EventPending, receive(t, events).Type)
+	e.seePending(Transaction{Hash: "0xf0", From: "0x1", To: "0x9", Nonce: "0x3"}, now)
+	assert.Equal(t, EventPending, receive(t, events).Type)
+
+	// 0xb is mined and 0xc is cancelled by a transaction to another address mined in the same block
+	e.minePending(7, []Transaction{{Hash: "0xb", From: "0x1", Nonce: "0x1"}, {Hash: "0xe", From: "0x7", To: "0x7", Nonce: "0x4"}})
+	event = receive(t, events)
+	assert.Equal(t, EventPendingMined, event.Type)
+	assert.Equal(t, 7, event.BlockNumber)
+	assert.Equal(t, "0xb", event.Pending.Hash)
+	event = receive(t, events)
+	assert.Equal(t, EventPendingReplaced, event.Type)
+	assert.Equal(t, "0xc", event.Pending.Hash)
+	assert.Equal(t, "0xe", event.Pending.ReplacedBy)
+
+	// 0xf0 is still pending on the client although it was not seen again
+	e.dropPending(now.Add(time.Second))
+	event = receive(t, events)
+	assert.Equal(t, EventPendingDropped, event.Type)
+	assert.Equal(t, "0xd", event.Pending.Hash)
+	pending := e.PendingTransactions("0x1")
+	require.Len(t, pending, 1)
+	assert.Equal(t, "0xf0", pending[0].Hash)
+	assert.True(t, pending[0].LastSeen.After(now.Add(time.Second)), "LastSeen is refreshed")
+	assert.Empty(t, events.C())
+}
+
+func TestEthereumObserver_seePending_replacementFee(t *testing.T) {
+	e := NewEthereumObserver("", fakeStore{})
+	e.Subscribe("0x1")
+	events := e.Events(EventOptions{})
+	defer events.Cancel()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	e.seePending(Transaction{Hash: "0xa", From: "0x1", To: "0x9", Nonce: "0x1", MaxFeePerGas: "0x64", MaxPriorityFeePerGas: "0xa"}, now)
+	assert.Equal(t, EventPending, receive(t, events).Type)
+
+	// the same fees, or a higher fee cap without a higher priority fee, do not replace the transaction.
+	// the sender is matched in any case
+	e.seePending(Transaction{Hash: "0xb", From: "0x1", To: "0x1", Nonce: "0x1", MaxFeePerGas: "0x64", MaxPriorityFeePerGas: "0xa"}, now)
+	e.seePending(Transaction{Hash: "0xc", From: "0X1", To: "0x1", Nonce: "0x1", MaxFeePerGas: "0xc8", MaxPriorityFeePerGas: "0xa"}, now)
+	e.seePending(Transaction{Hash: "0xd", From: "0x1", To: "0x1", Nonce: "0x1", GasPrice: "0x50"}, now)
+	assert.Empty(t, events.C())
+	pending := e.PendingTransactions("0x1")
+	require.Len(t, pending, 1)
+	assert.Equal(t, "0xa", pending[0].Hash)
+
+	// a legacy transaction paying more than both fees of the tracked one replaces it
+	e.seePending(Transaction{Hash: "0xe", From: "0X1", To: "0x1", Nonce: "0x1", GasPrice: "0x6e"}, now)
+	event := receive(t, events)
+	assert.Equal(t, EventPendingReplaced, event.Type)
+	assert.Equal(t, "0xa", event.Pending.Hash)
+	assert.Equal(t, "0xe", event.Pending.ReplacedBy)
+}
+
+func TestEthereumObserver_queueLookup(t *testing.T) {
+	e := NewEthereumObserver("", fakeStore{})
+	lookups := make(chan string, 2)
+	for _, hash := range []string{"0xa", "0xb", "0xc"} {
+		e.queueLookup(lookups, hash)
+	}
+	assert.Len(t, lookups, 2)
+	assert.Equal(t, int64(1), e.Metrics().PendingLookupsDropped, "hashes arriving while the queue is full are dropped")
+}
+
+func TestEthereumObserver_pollTxpool(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Result: []byte(`{
+			"pending": {"0x1": {"5": {"hash": "0xa", "from": "0x1", "to": "0x9", "nonce": "0x5"}}},
+			"queued": {"0x8": {"9": {"hash": "0xb", "from": "0x8", "to": "0x1", "nonce": "0x9"}}, "0x7": {"1": {"hash": "0xc", "from": "0x7", "nonce": "0x1"}}}
+		}`)})
+	}))
+	defer ts.Close()
+	e := NewEthereumObserver(ts.URL, fakeStore{})
+	e.Subscribe("0x1")
+
+	assert.NoError(t, e.pollTxpool())
+	pending := e.PendingTransactions("0x1")
+	assert.Len(t, pending, 2)
+	assert.Empty(t, e.PendingTransactions("0x7"))
+}
+
+func TestEthereumObserver_WatchMempool(t *testing.T) {
+	rpc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req EthRequestStruct
+		json.NewDecoder(r.Body).Decode(&req)
+		result := `null`
+		if req.Method == "eth_getTransactionByHash" && req.Params[0] == "0xb" {
+			result = `{"hash": "0xb", "from": "0x8", "to": "0x1", "nonce": "0x1"}`
+		}
+		json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Result: []byte(result)})
+	}))
+	defer rpc.Close()
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close(websocket.CloseNormal, "")
+		// full transaction objects are refused so the watcher falls back to hashes
+		_, data, err := conn.ReadMessage()
+		if err != nil || !strings.Contains(string(data), `"params":["newPendingTransactions",true]`) {
+			return
+		}
+		conn.WriteMessage(websocket.OpText, []byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32602,"message":"invalid argument"}}`))
+		_, data, err = conn.ReadMessage()
+		if err != nil || !strings.Contains(string(data), `"params":["newPendingTransactions"]`) {
+			return
+		}
+		for _, message := range []string{
+			`{"jsonrpc":"2.0","id":0,"result":"0x99"}`,
+			`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0x99","result":{"hash":"0xa","from":"0x1","nonce":"0x1"}}}`,
+			`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0x99","result":"0xc"}}`,
+			`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0x99","result":"0xb"}}`,
+		} {
+			conn.WriteMessage(websocket.OpText, []byte(message))
+		}
+		conn.ReadMessage()
+	}))
+	defer node.Close()
+
+	e := NewEthereumObserver(rpc.URL, fakeStore{})
+	e.Subscribe("0x1")
+	events := e.Events(EventOptions{Types: []EventType{EventPending}})
+	defer events.Cancel()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- e.WatchMempool(ctx, MempoolOptions{WebSocketURL: "ws" + strings.TrimPrefix(node.URL, "http")})
+	}()
+
+	assert.Equal(t, "0xa", receive(t, events).Pending.Hash)
+	// the hash only notification is resolved with eth_getTransactionByHash, unknown hashes are skipped
+	assert.Equal(t, "0xb", receive(t, events).Pending.Hash)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
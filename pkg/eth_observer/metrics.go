@@ -0,0 +1,47 @@
+package eth_observer
+
+import "sync/atomic"
+
+// metrics counts the work done by the observer. the counters are updated without holding e.mux
+type metrics struct {
+	blocksRead   atomic.Int64
+	bloomSkipped atomic.Int64
+	logFetches   atomic.Int64
+	// pendingLookupsDropped counts the pending transaction hashes not looked up as the lookup queue was full
+	pendingLookupsDropped atomic.Int64
+}
+
+// Metrics is a snapshot of the observer's counters
+type Metrics struct {
+	// LatestBlock is the last parsed block, Head the latest block reported by the ethereum client and Lag the
+	// number of blocks between them
+	LatestBlock int `json:"latestBlock"`
+	Head        int `json:"head"`
+	Lag         int `json:"lag"`
+	// BlocksRead is the number of blocks read and matched against the subscribed addresses
+	BlocksRead int64 `json:"blocksRead"`
+	// BloomSkipped is the number of blocks whose logs bloom ruled out every subscribed address, so that their logs
+	// were not fetched, and LogFetches the number of blocks whose logs were fetched
+	BloomSkipped int64 `json:"bloomSkipped"`
+	LogFetches   int64 `json:"logFetches"`
+	// BloomSkipRate is the share of the blocks checked against their logs bloom which were skipped, from 0 to 1
+	BloomSkipRate float64 `json:"bloomSkipRate"`
+	// PendingLookupsDropped is the number of pending transactions announced by hash over the websocket which were
+	// not looked up because the lookups already queued had not been answered
+	PendingLookupsDropped int64 `json:"pendingLookupsDropped"`
+}
+
+// Metrics returns a snapshot of the observer's counters
+func (e *EthereumObserver) Metrics() Metrics {
+	e.mux.Lock()
+	m := Metrics{LatestBlock: e.latestBlock, Head: e.head, Lag: e.lag}
+	e.mux.Unlock()
+	m.BlocksRead = e.metrics.blocksRead.Load()
+	m.BloomSkipped = e.metrics.bloomSkipped.Load()
+	m.LogFetches = e.metrics.logFetches.Load()
+	m.PendingLookupsDropped = e.metrics.pendingLookupsDropped.Load()
+	if checked := m.BloomSkipped + m.LogFetches; checked > 0 {
+		m.BloomSkipRate = float64(m.BloomSkipped) / float64(checked)
+	}
+	return m
+}
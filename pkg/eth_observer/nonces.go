@@ -0,0 +1,326 @@
+package eth_observer
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stuckAfter is how long the next nonce of an address may wait to be mined before it is reported stuck
+const stuckAfter = 10 * time.Minute
+
+// maxReplacements is the number of replacements kept per address
+const maxReplacements = 50
+
+// maxMissingNonces is the number of missing nonces listed in a NonceStatus
+const maxMissingNonces = 100
+
+// ReplacementKind tells a speed up from a cancellation
+type ReplacementKind string
+
+const (
+	// ReplacementSpeedUp resends the transaction with a higher fee
+	ReplacementSpeedUp ReplacementKind = "speedUp"
+	// ReplacementCancel replaces the transaction with an empty transfer to the sender
+	ReplacementCancel ReplacementKind = "cancel"
+)
+
+// Replacement records a transaction sent from a subscribed address which was replaced by another with the same nonce
+type Replacement struct {
+	Nonce      int             `json:"nonce"`
+	Hash       string          `json:"hash"`
+	ReplacedBy string          `json:"replacedBy"`
+	Kind       ReplacementKind `json:"kind"`
+	// BlockNumber is the block the replacement was mined in, zero if it was seen in the mempool
+	BlockNumber int       `json:"blockNumber,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// PendingNonce is an outgoing transaction waiting in the mempool
+type PendingNonce struct {
+	Nonce     int       `json:"nonce"`
+	Hash      string    `json:"hash"`
+	FirstSeen time.Time `json:"firstSeen"`
+}
+
+// NonceStatus describes the nonces of the transactions sent from an address
+type NonceStatus struct {
+	Address string `json:"address"`
+	// Nonce is the next nonce the chain expects from the address and PendingNonce
+	// the next nonce counting the transactions in the client's mempool
+	Nonce        int `json:"nonce"`
+	PendingNonce int `json:"pendingNonce"`
+	// LastMinedNonce is the highest nonce of the outgoing transactions stored, nil without any
+	LastMinedNonce *int `json:"lastMinedNonce"`
+	// MissingNonces are nonces between the first and last stored outgoing transaction without a stored
+	// transaction, they usually mean blocks were missed
+	MissingNonces []int `json:"missingNonces"`
+	// Pending are the outgoing transactions seen in the mempool ordered by nonce
+	Pending []PendingNonce `json:"pending"`
+	// Gaps are the nonces missing from the mempool before a pending transaction, which cannot be mined until they are filled
+	Gaps []int `json:"gaps"`
+	// Stuck is set when there are gaps or the next nonce has been pending for more than 10 minutes, either in the
+	// mempool or with the pending nonce ahead of the latest one over the calls made in that time
+	Stuck        bool          `json:"stuck"`
+	Replacements []Replacement `json:"replacements"`
+}
+
+// NonceStatus returns the nonce status of an address from the transaction counts of the ethereum client, the outgoing
+// transactions stored for it and, when WatchMempool is running, the outgoing transactions in the mempool, regardless of tenant.
+// without the mempool the address is reported stuck once its pending nonce has stayed ahead of the same latest nonce for
+// 10 minutes across calls, and replacements are only found among the stored transactions
+func (e *EthereumObserver) NonceStatus(address string) (NonceStatus, error) {
+	return e.nonceStatus(address, e.GetTransactions(address), func(Replacement) bool { return true })
+}
+
+// NonceStatus returns the nonce status of an address the tenant is subscribed to from the records the tenant can see:
+// the outgoing transactions and replacements mined from the start block of its subscription and the replacements seen
+// in the mempool since it was created. the transaction counts and the pending transactions are the current state of
+// the chain and are not scoped
+func (t *Tenant) NonceStatus(address string) (NonceStatus, error) {
+	subscription, ok := t.GetSubscription(address)
+	if !ok {
+		return NonceStatus{}, errNotSubscribed
+	}
+	return t.observer.nonceStatus(address, t.GetTransactions(address), func(replacement Replacement) bool {
+		if replacement.BlockNumber > 0 {
+			return replacement.BlockNumber >= subscription.StartBlock
+		}
+		return !replacement.Time.Before(subscription.CreatedAt)
+	})
+}
+
+// nonceStatus returns the nonce status of an address from the stored transactions given and the replacements passing visible
+func (e *EthereumObserver) nonceStatus(address string, transactions []Transaction, visible func(Replacement) bool) (NonceStatus, error) {
+	address = strings.ToLower(address)
+	nonce, err := e.getTransactionCount(address, "latest")
+	if err != nil {
+		return NonceStatus{}, err
+	}
+	pendingNonce, err := e.getTransactionCount(address, "pending")
+	if err != nil {
+		return NonceStatus{}, err
+	}
+	status := NonceStatus{
+		Address:       address,
+		Nonce:         nonce,
+		PendingNonce:  pendingNonce,
+		MissingNonces: []int{},
+		Pending:       []PendingNonce{},
+		Gaps:          []int{},
+		Replacements:  []Replacement{},
+	}
+
+	var mined []int
+	for _, transaction := range transactions {
+		if !strings.EqualFold(transaction.From, address) {
+			continue
+		}
+		if nonce, err := parseHexInt(transaction.Nonce); err == nil {
+			mined = append(mined, nonce)
+		}
+	}
+	slices.Sort(mined)
+	mined = slices.Compact(mined)
+	if len(mined) > 0 {
+		last := mined[len(mined)-1]
+		status.LastMinedNonce = &last
+		for i := 1; i < len(mined) && len(status.MissingNonces) < maxMissingNonces; i++ {
+			for missing := mined[i-1] + 1; missing < mined[i] && len(status.MissingNonces) < maxMissingNonces; missing++ {
+				status.MissingNonces = append(status.MissingNonces, missing)
+			}
+		}
+	}
+
+	e.mempool.mux.Lock()
+	for _, pending := range e.mempool.pending {
+		if !strings.EqualFold(pending.From, address) {
+			continue
+		}
+		if nonce, err := parseHexInt(pending.Nonce); err == nil && nonce >= status.Nonce {
+			status.Pending = append(status.Pending, PendingNonce{Nonce: nonce, Hash: pending.Hash, FirstSeen: pending.FirstSeen})
+		}
+	}
+	for _, replacement := range e.mempool.replacements[address] {
+		if visible(replacement) {
+			status.Replacements = append(status.Replacements, replacement)
+		}
+	}
+	e.mempool.mux.Unlock()
+
+	known := make(map[[2]string]bool, len(status.Replacements))
+	for _, replacement := range status.Replacements {
+		known[[2]string{replacement.Hash, replacement.ReplacedBy}] = true
+	}
+	for _, replacement := range minedReplacements(address, transactions) {
+		if !known[[2]string{replacement.Hash, replacement.ReplacedBy}] && visible(replacement) {
+			status.Replacements = append(status.Replacements, replacement)
+		}
+	}
+	slices.SortStableFunc(status.Replacements, func(a, b Replacement) int { return a.Time.Compare(b.Time) })
+
+	slices.SortFunc(status.Pending, func(a, b PendingNonce) int { return a.Nonce - b.Nonce })
+	expected := status.Nonce
+	for _, pending := range status.Pending {
+		for ; expected < pending.Nonce; expected++ {
+			status.Gaps = append(status.Gaps, expected)
+		}
+		expected = pending.Nonce + 1
+	}
+	stalledSince := e.stalls.observe(address, status.Nonce, status.PendingNonce, time.Now().UTC())
+	status.Stuck = len(status.Gaps) > 0 ||
+		len(status.Pending) > 0 && status.Pending[0].Nonce == status.Nonce && time.Since(status.Pending[0].FirstSeen) > stuckAfter ||
+		!stalledSince.IsZero() && time.Since(stalledSince) > stuckAfter
+	return status, nil
+}
+
+// stalls remembers since when the pending nonce of each address has been ahead of the same latest nonce, which
+// tells that its next transaction is waiting to be mined without watching the mempool
+type stalls struct {
+	mux   sync.Mutex
+	since map[string]stall // address -> stall
+}
+
+// stall is the latest nonce of an address with transactions pending and when it was first read
+type stall struct {
+	nonce int
+	since time.Time
+}
+
+// observe records the latest and pending nonces read for an address and returns since when its next nonce has
+// been waiting to be mined, zero when no transaction is pending
+func (s *stalls) observe(address string, nonce, pendingNonce int, now time.Time) time.Time {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if pendingNonce <= nonce {
+		delete(s.since, address)
+		return time.Time{}
+	}
+	if s.since == nil {
+		s.since = make(map[string]stall)
+	}
+	current, ok := s.since[address]
+	if !ok || current.nonce != nonce {
+		current = stall{nonce: nonce, since: now}
+		s.since[address] = current
+	}
+	return current.since
+}
+
+// minedReplacements returns the replacements found among the outgoing transactions stored for an address. transactions
+// sharing a nonce, such as one mined in a block later reorganised away and the one mined in its place, are replaced by
+// the transaction mined last
+func minedReplacements(address string, transactions []Transaction) []Replacement {
+	byNonce := make(map[int][]Transaction)
+	var nonces []int
+	for _, transaction := range transactions {
+		if !strings.EqualFold(transaction.From, address) {
+			continue
+		}
+		nonce, err := parseHexInt(transaction.Nonce)
+		if err != nil {
+			continue
+		}
+		if _, ok := byNonce[nonce]; !ok {
+			nonces = append(nonces, nonce)
+		}
+		if !slices.ContainsFunc(byNonce[nonce], func(t Transaction) bool { return t.Hash == transaction.Hash }) {
+			byNonce[nonce] = append(byNonce[nonce], transaction)
+		}
+	}
+	slices.Sort(nonces)
+
+	var replacements []Replacement
+	for _, nonce := range nonces {
+		sent := byNonce[nonce]
+		if len(sent) < 2 {
+			continue
+		}
+		slices.SortStableFunc(sent, func(a, b Transaction) int {
+			blockA, _ := parseHexInt(a.BlockNumber)
+			blockB, _ := parseHexInt(b.BlockNumber)
+			return blockA - blockB
+		})
+		last := sent[len(sent)-1]
+		blockNum, _ := parseHexInt(last.BlockNumber)
+		var minedAt time.Time
+		if timestamp, err := parseHexInt(last.Timestamp); err == nil {
+			minedAt = time.Unix(int64(timestamp), 0).UTC()
+		}
+		for _, replaced := range sent[:len(sent)-1] {
+			replacements = append(replacements, Replacement{
+				Nonce:       nonce,
+				Hash:        replaced.Hash,
+				ReplacedBy:  last.Hash,
+				Kind:        replacementKind(last),
+				BlockNumber: blockNum,
+				Time:        minedAt,
+			})
+		}
+	}
+	return replacements
+}
+
+// recordReplacement records the replacement of a tracked transaction sent from a subscribed address. the caller must hold e.mempool.mux
+func (e *EthereumObserver) recordReplacement(hash string, replacement Transaction, blockNum int, now time.Time) {
+	pending, ok := e.mempool.pending[hash]
+	if !ok || !slices.Contains(pending.Addresses, pending.From) {
+		return
+	}
+	nonce, err := parseHexInt(pending.Nonce)
+	if err != nil {
+		return
+	}
+	if e.mempool.replacements == nil {
+		e.mempool.replacements = make(map[string][]Replacement)
+	}
+	replacements := append(e.mempool.replacements[pending.From], Replacement{
+		Nonce:       nonce,
+		Hash:        hash,
+		ReplacedBy:  replacement.Hash,
+		Kind:        replacementKind(replacement),
+		BlockNumber: blockNum,
+		Time:        now,
+	})
+	if len(replacements) > maxReplacements {
+		replacements = replacements[len(replacements)-maxReplacements:]
+	}
+	e.mempool.replacements[pending.From] = replacements
+}
+
+// replacementKind tells whether a replacing transaction cancels the one it replaces, an empty transfer to the sender, or speeds it up
+func replacementKind(replacement Transaction) ReplacementKind {
+	if strings.EqualFold(replacement.To, replacement.From) && isZero(replacement.Value) {
+		return ReplacementCancel
+	}
+	return ReplacementSpeedUp
+}
+
+// getTransactionCount returns the number of transactions sent from an address at the block tag, the next nonce it will use
+func (e *EthereumObserver) getTransactionCount(address string, blockTag string) (int, error) {
+	countReq := EthRequestStruct{
+		Jsonrpc: "2.0",
+		Method:  "eth_getTransactionCount",
+		Params:  []interface{}{address, blockTag},
+		Id:      0,
+	}
+
+	response, err := e.QueryEthClient(countReq)
+	if err != nil {
+		return 0, err
+	}
+
+	var count string
+	err = json.Unmarshal(response.Result, &count)
+	if err != nil {
+		return 0, err
+	}
+	return parseHexInt(count)
+}
+
+// isZero reports whether a hex quantity is zero or empty
+func isZero(value string) bool {
+	return strings.TrimLeft(strings.TrimPrefix(value, "0x"), "0") == ""
+}
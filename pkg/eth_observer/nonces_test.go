@@ -0,0 +1,142 @@
+package eth_observer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEthereumObserver_NonceStatus(t *testing.T) {
+	const address = "0x00000000000000000000000000000000000000aa"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req EthRequestStruct
+		json.NewDecoder(r.Body).Decode(&req)
+		count := map[interface{}]string{"latest": `"0x5"`, "pending": `"0x9"`}[req.Params[1]]
+		json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Result: []byte(count)})
+	}))
+	defer ts.Close()
+	store := fakeStore{address: {
+		{Hash: "0x1", From: address, Nonce: "0x0"},
+		{Hash: "0x2", From: address, Nonce: "0x1"},
+		{Hash: "0x3", From: "0x00000000000000000000000000000000000000bb", To: address, Nonce: "0x7"},
+		{Hash: "0x4", From: address, Nonce: "0x4"},
+	}}
+	e := NewEthereumObserver(ts.URL, store)
+	e.Subscribe(address)
+
+	status, err := e.NonceStatus(address)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, status.Nonce)
+	assert.Equal(t, 9, status.PendingNonce)
+	assert.Equal(t, 4, *status.LastMinedNonce)
+	assert.Equal(t, []int{2, 3}, status.MissingNonces)
+	assert.Empty(t, status.Pending)
+	assert.False(t, status.Stuck)
+
+	// the next nonce waits in the mempool, then is cancelled
+	now := time.Now().UTC()
+	e.seePending(Transaction{Hash: "0xa", From: address, To: "0x9", Nonce: "0x5", Value: "0x1"}, now.Add(-time.Hour))
+	status, _ = e.NonceStatus(address)
+	assert.Equal(t, []PendingNonce{{Nonce: 5, Hash: "0xa", FirstSeen: now.Add(-time.Hour)}}, status.Pending)
+	assert.True(t, status.Stuck)
+	e.seePending(Transaction{Hash: "0xb", From: address, To: address, Nonce: "0x5", Value: "0x0"}, now)
+	status, _ = e.NonceStatus(address)
+	assert.False(t, status.Stuck)
+	assert.Equal(t, []Replacement{{Nonce: 5, Hash: "0xa", ReplacedBy: "0xb", Kind: ReplacementCancel, Time: now}}, status.Replacements)
+
+	// a transaction queued behind a missing nonce
+	e.seePending(Transaction{Hash: "0xc", From: address, To: "0x9", Nonce: "0x8"}, now)
+	status, _ = e.NonceStatus(address)
+	assert.Equal(t, []int{6, 7}, status.Gaps)
+	assert.True(t, status.Stuck)
+
+	// a speed up mined in a block
+	e.minePending(10, []Transaction{{Hash: "0xd", From: address, To: "0x9", Nonce: "0x8", Value: "0x1"}})
+	status, _ = e.NonceStatus(address)
+	assert.Len(t, status.Replacements, 2)
+	assert.Equal(t, ReplacementSpeedUp, status.Replacements[1].Kind)
+	assert.Equal(t, 10, status.Replacements[1].BlockNumber)
+}
+
+func TestEthereumObserver_NonceStatus_withoutMempool(t *testing.T) {
+	const address = "0x00000000000000000000000000000000000000aa"
+	var latest atomic.Int32
+	latest.Store(4)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req EthRequestStruct
+		json.NewDecoder(r.Body).Decode(&req)
+		count := map[interface{}]string{"latest": fmt.Sprintf(`"0x%x"`, latest.Load()), "pending": `"0x6"`}[req.Params[1]]
+		json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Result: []byte(count)})
+	}))
+	defer ts.Close()
+	// nonce 3 was mined in block 8, reorganised away and cancelled in block 9
+	store := fakeStore{address: {
+		{Hash: "0x1", From: address, To: "0x9", Nonce: "0x3", Value: "0x1", BlockNumber: "0x8"},
+		{Hash: "0x2", From: address, To: address, Nonce: "0x3", Value: "0x0", BlockNumber: "0x9", Timestamp: "0x65920080"},
+		{Hash: "0x3", From: address, To: "0x9", Nonce: "0x2", BlockNumber: "0x7"},
+	}}
+	e := NewEthereumObserver(ts.URL, store)
+	e.Subscribe(address)
+
+	status, err := e.NonceStatus(address)
+	assert.NoError(t, err)
+	assert.Equal(t, []Replacement{{Nonce: 3, Hash: "0x1", ReplacedBy: "0x2", Kind: ReplacementCancel, BlockNumber: 9, Time: time.Unix(0x65920080, 0).UTC()}}, status.Replacements)
+	assert.False(t, status.Stuck, "the pending nonce has only been ahead for one call")
+
+	// the pending nonce stays ahead of the same latest nonce
+	e.stalls.mux.Lock()
+	e.stalls.since[address] = stall{nonce: 4, since: time.Now().Add(-stuckAfter - time.Minute)}
+	e.stalls.mux.Unlock()
+	status, _ = e.NonceStatus(address)
+	assert.True(t, status.Stuck)
+
+	// a transaction is mined
+	latest.Store(5)
+	status, _ = e.NonceStatus(address)
+	assert.False(t, status.Stuck)
+}
+
+func TestTenant_NonceStatus(t *testing.T) {
+	const address = "0x00000000000000000000000000000000000000aa"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Result: []byte(`"0x5"`)})
+	}))
+	defer ts.Close()
+	store := fakeStore{address: {
+		{Hash: "0x1", From: address, Nonce: "0x0", BlockNumber: "0x2"},
+		{Hash: "0x2", From: address, Nonce: "0x2", BlockNumber: "0x4"},
+		{Hash: "0x3", From: address, Nonce: "0x4", BlockNumber: "0x9"},
+	}}
+	e := NewEthereumObserver(ts.URL, store)
+	now := time.Now().UTC()
+	e.Tenant("acme").AddSubscription(Subscription{Address: address, StartBlock: 1, CreatedAt: now.Add(-time.Hour)})
+	e.Tenant("globex").AddSubscription(Subscription{Address: address, StartBlock: 8, CreatedAt: now})
+
+	// a replacement mined before globex subscribed and one seen in the mempool before it
+	e.seePending(Transaction{Hash: "0xa", From: address, To: "0x9", Nonce: "0x3"}, now.Add(-time.Hour))
+	e.minePending(3, []Transaction{{Hash: "0xb", From: address, To: "0x9", Nonce: "0x3", Value: "0x1"}})
+	e.seePending(Transaction{Hash: "0xc", From: address, To: "0x9", Nonce: "0x5"}, now.Add(-time.Minute))
+	e.seePending(Transaction{Hash: "0xd", From: address, To: "0x9", Nonce: "0x5", Value: "0x1"}, now.Add(-time.Minute))
+
+	status, err := e.Tenant("acme").NonceStatus(address)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, *status.LastMinedNonce)
+	assert.Equal(t, []int{1, 3}, status.MissingNonces)
+	assert.Len(t, status.Replacements, 2)
+
+	status, err = e.Tenant("globex").NonceStatus(address)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, *status.LastMinedNonce)
+	assert.Empty(t, status.MissingNonces, "transactions before the start block are not seen")
+	assert.Empty(t, status.Replacements, "replacements before the subscription are not seen")
+	assert.Equal(t, []PendingNonce{{Nonce: 5, Hash: "0xd", FirstSeen: now.Add(-time.Minute)}}, status.Pending)
+
+	_, err = e.Tenant("initech").NonceStatus(address)
+	assert.ErrorIs(t, err, errNotSubscribed)
+}
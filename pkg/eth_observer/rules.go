@@ -0,0 +1,245 @@
+package eth_observer
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/aceagles/etherum_parser/pkg/units"
+)
+
+// Direction of a transaction relative to the subscribed address
+type Direction string
+
+const (
+	// DirectionAny matches transactions sent from or to the address
+	DirectionAny Direction = ""
+	// DirectionIn matches transactions sent to the address
+	DirectionIn Direction = "in"
+	// DirectionOut matches transactions sent from the address
+	DirectionOut Direction = "out"
+)
+
+// TokenEth selects plain ether transfers, transactions without call data, in Rule.Token
+const TokenEth = "eth"
+
+// transferTopic is the first topic of the Transfer(address,address,uint256) event of ERC-20 and ERC-721 tokens
+const transferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+var (
+	ruleAddressPattern  = regexp.MustCompile(`^0x[0-9a-f]{40}$`)
+	ruleSelectorPattern = regexp.MustCompile(`^0x[0-9a-f]{8}$`)
+)
+
+// Rule is a condition over the fields of a transaction matched for a subscription. a rule fires when
+// every condition it sets holds, conditions left empty match any transaction
+type Rule struct {
+	Name      string    `json:"name"`
+	Direction Direction `json:"direction,omitempty"`
+	// MinValue and MaxValue bound the amount transferred, inclusive: the ether value of the transaction in wei, or the
+	// amount of a token transfer in the token's smallest unit. given as decimal or 0x hex
+	MinValue string `json:"minValue,omitempty"`
+	MaxValue string `json:"maxValue,omitempty"`
+	// Token is the contract of a token transferred or called by the transaction, or "eth" for plain ether transfers
+	Token string `json:"token,omitempty"`
+	// Counterparties are the addresses on the other side of the transaction or of a token transfer
+	Counterparties []string `json:"counterparties,omitempty"`
+	// Methods are the 4-byte selectors of the contract methods called, e.g. 0xa9059cbb
+	Methods []string `json:"methods,omitempty"`
+	// Failed selects reverted transactions when true and successful ones when false
+	Failed *bool `json:"failed,omitempty"`
+}
+
+// ValidateRules checks the rules of a subscription and lowercases their addresses and selectors
+func ValidateRules(rules []Rule) error {
+	names := make(map[string]struct{}, len(rules))
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d: name is required", i)
+		}
+		if _, ok := names[rule.Name]; ok {
+			return fmt.Errorf("rule %q: duplicate name", rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+
+		switch rule.Direction {
+		case DirectionAny, DirectionIn, DirectionOut:
+		default:
+			return fmt.Errorf("rule %q: direction must be in or out", rule.Name)
+		}
+		min, err := parseWei(rule.MinValue)
+		if err != nil {
+			return fmt.Errorf("rule %q: minValue: %w", rule.Name, err)
+		}
+		max, err := parseWei(rule.MaxValue)
+		if err != nil {
+			return fmt.Errorf("rule %q: maxValue: %w", rule.Name, err)
+		}
+		if min != nil && max != nil && min.Cmp(max) > 0 {
+			return fmt.Errorf("rule %q: minValue is greater than maxValue", rule.Name)
+		}
+		rule.Token = strings.ToLower(rule.Token)
+		if rule.Token != "" && rule.Token != TokenEth && !ruleAddressPattern.MatchString(rule.Token) {
+			return fmt.Errorf("rule %q: token must be eth or a contract address", rule.Name)
+		}
+		for j, counterparty := range rule.Counterparties {
+			rule.Counterparties[j] = strings.ToLower(counterparty)
+			if !ruleAddressPattern.MatchString(rule.Counterparties[j]) {
+				return fmt.Errorf("rule %q: invalid counterparty %q", rule.Name, counterparty)
+			}
+		}
+		for j, method := range rule.Methods {
+			rule.Methods[j] = strings.ToLower(method)
+			if !ruleSelectorPattern.MatchString(rule.Methods[j]) {
+				return fmt.Errorf("rule %q: invalid method selector %q", rule.Name, method)
+			}
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the rule fires for a transaction matched for the address. the direction, token,
+// counterparty and value conditions hold when they all hold for the transaction itself or for one of the token
+// transfers it logged involving the address, so that a token transfer matched through its Transfer log
+// is judged by its sender, recipient and amount rather than by the token contract called
+func (r Rule) Matches(address string, transaction Transaction) bool {
+	if !slices.ContainsFunc(transfers(address, transaction), func(t transfer) bool { return r.matchesTransfer(address, t) }) {
+		return false
+	}
+	if len(r.Methods) > 0 && !slices.Contains(r.Methods, methodSelector(transaction.Input)) {
+		return false
+	}
+	if r.Failed != nil {
+		// the status is only known once the receipt has been read
+		if transaction.Status == "" || *r.Failed != (transaction.Status == "0x0") {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesTransfer reports whether the direction, token, counterparty and value conditions of the rule hold for a transfer
+func (r Rule) matchesTransfer(address string, t transfer) bool {
+	var counterparties []string
+	switch {
+	case r.Direction == DirectionIn && t.to != address, r.Direction == DirectionOut && t.from != address:
+		return false
+	case r.Direction == DirectionIn:
+		counterparties = []string{t.from}
+	case r.Direction == DirectionOut:
+		counterparties = []string{t.to}
+	default:
+		counterparties = []string{t.from, t.to}
+	}
+	if r.Token != "" && r.Token != t.token {
+		return false
+	}
+	if len(r.Counterparties) > 0 && !slices.ContainsFunc(counterparties, func(counterparty string) bool {
+		return counterparty != address && slices.Contains(r.Counterparties, counterparty)
+	}) {
+		return false
+	}
+	if r.MinValue != "" || r.MaxValue != "" {
+		if t.amount == nil {
+			return false
+		}
+		if min, _ := parseWei(r.MinValue); min != nil && t.amount.Cmp(min) < 0 {
+			return false
+		}
+		if max, _ := parseWei(r.MaxValue); max != nil && t.amount.Cmp(max) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// transfer is a movement of ether or tokens from one address to another
+type transfer struct {
+	from, to string
+	// token is the token contract, or TokenEth for the transaction itself when it carries no call data,
+	// in which case it is the contract called
+	token string
+	// amount is the ether value of the transaction itself or the amount of a token transfer, nil when unknown
+	// such as for the token ID of an ERC-721 transfer
+	amount *big.Int
+}
+
+// transfers returns the transfers of a transaction involving the address: the Transfer events it logged with the
+// address as sender or recipient, and the transaction itself when it is sent from or to the address. a contract call
+// carrying no ether is left out when it logged such transfers, which stand for it, so that a value rule is not met by
+// the zero value of the call to a token. a transaction matched otherwise, such as through a log emitted by the
+// address, is judged by its sender and recipient
+func transfers(address string, transaction Transaction) []transfer {
+	own := transfer{from: strings.ToLower(transaction.From), to: strings.ToLower(transaction.To), token: TokenEth}
+	own.amount, _ = parseWei(transaction.Value)
+	if methodSelector(transaction.Input) != "" {
+		own.token = own.to
+	}
+
+	var found []transfer
+	for _, log := range transaction.Logs {
+		if len(log.Topics) < 3 || !strings.EqualFold(log.Topics[0], transferTopic) {
+			continue
+		}
+		t := transfer{from: topicAddress(log.Topics[1]), to: topicAddress(log.Topics[2]), token: strings.ToLower(log.Address)}
+		if t.from != address && t.to != address {
+			continue
+		}
+		// ERC-20 transfers carry the amount as their data, ERC-721 transfers index the token ID as a fourth topic
+		if data := strings.TrimPrefix(log.Data, "0x"); len(log.Topics) == 3 && len(data) == 64 {
+			t.amount, _ = new(big.Int).SetString(data, 16)
+		}
+		found = append(found, t)
+	}
+	movesEther := own.token == TokenEth || own.amount != nil && own.amount.Sign() > 0
+	if (own.from == address || own.to == address) && (len(found) == 0 || movesEther) {
+		found = append([]transfer{own}, found...)
+	}
+	if len(found) == 0 {
+		return []transfer{own}
+	}
+	return found
+}
+
+// topicAddress returns the lowercase address held by an indexed address topic
+func topicAddress(topic string) string {
+	if len(topic) != 66 {
+		return ""
+	}
+	return "0x" + strings.ToLower(topic[26:])
+}
+
+// firedRules returns the names of the subscription's rules which fire for the transaction
+func firedRules(subscription Subscription, transaction Transaction) []string {
+	var fired []string
+	for _, rule := range subscription.Rules {
+		if rule.Matches(subscription.Address, transaction) {
+			fired = append(fired, rule.Name)
+		}
+	}
+	return fired
+}
+
+// methodSelector returns the lowercase 4-byte selector of the call data or an empty string for a plain transfer
+func methodSelector(input string) string {
+	if len(input) < 10 {
+		return ""
+	}
+	return strings.ToLower(input[:10])
+}
+
+// parseWei parses an amount of wei given as decimal or 0x hex. it returns nil for an empty string
+func parseWei(value string) (*big.Int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	wei, err := units.ParseQuantity(value)
+	if err != nil {
+		return nil, errors.New("must be a non negative integer")
+	}
+	return wei, nil
+}
@@ -0,0 +1,124 @@
+package eth_observer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRule_Matches(t *testing.T) {
+	const (
+		address = "0x00000000000000000000000000000000000000aa"
+		other   = "0x00000000000000000000000000000000000000bb"
+		token   = "0x00000000000000000000000000000000000000cc"
+	)
+	failed, succeeded := true, false
+	incoming := Transaction{From: other, To: address, Value: "0xde0b6b3a7640000", Input: "0x", Status: "0x1"} // 1 ether
+	transfer := Transaction{From: address, To: token, Value: "0x0", Input: "0xa9059cbb0000", Status: "0x0"}
+	// a token transfer from other to the address, matched through its Transfer log. the transaction itself
+	// is sent by a relayer to the token contract
+	const (
+		relayer = "0x00000000000000000000000000000000000000dd"
+		word    = "0x000000000000000000000000"
+	)
+	// 1000 units of the token
+	const amount = "0x00000000000000000000000000000000000000000000000000000000000003e8"
+	logged := Transaction{From: relayer, To: token, Value: "0x0", Input: "0xa9059cbb0000", Status: "0x1", Logs: []Log{{
+		Address: token,
+		Topics:  []string{transferTopic, word + other[2:], word + address[2:]},
+		Data:    amount,
+	}}}
+	// the address sends 1000 units of the token by calling it
+	sent := Transaction{From: address, To: token, Value: "0x0", Input: "0xa9059cbb0000", Status: "0x1", Logs: []Log{{
+		Address: token,
+		Topics:  []string{transferTopic, word + address[2:], word + other[2:]},
+		Data:    amount,
+	}}}
+	tests := []struct {
+		name        string
+		rule        Rule
+		transaction Transaction
+		want        bool
+	}{
+		{name: "Empty rule", transaction: incoming, want: true},
+		{name: "Direction in", rule: Rule{Direction: DirectionIn}, transaction: incoming, want: true},
+		{name: "Direction out", rule: Rule{Direction: DirectionOut}, transaction: incoming, want: false},
+		{name: "Above min value", rule: Rule{MinValue: "1000000000000000000"}, transaction: incoming, want: true},
+		{name: "Below min value", rule: Rule{MinValue: "1000000000000000001"}, transaction: incoming, want: false},
+		{name: "Above max value", rule: Rule{MaxValue: "0xde0b6b3a763ffff"}, transaction: incoming, want: false},
+		{name: "Ether token", rule: Rule{Token: TokenEth}, transaction: incoming, want: true},
+		{name: "Ether token contract call", rule: Rule{Token: TokenEth}, transaction: transfer, want: false},
+		{name: "Contract token", rule: Rule{Token: token}, transaction: transfer, want: true},
+		{name: "Counterparty", rule: Rule{Counterparties: []string{other}}, transaction: incoming, want: true},
+		{name: "Unknown counterparty", rule: Rule{Counterparties: []string{token}}, transaction: incoming, want: false},
+		{name: "Counterparty on the wrong side", rule: Rule{Direction: DirectionOut, Counterparties: []string{other}}, transaction: Transaction{From: address, To: token}, want: false},
+		{name: "Method", rule: Rule{Methods: []string{"0xa9059cbb"}}, transaction: transfer, want: true},
+		{name: "Other method", rule: Rule{Methods: []string{"0x23b872dd"}}, transaction: transfer, want: false},
+		{name: "Failed", rule: Rule{Failed: &failed}, transaction: transfer, want: true},
+		{name: "Succeeded", rule: Rule{Failed: &succeeded}, transaction: transfer, want: false},
+		{name: "Status unknown", rule: Rule{Failed: &succeeded}, transaction: Transaction{From: address}, want: false},
+		{name: "Logged transfer direction in", rule: Rule{Direction: DirectionIn}, transaction: logged, want: true},
+		{name: "Logged transfer direction out", rule: Rule{Direction: DirectionOut}, transaction: logged, want: false},
+		{name: "Logged transfer token", rule: Rule{Direction: DirectionIn, Token: token}, transaction: logged, want: true},
+		{name: "Logged transfer other token", rule: Rule{Token: other}, transaction: logged, want: false},
+		{name: "Logged transfer ether token", rule: Rule{Token: TokenEth}, transaction: logged, want: false},
+		{name: "Logged transfer sender", rule: Rule{Direction: DirectionIn, Counterparties: []string{other}}, transaction: logged, want: true},
+		{name: "Logged transfer relayer", rule: Rule{Counterparties: []string{relayer}}, transaction: logged, want: false},
+		{name: "Logged transfer above min value", rule: Rule{Token: token, MinValue: "1000"}, transaction: logged, want: true},
+		{name: "Logged transfer below min value", rule: Rule{Token: token, MinValue: "1001"}, transaction: logged, want: false},
+		{name: "Logged transfer above max value", rule: Rule{Token: token, MaxValue: "999"}, transaction: logged, want: false},
+		{name: "Logged transfer below max value", rule: Rule{MaxValue: "1000"}, transaction: logged, want: true},
+		{name: "Sent transfer above min value", rule: Rule{Direction: DirectionOut, Token: token, MinValue: "0x3e8"}, transaction: sent, want: true},
+		{name: "Sent transfer dust", rule: Rule{Direction: DirectionOut, MaxValue: "10"}, transaction: sent, want: false},
+		{name: "ERC-721 transfer has no amount", rule: Rule{MinValue: "0"}, transaction: Transaction{From: relayer, To: token, Input: "0x23b872dd0000",
+			Logs: []Log{{Address: token, Topics: []string{transferTopic, word + other[2:], word + address[2:], amount}}},
+		}, want: false},
+		{name: "Logged transfer recipient", rule: Rule{Direction: DirectionOut, Counterparties: []string{other}}, transaction: Transaction{
+			From: address, To: token, Input: "0xa9059cbb0000",
+			Logs: []Log{{Address: token, Topics: []string{transferTopic, word + address[2:], word + other[2:]}}},
+		}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.rule.Matches(address, tt.transaction))
+		})
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	rules := []Rule{{Name: "big", MinValue: "0x10", Counterparties: []string{"0x00000000000000000000000000000000000000BB"}, Methods: []string{"0xA9059CBB"}}}
+	assert.NoError(t, ValidateRules(rules))
+	assert.Equal(t, "0x00000000000000000000000000000000000000bb", rules[0].Counterparties[0])
+	assert.Equal(t, "0xa9059cbb", rules[0].Methods[0])
+
+	for _, invalid := range [][]Rule{
+		{{}},
+		{{Name: "a"}, {Name: "a"}},
+		{{Name: "a", Direction: "sideways"}},
+		{{Name: "a", MinValue: "-1"}},
+		{{Name: "a", MinValue: "10", MaxValue: "9"}},
+		{{Name: "a", Token: "usdc"}},
+		{{Name: "a", Counterparties: []string{"0x1"}}},
+		{{Name: "a", Methods: []string{"transfer"}}},
+	} {
+		assert.Error(t, ValidateRules(invalid), "%+v", invalid)
+	}
+}
+
+func TestTenant_Notification(t *testing.T) {
+	const address = "0x00000000000000000000000000000000000000aa"
+	e := NewEthereumObserver("", fakeStore{})
+	assert.True(t, e.Tenant("acme").AddSubscription(Subscription{Address: address, Rules: []Rule{{Name: "large", MinValue: "100"}}}))
+	assert.False(t, e.Tenant("globex").AddSubscription(Subscription{Address: address, Rules: []Rule{{Name: "large", MinValue: "x"}}}))
+	acme := e.Tenant("acme")
+
+	large, ok := acme.Notification(address, Transaction{To: address, Value: "0x64"})
+	assert.True(t, ok)
+	assert.Equal(t, []string{"large"}, large.Rules)
+
+	_, ok = acme.Notification(address, Transaction{To: address, Value: "0x1"})
+	assert.False(t, ok, "dust is not notified")
+	dust, ok := acme.Visible(address, Transaction{To: address, Value: "0x1"})
+	assert.True(t, ok, "dust is still readable")
+	assert.Empty(t, dust.Rules)
+}
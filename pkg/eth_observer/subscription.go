@@ -0,0 +1,244 @@
+package eth_observer
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrAlreadySubscribed is returned when adding a subscription the tenant already holds
+	ErrAlreadySubscribed = errors.New("already subscribed to address")
+	// ErrSubscriptionQuota is returned when the API key adding a subscription holds as many as it may
+	ErrSubscriptionQuota = errors.New("subscription quota exceeded")
+)
+
+// SubscriptionMetadata describes who a subscription belongs to and what it is
+type SubscriptionMetadata struct {
+	Label string   `json:"label,omitempty"`
+	Owner string   `json:"owner,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+}
+
+// HasTag reports whether the metadata carries the given tag
+func (m SubscriptionMetadata) HasTag(tag string) bool {
+	return slices.Contains(m.Tags, tag)
+}
+
+// Subscription describes an address watched by the observer along with its metadata
+type Subscription struct {
+	Address string `json:"address"`
+	SubscriptionMetadata
+	StartBlock int       `json:"startBlock"`
+	CreatedAt  time.Time `json:"createdAt"`
+	// WebhookURL receives a signed POST for every transaction matched for the subscription
+	WebhookURL    string `json:"webhookUrl,omitempty"`
+	WebhookSecret string `json:"webhookSecret,omitempty"`
+	// Rules select the matched transactions worth a notification. every match is notified if there are none
+	Rules []Rule `json:"rules,omitempty"`
+	// KeyID is the API key which created the subscription, whose quota it counts against
+	KeyID string `json:"keyId,omitempty"`
+	// MatchLogs also matches the transactions which neither come from nor go to the address but logged an event
+	// emitted by it or carrying it as an indexed topic, such as the recipient of an ERC-20 transfer
+	MatchLogs bool `json:"matchLogs,omitempty"`
+}
+
+// Notifies reports whether a transaction annotated for the subscription should be notified
+func (s Subscription) Notifies(transaction Transaction) bool {
+	return len(s.Rules) == 0 || len(transaction.Rules) > 0
+}
+
+// SubscriptionFilter selects subscriptions by address, owner and tag. empty fields match everything
+type SubscriptionFilter struct {
+	Address string
+	Owner   string
+	Tag     string
+}
+
+// Matches reports whether the subscription passes the filter
+func (f SubscriptionFilter) Matches(subscription Subscription) bool {
+	if f.Address != "" && subscription.Address != strings.ToLower(f.Address) {
+		return false
+	}
+	if f.Owner != "" && subscription.Owner != f.Owner {
+		return false
+	}
+	if f.Tag != "" && !subscription.HasTag(f.Tag) {
+		return false
+	}
+	return true
+}
+
+// SubscriptionRegistry persists subscriptions so they survive a restart of the observer
+type SubscriptionRegistry interface {
+	LoadSubscriptions() ([]Subscription, error)
+	SaveSubscription(subscription Subscription) error
+}
+
+// UseRegistry loads the subscriptions held in the registry into the observer
+// and persists every subscription added afterwards to it
+func (e *EthereumObserver) UseRegistry(registry SubscriptionRegistry) error {
+	subscriptions, err := registry.LoadSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	e.subscribeMux.Lock()
+	defer e.subscribeMux.Unlock()
+	for _, subscription := range subscriptions {
+		subscription.Address = strings.ToLower(subscription.Address)
+		e.putSubscription(subscription)
+	}
+	e.registry = registry
+	slog.Info("Loaded subscriptions", "count", len(subscriptions))
+	return nil
+}
+
+// AddSubscription adds a subscription to the observer for the tenant named by its owner.
+// the address is set to lowercase, the start block defaults to the block after the latest parsed block
+// and the creation time to now. it returns false if the tenant is already subscribed to the address
+// or the subscription could not be persisted or has invalid rules
+func (e *EthereumObserver) AddSubscription(subscription Subscription) bool {
+	return e.addSubscription(subscription, nil) == nil
+}
+
+// addSubscription adds a subscription like AddSubscription. allows, when set, is given the number of subscriptions
+// its API key holds on the observer and refuses the subscription with ErrSubscriptionQuota when it returns false.
+// the count and the insert are done under one lock so that concurrent subscriptions cannot exceed a quota
+func (e *EthereumObserver) addSubscription(subscription Subscription, allows func(current int) bool) error {
+	subscription.Address = strings.ToLower(subscription.Address)
+	subscription.Tags = normaliseTags(subscription.Tags)
+	if err := ValidateRules(subscription.Rules); err != nil {
+		slog.Debug("Invalid subscription rules", "address", subscription.Address, "error", err)
+		return err
+	}
+
+	// the registry is written under its own lock rather than e.mux, so that matching and readers of the
+	// observer are not held up by disk I/O
+	e.subscribeMux.Lock()
+	defer e.subscribeMux.Unlock()
+	if _, ok := e.subscriptions.get(subscription.Address, subscription.Owner); ok {
+		slog.Debug("Already subscribed to address", "address", subscription.Address, "owner", subscription.Owner)
+		return ErrAlreadySubscribed
+	}
+	if allows != nil && !allows(e.keySubscriptions[keySubscriptionsKey(subscription)]) {
+		return ErrSubscriptionQuota
+	}
+	if subscription.StartBlock == 0 {
+		e.mux.Lock()
+		subscription.StartBlock = e.latestBlock + 1
+		e.mux.Unlock()
+	}
+	if subscription.CreatedAt.IsZero() {
+		subscription.CreatedAt = time.Now().UTC()
+	}
+	if e.registry != nil {
+		if err := e.registry.SaveSubscription(subscription); err != nil {
+			slog.Error("Failed to persist subscription", "address", subscription.Address, "error", err)
+			return fmt.Errorf("persisting subscription: %w", err)
+		}
+	}
+	e.putSubscription(subscription)
+	slog.Debug("Subscribed to address", "address", subscription.Address, "owner", subscription.Owner)
+	return nil
+}
+
+// putSubscription stores a subscription under its address and owner. the caller must hold e.subscribeMux so that
+// subscriptions are checked and persisted one at a time, while readers only take the lock of the set
+func (e *EthereumObserver) putSubscription(subscription Subscription) {
+	if _, ok := e.subscriptions.get(subscription.Address, subscription.Owner); !ok && subscription.KeyID != "" {
+		if e.keySubscriptions == nil {
+			e.keySubscriptions = make(map[string]int)
+		}
+		e.keySubscriptions[keySubscriptionsKey(subscription)]++
+	}
+	e.subscriptions.put(subscription)
+}
+
+// keySubscriptionsKey returns the key under which the subscriptions of the API key of a subscription are counted
+func keySubscriptionsKey(subscription Subscription) string {
+	return subscription.Owner + "\x00" + subscription.KeyID
+}
+
+// GetSubscription returns the subscription of an owner for an address and whether it exists
+func (e *EthereumObserver) GetSubscription(owner, address string) (Subscription, bool) {
+	return e.subscriptions.get(strings.ToLower(address), owner)
+}
+
+// SubscribeWithMetadata subscribes to an address and records the label, owner and tags given in metadata
+func (e *EthereumObserver) SubscribeWithMetadata(address string, metadata SubscriptionMetadata) bool {
+	return e.AddSubscription(Subscription{Address: address, SubscriptionMetadata: metadata})
+}
+
+// ListSubscriptions returns the subscriptions of every tenant passing the filter ordered by address then owner
+func (e *EthereumObserver) ListSubscriptions(filter SubscriptionFilter) []Subscription {
+	subscriptions := []Subscription{}
+	collect := func(subscription Subscription) {
+		if filter.Matches(subscription) {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	if filter.Address != "" {
+		// the address names a single entry of the set, which spares a scan of every subscription
+		for _, subscription := range e.subscriptions.forAddress(strings.ToLower(filter.Address)) {
+			collect(subscription)
+		}
+	} else {
+		e.subscriptions.each(collect)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		if subscriptions[i].Address != subscriptions[j].Address {
+			return subscriptions[i].Address < subscriptions[j].Address
+		}
+		return subscriptions[i].Owner < subscriptions[j].Owner
+	})
+	return subscriptions
+}
+
+// QueryTransactions returns the transactions of every subscription passing the filter, annotated with
+// the subscription metadata. transactions mined before a subscription's start block are left out
+// so a tenant subscribing late does not see the history gathered for another tenant
+func (e *EthereumObserver) QueryTransactions(filter SubscriptionFilter) []Transaction {
+	transactions := []Transaction{}
+	for _, subscription := range e.ListSubscriptions(filter) {
+		transactions = append(transactions, annotate(e.transactionsStore.GetTransactions(subscription.Address), subscription)...)
+	}
+	return transactions
+}
+
+// annotate returns a copy of the transactions from the subscription's start block onwards with the
+// subscription metadata and the names of the rules which fired attached. transactions matched through their logs
+// are left out unless the subscription matches logs. the stored transactions are left untouched as they are shared
+// between tenants
+func annotate(transactions []Transaction, subscription Subscription) []Transaction {
+	annotated := make([]Transaction, 0, len(transactions))
+	for _, transaction := range transactions {
+		if blockNum, err := parseHexInt(transaction.BlockNumber); err == nil && blockNum < subscription.StartBlock {
+			continue
+		}
+		if transaction.MatchedByLogs && !subscription.MatchLogs {
+			continue
+		}
+		metadata := subscription.SubscriptionMetadata
+		transaction.Subscription = &metadata
+		transaction.Rules = firedRules(subscription, transaction)
+		annotated = append(annotated, transaction)
+	}
+	return annotated
+}
+
+// normaliseTags trims tags and removes empty and duplicate entries
+func normaliseTags(tags []string) []string {
+	var normalised []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !slices.Contains(normalised, tag) {
+			normalised = append(normalised, tag)
+		}
+	}
+	return normalised
+}
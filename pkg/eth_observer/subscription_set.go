@@ -0,0 +1,178 @@
+package eth_observer
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// subscriptionShards is the number of shards of a subscriptionSet
+const subscriptionShards = 64
+
+// bloomScanLimit is the number of subscribed addresses above which mayBeInBloom no longer checks them one by one.
+// with three bits per address and topic, a few thousand addresses match nearly every block bloom of a busy chain,
+// so the scan would cost a pass over every address per block to skip almost no log fetches
+const bloomScanLimit = 2048
+
+// subscriptionSet holds the subscriptions by address and owner. it is split into shards by address, each with its own
+// lock, so that blocks are matched against millions of addresses concurrently with subscriptions being added, without
+// copying the set on every change. its zero value is empty and ready to use
+type subscriptionSet struct {
+	shards [subscriptionShards]subscriptionShard
+	// bloomed is the number of addresses with bloom bits subscribed to with MatchLogs, maintained by put
+	bloomed atomic.Int64
+}
+
+type subscriptionShard struct {
+	mu        sync.RWMutex
+	addresses map[string]subscribedAddress
+}
+
+// subscribedAddress holds the subscriptions of every tenant to an address along with its logs bloom bits
+type subscribedAddress struct {
+	bloom    addressBloom
+	hasBloom bool
+	// matchLogs is set when a subscription to the address matches logs
+	matchLogs     bool
+	subscriptions []Subscription
+}
+
+// bloomed reports whether the logs of blocks are tested against the bloom bits of the address
+func (a subscribedAddress) bloomed() bool {
+	return a.hasBloom && a.matchLogs
+}
+
+// shard returns the shard of an address, chosen by its FNV-1a hash
+func (s *subscriptionSet) shard(address string) *subscriptionShard {
+	hash := uint32(2166136261)
+	for i := 0; i < len(address); i++ {
+		hash ^= uint32(address[i])
+		hash *= 16777619
+	}
+	return &s.shards[hash%subscriptionShards]
+}
+
+// contains reports whether any tenant is subscribed to the address
+func (s *subscriptionSet) contains(address string) bool {
+	shard := s.shard(address)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	_, ok := shard.addresses[address]
+	return ok
+}
+
+// matchesLogs reports whether a tenant is subscribed to the address with MatchLogs
+func (s *subscriptionSet) matchesLogs(address string) bool {
+	shard := s.shard(address)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.addresses[address].matchLogs
+}
+
+// get returns the subscription of an owner to the address and whether it exists
+func (s *subscriptionSet) get(address, owner string) (Subscription, bool) {
+	shard := s.shard(address)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	for _, subscription := range shard.addresses[address].subscriptions {
+		if subscription.Owner == owner {
+			return subscription, true
+		}
+	}
+	return Subscription{}, false
+}
+
+// put stores a subscription under its address and owner, replacing the owner's previous subscription to the address
+func (s *subscriptionSet) put(subscription Subscription) {
+	shard := s.shard(subscription.Address)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if shard.addresses == nil {
+		shard.addresses = make(map[string]subscribedAddress)
+	}
+	entry, ok := shard.addresses[subscription.Address]
+	if !ok {
+		entry.bloom, entry.hasBloom = newAddressBloom(subscription.Address)
+	}
+	wasBloomed := entry.bloomed()
+	replaced := false
+	for i := range entry.subscriptions {
+		if entry.subscriptions[i].Owner == subscription.Owner {
+			// the slice may be read by a caller of forAddress, so it is copied rather than changed in place
+			entry.subscriptions = append([]Subscription(nil), entry.subscriptions...)
+			entry.subscriptions[i] = subscription
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		entry.subscriptions = append(entry.subscriptions[:len(entry.subscriptions):len(entry.subscriptions)], subscription)
+	}
+	entry.matchLogs = false
+	for _, subscription := range entry.subscriptions {
+		entry.matchLogs = entry.matchLogs || subscription.MatchLogs
+	}
+	switch isBloomed := entry.bloomed(); {
+	case isBloomed && !wasBloomed:
+		s.bloomed.Add(1)
+	case !isBloomed && wasBloomed:
+		s.bloomed.Add(-1)
+	}
+	shard.addresses[subscription.Address] = entry
+}
+
+// forAddress returns the subscriptions of every tenant to the address. the slice must not be modified
+func (s *subscriptionSet) forAddress(address string) []Subscription {
+	shard := s.shard(address)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.addresses[address].subscriptions
+}
+
+// each calls fn with every subscription. fn must not change the set
+func (s *subscriptionSet) each(fn func(Subscription)) {
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.RLock()
+		for _, entry := range shard.addresses {
+			for _, subscription := range entry.subscriptions {
+				fn(subscription)
+			}
+		}
+		shard.mu.RUnlock()
+	}
+}
+
+// len returns the number of subscribed addresses
+func (s *subscriptionSet) len() int {
+	count := 0
+	for i := range s.shards {
+		s.shards[i].mu.RLock()
+		count += len(s.shards[i].addresses)
+		s.shards[i].mu.RUnlock()
+	}
+	return count
+}
+
+// mayBeInBloom reports whether the logs bloom of a block may hold any address subscribed to with MatchLogs. a nil
+// bloom may hold every address which can appear in a log, and past bloomScanLimit addresses every bloom is assumed to
+// hold one
+func (s *subscriptionSet) mayBeInBloom(bloom []byte) bool {
+	switch bloomed := s.bloomed.Load(); {
+	case bloomed == 0:
+		return false
+	case bloomed > bloomScanLimit:
+		return true
+	}
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.RLock()
+		for _, entry := range shard.addresses {
+			if entry.bloomed() && (bloom == nil || entry.bloom.mayContain(bloom)) {
+				shard.mu.RUnlock()
+				return true
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package eth_observer
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptionSet(t *testing.T) {
+	var set subscriptionSet
+	assert.False(t, set.contains("0x1"))
+
+	set.put(Subscription{Address: "0x1", SubscriptionMetadata: SubscriptionMetadata{Owner: "acme", Label: "hot"}})
+	set.put(Subscription{Address: "0x1", SubscriptionMetadata: SubscriptionMetadata{Owner: "globex"}})
+	set.put(Subscription{Address: "0x2", SubscriptionMetadata: SubscriptionMetadata{Owner: "acme"}})
+	assert.True(t, set.contains("0x1"))
+	assert.Equal(t, 2, set.len())
+
+	before := set.forAddress("0x1")
+	set.put(Subscription{Address: "0x1", SubscriptionMetadata: SubscriptionMetadata{Owner: "acme", Label: "cold"}})
+	assert.Equal(t, "hot", before[0].Label, "slices returned earlier are not changed")
+	subscription, ok := set.get("0x1", "acme")
+	assert.True(t, ok)
+	assert.Equal(t, "cold", subscription.Label)
+	assert.Len(t, set.forAddress("0x1"), 2)
+	_, ok = set.get("0x2", "globex")
+	assert.False(t, ok)
+
+	count := 0
+	set.each(func(Subscription) { count++ })
+	assert.Equal(t, 3, count)
+}
+
+func TestSubscriptionSet_mayBeInBloom(t *testing.T) {
+	var set subscriptionSet
+	empty := make([]byte, bloomSize)
+	assert.False(t, set.mayBeInBloom(nil))
+
+	// addresses which are not 20 bytes of hex have no bloom bits
+	set.put(Subscription{Address: "0x1", SubscriptionMetadata: SubscriptionMetadata{Owner: "acme"}})
+	assert.False(t, set.mayBeInBloom(nil))
+
+	// only addresses subscribed to with MatchLogs are looked up in blooms
+	address := "0x00000000000000000000000000000000000000aa"
+	set.put(Subscription{Address: address, SubscriptionMetadata: SubscriptionMetadata{Owner: "acme"}})
+	assert.False(t, set.mayBeInBloom(nil))
+	assert.False(t, set.matchesLogs(address))
+	set.put(Subscription{Address: address, SubscriptionMetadata: SubscriptionMetadata{Owner: "globex"}, MatchLogs: true})
+	set.put(Subscription{Address: address, SubscriptionMetadata: SubscriptionMetadata{Owner: "initech"}, MatchLogs: true})
+	assert.Equal(t, int64(1), set.bloomed.Load())
+	assert.True(t, set.matchesLogs(address))
+	assert.True(t, set.mayBeInBloom(nil))
+	assert.False(t, set.mayBeInBloom(empty))
+
+	// the address is no longer looked up once no subscription to it matches logs
+	set.put(Subscription{Address: address, SubscriptionMetadata: SubscriptionMetadata{Owner: "globex"}})
+	assert.Equal(t, int64(1), set.bloomed.Load())
+	set.put(Subscription{Address: address, SubscriptionMetadata: SubscriptionMetadata{Owner: "initech"}})
+	assert.Zero(t, set.bloomed.Load())
+	assert.False(t, set.mayBeInBloom(nil))
+	set.put(Subscription{Address: address, SubscriptionMetadata: SubscriptionMetadata{Owner: "acme"}, MatchLogs: true})
+
+	// past the scan limit every bloom may hold a subscribed address
+	for i := 0; i < bloomScanLimit; i++ {
+		set.put(Subscription{Address: fmt.Sprintf("0x%040x", i+0x100), SubscriptionMetadata: SubscriptionMetadata{Owner: "acme"}, MatchLogs: true})
+	}
+	assert.True(t, set.mayBeInBloom(empty))
+}
+
+func TestEthereumObserver_matchWhileSubscribing(t *testing.T) {
+	e := NewEthereumObserver("", fakeStore{})
+	transactions := []Transaction{{Hash: "0xa", From: "0x0", To: "0x1"}}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			e.Subscribe(fmt.Sprintf("0x%x", i))
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		e.collectSubscribedAddresses(transactions)
+	}
+	wg.Wait()
+	assert.Len(t, e.collectSubscribedAddresses(transactions), 2)
+}
+
+var (
+	benchmarkSet     *EthereumObserver
+	benchmarkSetOnce sync.Once
+)
+
+// BenchmarkEthereumObserver_collectSubscribedAddresses matches a block of 1,000 transactions against 10M subscribed
+// addresses, one transaction in ten involving a subscribed address. building the set takes a while and a few GB
+func BenchmarkEthereumObserver_collectSubscribedAddresses(b *testing.B) {
+	const addresses = 10_000_000
+	benchmarkSetOnce.Do(func() {
+		benchmarkSet = &EthereumObserver{}
+		for i := 0; i < addresses; i++ {
+			benchmarkSet.subscriptions.put(Subscription{Address: fmt.Sprintf("0x%040x", i)})
+		}
+	})
+	transactions := make([]Transaction, 1000)
+	for i := range transactions {
+		from := fmt.Sprintf("0x%040x", addresses+2*i)
+		to := fmt.Sprintf("0x%040x", addresses+2*i+1)
+		if i%10 == 0 {
+			to = fmt.Sprintf("0x%040x", i*addresses/len(transactions))
+		}
+		transactions[i] = Transaction{Hash: fmt.Sprintf("0x%x", i), From: from, To: to}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if matched := benchmarkSet.collectSubscribedAddresses(transactions); len(matched) != len(transactions)/10 {
+			b.Fatalf("matched %d addresses, want %d", len(matched), len(transactions)/10)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package eth_observer
+
+// Tenant is a view of the observer scoped to the subscriptions of a single tenant.
+// every tenant has its own subscription set over the same address space while the observer
+// ingests each address once, however many tenants watch it. it implements the Parser interface
+type Tenant struct {
+	id       string
+	observer *EthereumObserver
+}
+
+// Tenant returns the view of the observer for the tenant with the given id
+func (e *EthereumObserver) Tenant(id string) *Tenant {
+	return &Tenant{id: id, observer: e}
+}
+
+// ID returns the tenant id, which is recorded as the owner of its subscriptions
+func (t *Tenant) ID() string {
+	return t.id
+}
+
+// GetCurrentBlock returns the current block number in the observer
+func (t *Tenant) GetCurrentBlock() int {
+	return t.observer.GetCurrentBlock()
+}
+
+// Subscribe adds an address to the tenant's subscriptions
+func (t *Tenant) Subscribe(address string) bool {
+	return t.SubscribeWithMetadata(address, SubscriptionMetadata{})
+}
+
+// SubscribeWithMetadata adds an address to the tenant's subscriptions. the owner is always the tenant
+func (t *Tenant) SubscribeWithMetadata(address string, metadata SubscriptionMetadata) bool {
+	metadata.Owner = t.id
+	return t.observer.SubscribeWithMetadata(address, metadata)
+}
+
+// AddSubscription adds a subscription for the tenant. the owner is always the tenant
+func (t *Tenant) AddSubscription(subscription Subscription) bool {
+	subscription.Owner = t.id
+	return t.observer.AddSubscription(subscription)
+}
+
+// AddKeySubscription adds a subscription for the tenant created with the API key given. allows is given the number of
+// subscriptions the key holds on the observer's chain and refuses the subscription with ErrSubscriptionQuota when it
+// returns false. it returns ErrAlreadySubscribed if the tenant is already subscribed to the address
+func (t *Tenant) AddKeySubscription(subscription Subscription, keyID string, allows func(current int) bool) error {
+	subscription.Owner = t.id
+	subscription.KeyID = keyID
+	return t.observer.addSubscription(subscription, allows)
+}
+
+// GetSubscription returns the tenant's subscription for an address and whether it exists
+func (t *Tenant) GetSubscription(address string) (Subscription, bool) {
+	return t.observer.GetSubscription(t.id, address)
+}
+
+// GetTransactions returns transactions for an address the tenant is subscribed to
+// addresses the tenant is not subscribed to return no transactions
+func (t *Tenant) GetTransactions(address string) []Transaction {
+	if address == "" {
+		return []Transaction{}
+	}
+	return t.QueryTransactions(SubscriptionFilter{Address: address})
+}
+
+// ListSubscriptions returns the tenant's subscriptions passing the filter
+func (t *Tenant) ListSubscriptions(filter SubscriptionFilter) []Subscription {
+	filter.Owner = t.id
+	return t.observer.ListSubscriptions(filter)
+}
+
+// QueryTransactions returns the transactions of the tenant's subscriptions passing the filter
+func (t *Tenant) QueryTransactions(filter SubscriptionFilter) []Transaction {
+	filter.Owner = t.id
+	return t.observer.QueryTransactions(filter)
+}
+
+// Visible returns the transaction annotated with the tenant's subscription metadata
+// and false if the tenant is not subscribed to the address or subscribed after the transaction was mined
+func (t *Tenant) Visible(address string, transaction Transaction) (Transaction, bool) {
+	_, annotated, ok := t.visible(address, transaction)
+	return annotated, ok
+}
+
+// Notification returns the transaction annotated like Visible and false unless the
+// tenant can see it and the rules of its subscription select it for a notification
+func (t *Tenant) Notification(address string, transaction Transaction) (Transaction, bool) {
+	subscription, annotated, ok := t.visible(address, transaction)
+	if !ok || !subscription.Notifies(annotated) {
+		return Transaction{}, false
+	}
+	return annotated, true
+}
+
+// visible returns the tenant's subscription to the address and the transaction annotated with it,
+// and false if the tenant is not subscribed to the address or subscribed after the transaction was mined
+func (t *Tenant) visible(address string, transaction Transaction) (Subscription, Transaction, bool) {
+	subscription, ok := t.GetSubscription(address)
+	if !ok {
+		return Subscription{}, Transaction{}, false
+	}
+	annotated := annotate([]Transaction{transaction}, subscription)
+	if len(annotated) == 0 {
+		return Subscription{}, Transaction{}, false
+	}
+	return subscription, annotated[0], true
+}
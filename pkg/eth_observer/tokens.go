@@ -0,0 +1,190 @@
+package eth_observer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"github.com/aceagles/etherum_parser/pkg/abi"
+	"github.com/aceagles/etherum_parser/pkg/tokens"
+)
+
+// selectors of the ERC-20 methods read with eth_call
+const (
+	selectorDecimals  = "0x313ce567"
+	selectorSymbol    = "0x95d89b41"
+	selectorBalanceOf = "0x70a08231"
+)
+
+// holderPattern matches a lowercase hex encoded 20 byte address
+var holderPattern = regexp.MustCompile(`^0x[0-9a-f]{40}$`)
+
+// ErrInvalidAddress is returned by TokenBalances for an address which is not a 0x prefixed 20 byte hex address
+var ErrInvalidAddress = errors.New("invalid address")
+
+// TokenBalance is the balance of an address in an ERC-20 token
+type TokenBalance struct {
+	tokens.Token
+	// Balance is the raw amount in the token's smallest unit as a decimal string, Formatted the amount with the token's decimals
+	Balance   string `json:"balance,omitempty"`
+	Formatted string `json:"formatted,omitempty"`
+	// Error is set instead of the balance when the token could not be read
+	Error string `json:"error,omitempty"`
+}
+
+// maxLookedUpTokens is the number of tokens read with eth_call whose metadata is remembered. tokens read once it is
+// reached are read again on every lookup
+const maxLookedUpTokens = 10000
+
+// UseTokens replaces the registry of known tokens. tokens looked up with eth_call are not added to it
+func (e *EthereumObserver) UseTokens(registry *tokens.Registry) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.tokens = registry
+}
+
+// tokenRegistry returns the registry of known tokens
+func (e *EthereumObserver) tokenRegistry() *tokens.Registry {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.tokens == nil {
+		e.tokens = tokens.NewRegistry()
+	}
+	return e.tokens
+}
+
+// lookedUpTokenCache returns the cache of the tokens read with eth_call
+func (e *EthereumObserver) lookedUpTokenCache() *tokens.Registry {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.lookedUpTokens == nil {
+		e.lookedUpTokens = tokens.NewRegistry()
+	}
+	return e.lookedUpTokens
+}
+
+// Token returns the token at a contract address. tokens missing from the registry are read with eth_call on
+// decimals() and symbol() and cached apart from it, so that the tokens asked for by one caller are never listed
+// as known tokens to the others
+func (e *EthereumObserver) Token(address string) (tokens.Token, error) {
+	if token, ok := e.tokenRegistry().Get(address); ok {
+		return token, nil
+	}
+	cache := e.lookedUpTokenCache()
+	if token, ok := cache.Get(address); ok {
+		return token, nil
+	}
+
+	// the metadata of a token never changes, so it is read at the latest block where the contract surely exists
+	token := tokens.Token{Address: strings.ToLower(address)}
+	values, err := e.callToken(token.Address, selectorDecimals, "latest", "uint8")
+	if err != nil {
+		return tokens.Token{}, fmt.Errorf("reading decimals of %s: %w", address, err)
+	}
+	decimals, _ := new(big.Int).SetString(values[0].(string), 10)
+	if !decimals.IsInt64() || decimals.Int64() > 255 {
+		return tokens.Token{}, fmt.Errorf("reading decimals of %s: %s does not fit a uint8", address, decimals)
+	}
+	token.Decimals = int(decimals.Int64())
+	if token.Symbol, err = e.tokenSymbol(token.Address, "latest"); err != nil {
+		return tokens.Token{}, fmt.Errorf("reading symbol of %s: %w", address, err)
+	}
+	if cache.Len() < maxLookedUpTokens {
+		if err := cache.Add(token); err != nil {
+			return tokens.Token{}, err
+		}
+	}
+	return token, nil
+}
+
+// TokenBalances returns the balances of an address at the end of a block in the tokens at the contract addresses,
+// or in every token of the registry without any. tokens which cannot be read carry an error instead of a balance.
+// the address is lowercased and validated before it is encoded in the balanceOf call data
+func (e *EthereumObserver) TokenBalances(address string, blockNum int, tokenAddresses ...string) ([]TokenBalance, error) {
+	address = strings.ToLower(address)
+	if !holderPattern.MatchString(address) {
+		return nil, fmt.Errorf("%w: %q is not a 0x prefixed 20 byte hex address", ErrInvalidAddress, address)
+	}
+	var known []tokens.Token
+	if len(tokenAddresses) == 0 {
+		known = e.tokenRegistry().List()
+	}
+	balances := make([]TokenBalance, 0, len(known)+len(tokenAddresses))
+	for _, tokenAddress := range tokenAddresses {
+		token, err := e.Token(tokenAddress)
+		if err != nil {
+			balances = append(balances, TokenBalance{Token: tokens.Token{Address: strings.ToLower(tokenAddress)}, Error: err.Error()})
+			continue
+		}
+		known = append(known, token)
+	}
+
+	for _, token := range known {
+		values, err := e.callToken(token.Address, selectorBalanceOf+strings.Repeat("0", 24)+strings.TrimPrefix(address, "0x"), fmt.Sprintf("0x%x", blockNum), "uint256")
+		if err != nil {
+			balances = append(balances, TokenBalance{Token: token, Error: fmt.Sprintf("reading balance: %v", err)})
+			continue
+		}
+		balance, _ := new(big.Int).SetString(values[0].(string), 10)
+		balances = append(balances, TokenBalance{Token: token, Balance: balance.String(), Formatted: token.Format(balance)})
+	}
+	return balances, nil
+}
+
+// tokenSymbol reads the symbol of a token. a few early tokens return it as bytes32 rather than a string
+func (e *EthereumObserver) tokenSymbol(address string, blockTag string) (string, error) {
+	data, err := e.call(address, selectorSymbol, blockTag)
+	if err != nil {
+		return "", err
+	}
+	if len(data) == 32 {
+		return strings.TrimRight(string(data), "\x00"), nil
+	}
+	values, err := abi.Decode([]abi.Type{{Kind: abi.KindString}}, data)
+	if err != nil {
+		return "", err
+	}
+	return values[0].(string), nil
+}
+
+// callToken calls a method of a token returning a single value of the type
+func (e *EthereumObserver) callToken(address string, input string, blockTag string, returnType string) ([]any, error) {
+	t, err := abi.ParseType(returnType, nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := e.call(address, input, blockTag)
+	if err != nil {
+		return nil, err
+	}
+	return abi.Decode([]abi.Type{t}, data)
+}
+
+// call executes a read only call of a contract at the block tag and returns its return data
+func (e *EthereumObserver) call(to string, input string, blockTag string) ([]byte, error) {
+	callReq := EthRequestStruct{
+		Jsonrpc: "2.0",
+		Method:  "eth_call",
+		Params:  []interface{}{map[string]string{"to": to, "data": input}, blockTag},
+		Id:      0,
+	}
+
+	response, err := e.QueryEthClient(callReq)
+	if err != nil {
+		return nil, err
+	}
+
+	var result string
+	err = json.Unmarshal(response.Result, &result)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(result, "0x") {
+		return nil, fmt.Errorf("invalid call result %q", result)
+	}
+	return hex.DecodeString(result[2:])
+}
@@ -0,0 +1,122 @@
+package eth_observer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aceagles/etherum_parser/pkg/keccak"
+	"github.com/aceagles/etherum_parser/pkg/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_tokenSelectors(t *testing.T) {
+	for signature, selector := range map[string]string{
+		"decimals()":         selectorDecimals,
+		"symbol()":           selectorSymbol,
+		"balanceOf(address)": selectorBalanceOf,
+	} {
+		hash := keccak.Sum256([]byte(signature))
+		assert.Equal(t, "0x"+hex.EncodeToString(hash[:4]), selector, signature)
+	}
+}
+
+func TestEthereumObserver_TokenBalances(t *testing.T) {
+	const (
+		holder = "0x00000000000000000000000000000000000000aa"
+		usdc   = "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"
+		mkr    = "0x9f8f72aa9304c8b593d555f12ef6589cc3a579a2"
+		broken = "0x00000000000000000000000000000000000000ee"
+	)
+	balanceOf := selectorBalanceOf + wordHex("aa")[2:]
+	results := map[string]string{
+		usdc + " " + balanceOf + " 0x5":          wordHex("16e360"),
+		mkr + " " + selectorDecimals + " latest": wordHex("12"),
+		// MKR returns its symbol as bytes32
+		mkr + " " + selectorSymbol + " latest": "0x" + hex.EncodeToString([]byte("MKR")) + strings.Repeat("0", 58),
+		mkr + " " + balanceOf + " 0x5":         wordHex("de0b6b3a7640000"),
+		// no contract at the address
+		broken + " " + selectorDecimals + " latest": "0x",
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string        `json:"method"`
+			Params []interface{} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		call := req.Params[0].(map[string]interface{})
+		result, ok := results[call["to"].(string)+" "+call["data"].(string)+" "+req.Params[1].(string)]
+		if req.Method != "eth_call" || !ok {
+			json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Error: &EthErrorStruct{Code: 3, Message: "execution reverted"}})
+			return
+		}
+		json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Result: []byte(`"` + result + `"`)})
+	}))
+	defer ts.Close()
+
+	registry := tokens.NewRegistry()
+	require.NoError(t, registry.Add(tokens.Token{Address: usdc, Symbol: "USDC", Decimals: 6}))
+	e := NewEthereumObserver(ts.URL, fakeStore{})
+	e.UseTokens(registry)
+
+	balances, err := e.TokenBalances(holder, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []TokenBalance{
+		{Token: tokens.Token{Address: usdc, Symbol: "USDC", Decimals: 6}, Balance: "1500000", Formatted: "1.5"},
+	}, balances)
+
+	// tokens missing from the registry are read with eth_call and remembered
+	balances, err = e.TokenBalances(strings.ToUpper(holder[:2])+holder[2:], 5, mkr, broken)
+	require.NoError(t, err)
+	require.Len(t, balances, 2)
+	assert.Equal(t, tokens.Token{Address: broken}, balances[0].Token)
+	assert.Contains(t, balances[0].Error, "reading decimals")
+	assert.Equal(t, TokenBalance{Token: tokens.Token{Address: mkr, Symbol: "MKR", Decimals: 18}, Balance: "1000000000000000000", Formatted: "1"}, balances[1])
+	token, ok := e.lookedUpTokenCache().Get(mkr)
+	assert.True(t, ok)
+	assert.Equal(t, "MKR", token.Symbol)
+	_, ok = registry.Get(mkr)
+	assert.False(t, ok, "tokens looked up are not listed as known tokens")
+
+	// a balance at a block the client cannot serve, in the known tokens only
+	balances, err = e.TokenBalances(holder, 4)
+	require.NoError(t, err)
+	require.Len(t, balances, 1)
+	assert.Contains(t, balances[0].Error, "execution reverted")
+
+	// a malformed holder would corrupt the call data
+	for _, address := range []string{"0x1234", holder + "00", "0x" + strings.Repeat("g", 40), holder[2:]} {
+		_, err = e.TokenBalances(address, 5)
+		assert.ErrorIs(t, err, ErrInvalidAddress, address)
+	}
+}
+
+func TestEthereumObserver_Token_stringSymbol(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Params []interface{} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		result := wordHex("6")
+		if req.Params[0].(map[string]interface{})["data"] == selectorSymbol {
+			// the ABI encoding of the string "USDC"
+			result = wordHex("20") + wordHex("4")[2:] + hex.EncodeToString([]byte("USDC")) + strings.Repeat("0", 56)
+		}
+		json.NewEncoder(w).Encode(EthResponseStruct{Jsonrpc: "2.0", Result: []byte(`"` + result + `"`)})
+	}))
+	defer ts.Close()
+
+	e := NewEthereumObserver(ts.URL, fakeStore{})
+	token, err := e.Token("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48")
+	require.NoError(t, err)
+	assert.Equal(t, tokens.Token{Address: "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", Symbol: "USDC", Decimals: 6}, token)
+}
+
+// wordHex left pads hex digits to a 0x prefixed 32 byte word
+func wordHex(digits string) string {
+	return "0x" + strings.Repeat("0", 64-len(digits)) + digits
+}
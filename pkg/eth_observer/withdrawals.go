@@ -0,0 +1,86 @@
+package eth_observer
+
+import (
+	"strings"
+)
+
+// Withdrawal is a beacon chain withdrawal crediting ETH to an address, which happens without a transaction
+type Withdrawal struct {
+	Index          string `json:"index"`
+	ValidatorIndex string `json:"validatorIndex"`
+	Address        string `json:"address"`
+	// Amount is in gwei, not wei like the values of transactions
+	Amount      string `json:"amount"`
+	BlockNumber string `json:"blockNumber"`
+	BlockHash   string `json:"blockHash"`
+
+	// Subscription holds the metadata of the subscription the withdrawal was matched for
+	Subscription *SubscriptionMetadata `json:"subscription,omitempty"`
+}
+
+// WithdrawalsStore stores the withdrawals matched for subscribed addresses
+type WithdrawalsStore interface {
+	GetWithdrawals(address string) []Withdrawal
+	AddWithdrawals(address string, withdrawals []Withdrawal)
+}
+
+// UseWithdrawalsStore stores the withdrawals to subscribed addresses found from now on in the store.
+// withdrawals are not collected without one
+func (e *EthereumObserver) UseWithdrawalsStore(store WithdrawalsStore) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.withdrawalsStore = store
+}
+
+// GetWithdrawals returns every stored withdrawal for an address regardless of tenant
+// use Tenant to read the withdrawals scoped to a tenant's subscriptions
+func (e *EthereumObserver) GetWithdrawals(address string) []Withdrawal {
+	e.mux.Lock()
+	store := e.withdrawalsStore
+	e.mux.Unlock()
+	if store == nil {
+		return []Withdrawal{}
+	}
+	return store.GetWithdrawals(strings.ToLower(address))
+}
+
+// storeWithdrawals stores the withdrawals of a block made to subscribed addresses
+func (e *EthereumObserver) storeWithdrawals(blk block) {
+	withdrawalsByAddress := make(map[string][]Withdrawal)
+	e.mux.Lock()
+	store := e.withdrawalsStore
+	for _, withdrawal := range blk.Withdrawals {
+		withdrawal.Address = strings.ToLower(withdrawal.Address)
+		if e.subscriptions.contains(withdrawal.Address) {
+			withdrawal.BlockNumber, withdrawal.BlockHash = blk.Number, blk.Hash
+			withdrawalsByAddress[withdrawal.Address] = append(withdrawalsByAddress[withdrawal.Address], withdrawal)
+		}
+	}
+	e.mux.Unlock()
+	if store == nil {
+		return
+	}
+
+	for address, withdrawals := range withdrawalsByAddress {
+		store.AddWithdrawals(address, withdrawals)
+	}
+}
+
+// GetWithdrawals returns the withdrawals to an address the tenant is subscribed to which were made from the start
+// block of its subscription, annotated with the subscription metadata
+func (t *Tenant) GetWithdrawals(address string) []Withdrawal {
+	subscription, ok := t.GetSubscription(address)
+	if !ok {
+		return []Withdrawal{}
+	}
+	withdrawals := []Withdrawal{}
+	for _, withdrawal := range t.observer.GetWithdrawals(address) {
+		if blockNum, err := parseHexInt(withdrawal.BlockNumber); err == nil && blockNum < subscription.StartBlock {
+			continue
+		}
+		metadata := subscription.SubscriptionMetadata
+		withdrawal.Subscription = &metadata
+		withdrawals = append(withdrawals, withdrawal)
+	}
+	return withdrawals
+}
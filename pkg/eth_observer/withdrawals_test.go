@@ -0,0 +1,52 @@
+package eth_observer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeWithdrawalsStore map[string][]Withdrawal
+
+func (f fakeWithdrawalsStore) GetWithdrawals(address string) []Withdrawal {
+	return f[address]
+}
+
+func (f fakeWithdrawalsStore) AddWithdrawals(address string, withdrawals []Withdrawal) {
+	f[address] = append(f[address], withdrawals...)
+}
+
+func TestEthereumObserver_withdrawals(t *testing.T) {
+	ts := newBlockServer(t, map[string]string{
+		"0x1": `{"number":"0x1","hash":"0xb1","transactions":[],"withdrawals":[
+			{"index":"0x10","validatorIndex":"0x20","address":"0x00000000000000000000000000000000000000AA","amount":"0x3b9aca00"},
+			{"index":"0x11","validatorIndex":"0x21","address":"0x00000000000000000000000000000000000000bb","amount":"0x1"}
+		]}`,
+		"0x2": `{"number":"0x2","hash":"0xb2","transactions":[],"withdrawals":[
+			{"index":"0x12","validatorIndex":"0x20","address":"0x00000000000000000000000000000000000000aa","amount":"0x2"}
+		]}`,
+	})
+	const address = "0x00000000000000000000000000000000000000aa"
+	transactions := fakeStore{}
+	e := NewEthereumObserver(ts.URL, transactions)
+	e.Tenant("acme").Subscribe(address)
+	e.UpdateTransactions(1)
+	assert.Empty(t, e.GetWithdrawals(address), "withdrawals are not collected without a store")
+
+	e.UseWithdrawalsStore(fakeWithdrawalsStore{})
+	e.UpdateTransactions(1)
+	e.Tenant("globex").AddSubscription(Subscription{Address: address, StartBlock: 2, SubscriptionMetadata: SubscriptionMetadata{Label: "staking"}})
+	e.UpdateTransactions(2)
+
+	withdrawals := e.GetWithdrawals(address)
+	require.Len(t, withdrawals, 2)
+	assert.Equal(t, Withdrawal{Index: "0x10", ValidatorIndex: "0x20", Address: address, Amount: "0x3b9aca00", BlockNumber: "0x1", BlockHash: "0xb1"}, withdrawals[0])
+	assert.Empty(t, transactions, "withdrawals are not stored as transactions")
+
+	globex := e.Tenant("globex").GetWithdrawals(address)
+	require.Len(t, globex, 1, "withdrawals before the start block are hidden")
+	assert.Equal(t, "0x12", globex[0].Index)
+	assert.Equal(t, "staking", globex[0].Subscription.Label)
+	assert.Empty(t, e.Tenant("initech").GetWithdrawals(address))
+}
@@ -0,0 +1,141 @@
+package fileregistry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+
+	atomicfile "github.com/aceagles/etherum_parser/pkg/atomic_file"
+	"github.com/aceagles/etherum_parser/pkg/eth_observer"
+)
+
+// fileRegistry is a subscription registry backed by a journal file holding one JSON subscription per line.
+// saves append a line so that their cost does not grow with the registry, and the journal is compacted to
+// one line per subscription when it is opened. it implements the SubscriptionRegistry interface
+type fileRegistry struct {
+	path          string
+	mux           sync.Mutex
+	subscriptions map[string]eth_observer.Subscription
+	journal       *atomicfile.Journal
+}
+
+// NewFileRegistry creates a new fileRegistry persisting to the file at path
+// the file is read and compacted if it exists, otherwise it is created on the first save.
+// a file holding a JSON array of subscriptions, as written by earlier versions, is read as well
+func NewFileRegistry(path string) (*fileRegistry, error) {
+	f := &fileRegistry{path: path, subscriptions: make(map[string]eth_observer.Subscription), journal: atomicfile.NewJournal(path)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines, err := f.read(data)
+	if err != nil {
+		return nil, err
+	}
+	if lines != len(f.subscriptions) {
+		if err := f.compact(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// read loads the subscriptions of the journal, later lines replacing earlier ones, and returns the number of lines
+// read. a last line cut short by a crash while it was appended is dropped
+func (f *fileRegistry) read(data []byte) (int, error) {
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("[")) {
+		var subscriptions []eth_observer.Subscription
+		if err := json.Unmarshal(trimmed, &subscriptions); err != nil {
+			return 0, err
+		}
+		for _, subscription := range subscriptions {
+			f.subscriptions[key(subscription)] = subscription
+		}
+		// always rewritten as a journal
+		return -1, nil
+	}
+
+	lines, torn := atomicfile.SplitLines(data)
+	for i, line := range lines {
+		var subscription eth_observer.Subscription
+		if err := json.Unmarshal(line, &subscription); err != nil {
+			return 0, fmt.Errorf("%s: line %d: %w", f.path, i+1, err)
+		}
+		f.subscriptions[key(subscription)] = subscription
+	}
+	if torn {
+		slog.Warn("Dropping the incomplete last line of the subscription registry", "path", f.path)
+		// rewritten without it
+		return -1, nil
+	}
+	return len(lines), nil
+}
+
+// key identifies a subscription by its owner and address as each tenant has its own subscription set
+func key(subscription eth_observer.Subscription) string {
+	return subscription.Owner + "/" + subscription.Address
+}
+
+// LoadSubscriptions returns every subscription held in the registry ordered by creation time
+func (f *fileRegistry) LoadSubscriptions() ([]eth_observer.Subscription, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.sorted(), nil
+}
+
+// SaveSubscription adds or replaces a subscription and appends it to the journal
+func (f *fileRegistry) SaveSubscription(subscription eth_observer.Subscription) error {
+	line, err := json.Marshal(subscription)
+	if err != nil {
+		return err
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if err := f.journal.Append(line); err != nil {
+		return err
+	}
+	f.subscriptions[key(subscription)] = subscription
+	return nil
+}
+
+// sorted returns the subscriptions ordered by creation time then address and owner
+func (f *fileRegistry) sorted() []eth_observer.Subscription {
+	subscriptions := make([]eth_observer.Subscription, 0, len(f.subscriptions))
+	for _, subscription := range f.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		if !subscriptions[i].CreatedAt.Equal(subscriptions[j].CreatedAt) {
+			return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+		}
+		if subscriptions[i].Address != subscriptions[j].Address {
+			return subscriptions[i].Address < subscriptions[j].Address
+		}
+		return subscriptions[i].Owner < subscriptions[j].Owner
+	})
+	return subscriptions
+}
+
+// compact rewrites the journal atomically with one line per subscription, so a crash mid write never leaves a
+// truncated registry behind
+func (f *fileRegistry) compact() error {
+	records := make([][]byte, 0, len(f.subscriptions))
+	for _, subscription := range f.sorted() {
+		record, err := json.Marshal(subscription)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+	return f.journal.Rewrite(records)
+}
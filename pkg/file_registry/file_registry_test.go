@@ -0,0 +1,108 @@
+package fileregistry
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aceagles/etherum_parser/pkg/eth_observer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_fileRegistry(t *testing.T) {
+	created := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		saves []eth_observer.Subscription
+		want  []eth_observer.Subscription
+	}{
+		{
+			name: "Persist subscriptions",
+			saves: []eth_observer.Subscription{
+				{Address: "0x2", SubscriptionMetadata: eth_observer.SubscriptionMetadata{Label: "cold wallet", Owner: "acme", Tags: []string{"exchange"}}, StartBlock: 5, CreatedAt: created.Add(time.Minute)},
+				{Address: "0x1", StartBlock: 4, CreatedAt: created},
+			},
+			want: []eth_observer.Subscription{
+				{Address: "0x1", StartBlock: 4, CreatedAt: created},
+				{Address: "0x2", SubscriptionMetadata: eth_observer.SubscriptionMetadata{Label: "cold wallet", Owner: "acme", Tags: []string{"exchange"}}, StartBlock: 5, CreatedAt: created.Add(time.Minute)},
+			},
+		},
+		{
+			name: "Same address for two owners",
+			saves: []eth_observer.Subscription{
+				{Address: "0x1", SubscriptionMetadata: eth_observer.SubscriptionMetadata{Owner: "globex"}, CreatedAt: created},
+				{Address: "0x1", SubscriptionMetadata: eth_observer.SubscriptionMetadata{Owner: "acme"}, CreatedAt: created},
+			},
+			want: []eth_observer.Subscription{
+				{Address: "0x1", SubscriptionMetadata: eth_observer.SubscriptionMetadata{Owner: "acme"}, CreatedAt: created},
+				{Address: "0x1", SubscriptionMetadata: eth_observer.SubscriptionMetadata{Owner: "globex"}, CreatedAt: created},
+			},
+		},
+		{
+			name: "Replace subscription",
+			saves: []eth_observer.Subscription{
+				{Address: "0x1", SubscriptionMetadata: eth_observer.SubscriptionMetadata{Label: "old"}, CreatedAt: created},
+				{Address: "0x1", SubscriptionMetadata: eth_observer.SubscriptionMetadata{Label: "new"}, CreatedAt: created},
+			},
+			want: []eth_observer.Subscription{
+				{Address: "0x1", SubscriptionMetadata: eth_observer.SubscriptionMetadata{Label: "new"}, CreatedAt: created},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "subscriptions.json")
+			registry, err := NewFileRegistry(path)
+			assert.NoError(t, err)
+			for _, subscription := range tt.saves {
+				assert.NoError(t, registry.SaveSubscription(subscription))
+			}
+
+			// reopen the registry to check the subscriptions survive a restart
+			reopened, err := NewFileRegistry(path)
+			assert.NoError(t, err)
+			got, err := reopened.LoadSubscriptions()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_NewFileRegistry_malformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscriptions.json")
+	assert.NoError(t, os.WriteFile(path, []byte("{not json\n"), 0o600))
+	_, err := NewFileRegistry(path)
+	assert.Error(t, err)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"address": "0x1"`), 0o600))
+	_, err = NewFileRegistry(path)
+	assert.Error(t, err)
+}
+
+func Test_NewFileRegistry_journal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscriptions.json")
+
+	// a registry written by earlier versions as a JSON array is rewritten as a journal
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"address": "0x1", "label": "old"}, {"address": "0x2"}]`), 0o600))
+	registry, err := NewFileRegistry(path)
+	require.NoError(t, err)
+	assert.NoError(t, registry.SaveSubscription(eth_observer.Subscription{Address: "0x1", SubscriptionMetadata: eth_observer.SubscriptionMetadata{Label: "new"}}))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(data)), "\n"), 3, "saves are appended")
+
+	// a line cut short while it was appended is dropped, and the journal is compacted
+	assert.NoError(t, os.WriteFile(path, append(data, []byte(`{"address": "0x3"`)...), 0o600))
+	registry, err = NewFileRegistry(path)
+	require.NoError(t, err)
+	got, err := registry.LoadSubscriptions()
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, "new", got[0].Label)
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(string(data)), "\n"), 2)
+}
@@ -0,0 +1,136 @@
+// Package keccak implements the Keccak-256 hash used by ethereum. it differs from the standardised
+// SHA3-256 only in its padding, so crypto/sha3 cannot be used in its place
+package keccak
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// Size is the size of a Keccak-256 checksum in bytes
+const Size = 32
+
+// rate is the number of bytes absorbed per permutation for a 256 bit capacity
+const rate = 136
+
+var roundConstants = [24]uint64{
+	0x0000000000000001, 0x0000000000008082, 0x800000000000808a, 0x8000000080008000,
+	0x000000000000808b, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+	0x000000000000008a, 0x0000000000000088, 0x0000000080008009, 0x000000008000000a,
+	0x000000008000808b, 0x800000000000008b, 0x8000000000008089, 0x8000000000008003,
+	0x8000000000008002, 0x8000000000000080, 0x000000000000800a, 0x800000008000000a,
+	0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+}
+
+// rotations are the rho offsets of each lane, indexed by x + 5y
+var rotations = [25]int{
+	0, 1, 62, 28, 27,
+	36, 44, 6, 55, 20,
+	3, 10, 43, 25, 39,
+	41, 45, 15, 21, 8,
+	18, 2, 61, 56, 14,
+}
+
+// permute applies the Keccak-f[1600] permutation to the state
+func permute(a *[25]uint64) {
+	var c [5]uint64
+	var b [25]uint64
+	for round := 0; round < 24; round++ {
+		// theta
+		for x := 0; x < 5; x++ {
+			c[x] = a[x] ^ a[x+5] ^ a[x+10] ^ a[x+15] ^ a[x+20]
+		}
+		for x := 0; x < 5; x++ {
+			d := c[(x+4)%5] ^ bits.RotateLeft64(c[(x+1)%5], 1)
+			for y := 0; y < 25; y += 5 {
+				a[x+y] ^= d
+			}
+		}
+		// rho and pi
+		for x := 0; x < 5; x++ {
+			for y := 0; y < 5; y++ {
+				b[y+5*((2*x+3*y)%5)] = bits.RotateLeft64(a[x+5*y], rotations[x+5*y])
+			}
+		}
+		// chi
+		for y := 0; y < 25; y += 5 {
+			for x := 0; x < 5; x++ {
+				a[x+y] = b[x+y] ^ (^b[(x+1)%5+y] & b[(x+2)%5+y])
+			}
+		}
+		// iota
+		a[0] ^= roundConstants[round]
+	}
+}
+
+// digest is a Keccak-256 hash.Hash
+type digest struct {
+	state  [25]uint64
+	buffer [rate]byte
+	n      int
+}
+
+// New returns a hash.Hash computing the Keccak-256 checksum
+func New() hash.Hash {
+	return &digest{}
+}
+
+// Sum256 returns the Keccak-256 checksum of the data
+func Sum256(data []byte) [Size]byte {
+	var d digest
+	d.Write(data)
+	var sum [Size]byte
+	d.checksum(sum[:0])
+	return sum
+}
+
+func (d *digest) Size() int      { return Size }
+func (d *digest) BlockSize() int { return rate }
+
+func (d *digest) Reset() {
+	*d = digest{}
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		copied := copy(d.buffer[d.n:], p)
+		d.n += copied
+		p = p[copied:]
+		if d.n == rate {
+			d.absorb()
+		}
+	}
+	return written, nil
+}
+
+// Sum appends the checksum to b without changing the state of the hash
+func (d *digest) Sum(b []byte) []byte {
+	clone := *d
+	return clone.checksum(b)
+}
+
+// absorb xors a full buffer into the state and permutes it
+func (d *digest) absorb() {
+	for i := 0; i < rate/8; i++ {
+		d.state[i] ^= binary.LittleEndian.Uint64(d.buffer[i*8:])
+	}
+	permute(&d.state)
+	d.n = 0
+}
+
+// checksum pads the message with the original Keccak padding and squeezes the checksum
+func (d *digest) checksum(b []byte) []byte {
+	clear(d.buffer[d.n:])
+	d.buffer[d.n] ^= 0x01
+	d.buffer[rate-1] ^= 0x80
+	d.n = rate
+	d.absorb()
+
+	var out [Size]byte
+	for i := 0; i < Size/8; i++ {
+		binary.LittleEndian.PutUint64(out[i*8:], d.state[i])
+	}
+	return append(b, out[:]...)
+}
@@ -0,0 +1,54 @@
+package keccak
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSum256(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "Empty", input: "", want: "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{name: "Short", input: "abc", want: "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+		{name: "Function signature", input: "transfer(address,uint256)", want: "a9059cbb2ab09eb219583f4a59a5d0623ade346d962bcd4e46b11da047c9049b"},
+		{name: "Event signature", input: "Transfer(address,address,uint256)", want: "ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sum := Sum256([]byte(tt.input))
+			assert.Equal(t, tt.want, hex.EncodeToString(sum[:]))
+		})
+	}
+}
+
+// sumInParts hashes the input one byte at a time through the hash.Hash interface
+func sumInParts(input string) []byte {
+	h := New()
+	for i := range input {
+		h.Write([]byte{input[i]})
+	}
+	return h.Sum(nil)
+}
+
+func TestNew(t *testing.T) {
+	h := New()
+	h.Write([]byte("transfer(address,"))
+	first := h.Sum(nil)
+	h.Write([]byte("uint256)"))
+	assert.Equal(t, "a9059cbb", hex.EncodeToString(h.Sum(nil)[:4]))
+	assert.NotEqual(t, first, h.Sum(nil), "Sum must not change the state")
+
+	h.Reset()
+	assert.Equal(t, "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470", hex.EncodeToString(h.Sum(nil)))
+	for _, size := range []int{rate - 1, rate, rate + 1, 3*rate + 7} {
+		input := strings.Repeat("x", size)
+		sum := Sum256([]byte(input))
+		assert.Equal(t, sum[:], sumInParts(input), "size %d", size)
+	}
+}
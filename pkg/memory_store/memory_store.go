@@ -1,26 +1,87 @@
 package memorystore
 
 import (
+	"strconv"
+	"sync"
+
 	"github.com/aceagles/etherum_parser/pkg/eth_observer"
 )
 
-// memStore is an in-memory store for transactions
-// it implements the TransactionStore interface
+// memStore is an in-memory store for transactions and withdrawals
+// it implements the TransactionStore and WithdrawalsStore interfaces
 type memStore struct {
+	mux          sync.RWMutex
 	transactions map[string][]eth_observer.Transaction
+	withdrawals  map[string][]eth_observer.Withdrawal
 }
 
 // NewMemStore creates a new memStore
 func NewMemStore() *memStore {
-	return &memStore{transactions: make(map[string][]eth_observer.Transaction)}
+	return &memStore{
+		transactions: make(map[string][]eth_observer.Transaction),
+		withdrawals:  make(map[string][]eth_observer.Withdrawal),
+	}
 }
 
 // AddTransactions adds transactions to the store for a given address
 func (m *memStore) AddTransactions(address string, transactions []eth_observer.Transaction) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
 	m.transactions[address] = append(m.transactions[address], transactions...)
 }
 
 // GetTransactions returns transactions for a given address
+// transactions are only ever appended so the returned slice is safe to read while more are added
 func (m *memStore) GetTransactions(address string) []eth_observer.Transaction {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
 	return m.transactions[address]
 }
+
+// AddWithdrawals adds withdrawals to the store for a given address
+func (m *memStore) AddWithdrawals(address string, withdrawals []eth_observer.Withdrawal) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.withdrawals[address] = append(m.withdrawals[address], withdrawals...)
+}
+
+// GetWithdrawals returns withdrawals for a given address
+// withdrawals are only ever appended so the returned slice is safe to read while more are added
+func (m *memStore) GetWithdrawals(address string) []eth_observer.Withdrawal {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return m.withdrawals[address]
+}
+
+// chainStore is a view of a memStore holding the records of one chain. the records of each chain are kept
+// under their own namespace so that the same address on several chains never shares records
+type chainStore struct {
+	store     *memStore
+	namespace string
+}
+
+// ForChain returns a view of the store for the chain with the given ID, to be used as the
+// TransactionsStore and WithdrawalsStore of the observer of that chain
+func (m *memStore) ForChain(chainId uint64) *chainStore {
+	return &chainStore{store: m, namespace: strconv.FormatUint(chainId, 10) + ":"}
+}
+
+// AddTransactions adds transactions to the store for a given address of the chain
+func (c *chainStore) AddTransactions(address string, transactions []eth_observer.Transaction) {
+	c.store.AddTransactions(c.namespace+address, transactions)
+}
+
+// GetTransactions returns transactions for a given address of the chain
+func (c *chainStore) GetTransactions(address string) []eth_observer.Transaction {
+	return c.store.GetTransactions(c.namespace + address)
+}
+
+// AddWithdrawals adds withdrawals to the store for a given address of the chain
+func (c *chainStore) AddWithdrawals(address string, withdrawals []eth_observer.Withdrawal) {
+	c.store.AddWithdrawals(c.namespace+address, withdrawals)
+}
+
+// GetWithdrawals returns withdrawals for a given address of the chain
+func (c *chainStore) GetWithdrawals(address string) []eth_observer.Withdrawal {
+	return c.store.GetWithdrawals(c.namespace + address)
+}
@@ -60,3 +60,27 @@ func Test_memStores(t *testing.T) {
 		})
 	}
 }
+
+func Test_memStoreWithdrawals(t *testing.T) {
+	m := NewMemStore()
+	m.AddWithdrawals("0x123", []eth_observer.Withdrawal{{Index: "0x1"}})
+	m.AddWithdrawals("0x123", []eth_observer.Withdrawal{{Index: "0x2"}})
+
+	assert.Equal(t, []eth_observer.Withdrawal{{Index: "0x1"}, {Index: "0x2"}}, m.GetWithdrawals("0x123"))
+	assert.Empty(t, m.GetWithdrawals("0x456"))
+	assert.Empty(t, m.GetTransactions("0x123"), "withdrawals are not transactions")
+}
+
+func Test_memStoreForChain(t *testing.T) {
+	m := NewMemStore()
+	mainnet, base := m.ForChain(1), m.ForChain(8453)
+	mainnet.AddTransactions("0x123", []eth_observer.Transaction{{Hash: "0xa"}})
+	base.AddTransactions("0x123", []eth_observer.Transaction{{Hash: "0xb"}})
+	base.AddWithdrawals("0x123", []eth_observer.Withdrawal{{Index: "0x1"}})
+
+	assert.Equal(t, []eth_observer.Transaction{{Hash: "0xa"}}, mainnet.GetTransactions("0x123"))
+	assert.Equal(t, []eth_observer.Transaction{{Hash: "0xb"}}, m.ForChain(8453).GetTransactions("0x123"))
+	assert.Empty(t, mainnet.GetWithdrawals("0x123"))
+	assert.Len(t, base.GetWithdrawals("0x123"), 1)
+	assert.Empty(t, m.GetTransactions("0x123"), "records of a chain are kept under its namespace")
+}
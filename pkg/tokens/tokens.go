@@ -0,0 +1,111 @@
+// Package tokens holds the ERC-20 tokens known to the observer along with the symbol and decimals needed to format their amounts
+package tokens
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aceagles/etherum_parser/pkg/units"
+)
+
+// addressPattern matches a hex encoded 20 byte address in any case
+var addressPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+
+// Token is an ERC-20 token contract
+type Token struct {
+	Address  string `json:"address"`
+	Symbol   string `json:"symbol"`
+	Decimals int    `json:"decimals"`
+}
+
+// Validate checks the address is a 20 byte hex address and the decimals fit the uint8 returned by decimals()
+func (t Token) Validate() error {
+	if !addressPattern.MatchString(t.Address) {
+		return fmt.Errorf("tokens: %q is not a 0x prefixed 20 byte hex address", t.Address)
+	}
+	if t.Decimals < 0 || t.Decimals > 255 {
+		return fmt.Errorf("tokens: %s: decimals must be between 0 and 255", t.Address)
+	}
+	return nil
+}
+
+// Format formats an amount of the token's smallest unit as a decimal amount of the token
+func (t Token) Format(amount *big.Int) string {
+	return units.Format(amount, t.Decimals)
+}
+
+// Registry holds tokens by lowercase contract address. it is safe for concurrent use
+type Registry struct {
+	mux    sync.RWMutex
+	tokens map[string]Token
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{tokens: make(map[string]Token)}
+}
+
+// LoadRegistry loads the tokens of a JSON file holding an array of tokens, e.g.
+// [{"address": "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", "symbol": "USDC", "decimals": 6}]
+func LoadRegistry(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("tokens: %s: %w", path, err)
+	}
+	registry := NewRegistry()
+	for _, token := range tokens {
+		if err := registry.Add(token); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return registry, nil
+}
+
+// Add adds a token, replacing any token at the same address
+func (r *Registry) Add(token Token) error {
+	if err := token.Validate(); err != nil {
+		return err
+	}
+	token.Address = strings.ToLower(token.Address)
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.tokens[token.Address] = token
+	return nil
+}
+
+// Get returns the token at an address and whether it is known
+func (r *Registry) Get(address string) (Token, bool) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	token, ok := r.tokens[strings.ToLower(address)]
+	return token, ok
+}
+
+// Len returns the number of tokens in the registry
+func (r *Registry) Len() int {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return len(r.tokens)
+}
+
+// List returns every token ordered by address
+func (r *Registry) List() []Token {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	tokens := make([]Token, 0, len(r.tokens))
+	for _, token := range r.tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Address < tokens[j].Address })
+	return tokens
+}
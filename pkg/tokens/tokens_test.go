@@ -0,0 +1,45 @@
+package tokens
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRegistry(t *testing.T) {
+	registry, err := LoadRegistry(filepath.Join("testdata", "tokens.json"))
+	require.NoError(t, err)
+
+	usdc, ok := registry.Get("0xA0B86991C6218B36C1D19D4A2E9EB0CE3606EB48")
+	assert.True(t, ok)
+	assert.Equal(t, Token{Address: "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", Symbol: "USDC", Decimals: 6}, usdc)
+	assert.Len(t, registry.List(), 2)
+	assert.Equal(t, "WETH", registry.List()[1].Symbol)
+	_, ok = registry.Get("0xdac17f958d2ee523a2206206994597c13d831ec7")
+	assert.False(t, ok)
+}
+
+func TestLoadRegistry_invalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"not json":     `{`,
+		"bad address":  `[{"address": "0x1", "symbol": "X", "decimals": 6}]`,
+		"bad decimals": `[{"address": "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", "symbol": "X", "decimals": 256}]`,
+	} {
+		path := filepath.Join(t.TempDir(), "tokens.json")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		_, err := LoadRegistry(path)
+		assert.Error(t, err, name)
+	}
+	_, err := LoadRegistry(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestToken_Format(t *testing.T) {
+	usdc := Token{Address: "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48", Symbol: "USDC", Decimals: 6}
+	assert.Equal(t, "1.5", usdc.Format(big.NewInt(1_500_000)))
+	assert.Equal(t, "0.000001", usdc.Format(big.NewInt(1)))
+}
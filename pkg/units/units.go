@@ -0,0 +1,131 @@
+// Package units converts exactly between wei, gwei and ether, and formats amounts of tokens with any number of decimals.
+// amounts are math/big integers of the smallest unit and never pass through floats
+package units
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// decimals of the ether units relative to wei
+const (
+	GweiDecimals  = 9
+	EtherDecimals = 18
+)
+
+var (
+	// Gwei and Ether are the number of wei in one gwei and one ether
+	Gwei  = big.NewInt(1_000_000_000)
+	Ether = big.NewInt(1_000_000_000_000_000_000)
+)
+
+// ParseQuantity parses a non negative integer, either a 0x prefixed hex quantity as returned
+// by the ethereum client or a decimal string
+func ParseQuantity(value string) (*big.Int, error) {
+	base := 10
+	digits := value
+	if strings.HasPrefix(value, "0x") || strings.HasPrefix(value, "0X") {
+		base, digits = 16, value[2:]
+	}
+	quantity, ok := new(big.Int).SetString(digits, base)
+	if !ok || quantity.Sign() < 0 || strings.HasPrefix(digits, "+") || strings.HasPrefix(digits, "-") {
+		return nil, fmt.Errorf("units: %q is not a non negative integer", value)
+	}
+	return quantity, nil
+}
+
+// Format formats an amount of a smallest unit as an exact decimal of the unit with the decimals, without trailing zeros
+func Format(amount *big.Int, decimals int) string {
+	digits := new(big.Int).Abs(amount).String()
+	sign := ""
+	if amount.Sign() < 0 {
+		sign = "-"
+	}
+	if decimals <= 0 {
+		return sign + digits
+	}
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	whole, fraction := digits[:len(digits)-decimals], strings.TrimRight(digits[len(digits)-decimals:], "0")
+	if fraction == "" {
+		return sign + whole
+	}
+	return sign + whole + "." + fraction
+}
+
+// Parse parses a decimal amount of a unit with the decimals into its smallest unit. it fails rather than
+// round when the amount has more fractional digits than the decimals
+func Parse(amount string, decimals int) (*big.Int, error) {
+	sign := ""
+	digits := amount
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	whole, fraction, _ := strings.Cut(digits, ".")
+	if whole == "" && fraction == "" || !isDigits(whole) || !isDigits(fraction) {
+		return nil, fmt.Errorf("units: %q is not a decimal amount", amount)
+	}
+	if len(fraction) > decimals {
+		if strings.TrimRight(fraction[decimals:], "0") != "" {
+			return nil, fmt.Errorf("units: %s has more than %d decimals", amount, decimals)
+		}
+		fraction = fraction[:decimals]
+	}
+	value, _ := new(big.Int).SetString(sign+"0"+whole+fraction+strings.Repeat("0", decimals-len(fraction)), 10)
+	return value, nil
+}
+
+// isDigits reports whether the string only holds decimal digits
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatEther formats an amount of wei in ether
+func FormatEther(wei *big.Int) string {
+	return Format(wei, EtherDecimals)
+}
+
+// FormatGwei formats an amount of wei in gwei
+func FormatGwei(wei *big.Int) string {
+	return Format(wei, GweiDecimals)
+}
+
+// ParseEther parses a decimal amount of ether into wei
+func ParseEther(ether string) (*big.Int, error) {
+	return Parse(ether, EtherDecimals)
+}
+
+// ParseGwei parses a decimal amount of gwei into wei
+func ParseGwei(gwei string) (*big.Int, error) {
+	return Parse(gwei, GweiDecimals)
+}
+
+// GweiToWei converts an amount of gwei to wei
+func GweiToWei(gwei *big.Int) *big.Int {
+	return new(big.Int).Mul(gwei, Gwei)
+}
+
+// HexToEther formats a hex or decimal quantity of wei in ether, returning an empty string for invalid or missing quantities
+func HexToEther(wei string) string {
+	quantity, err := ParseQuantity(wei)
+	if err != nil {
+		return ""
+	}
+	return FormatEther(quantity)
+}
+
+// HexToGwei formats a hex or decimal quantity of wei in gwei, returning an empty string for invalid or missing quantities
+func HexToGwei(wei string) string {
+	quantity, err := ParseQuantity(wei)
+	if err != nil {
+		return ""
+	}
+	return FormatGwei(quantity)
+}
@@ -0,0 +1,110 @@
+package units
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// maxUint256 is the largest amount a contract can hold
+const maxUint256 = "115792089237316195423570985008687907853269984665640564039457584007913129639935"
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "0x16345785d8a0000", want: "100000000000000000"},
+		{value: "0X0", want: "0"},
+		{value: "1000", want: "1000"},
+		{value: "0x" + "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", want: maxUint256},
+		{value: "", wantErr: true},
+		{value: "0x", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "+1", wantErr: true},
+		{value: "0x-1", wantErr: true},
+		{value: "1.5", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseQuantity(tt.value)
+		if tt.wantErr {
+			assert.Error(t, err, tt.value)
+			continue
+		}
+		require.NoError(t, err, tt.value)
+		assert.Equal(t, tt.want, got.String(), tt.value)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		amount   string
+		decimals int
+		want     string
+	}{
+		{amount: "0", decimals: 6, want: "0"},
+		{amount: "1500000", decimals: 6, want: "1.5"},
+		{amount: "1", decimals: 18, want: "0.000000000000000001"},
+		{amount: "123", decimals: 0, want: "123"},
+		{amount: "-2500", decimals: 3, want: "-2.5"},
+		{amount: maxUint256, decimals: 18, want: "115792089237316195423570985008687907853269984665640564039457.584007913129639935"},
+	}
+	for _, tt := range tests {
+		amount, _ := new(big.Int).SetString(tt.amount, 10)
+		assert.Equal(t, tt.want, Format(amount, tt.decimals), tt.amount)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		amount   string
+		decimals int
+		want     string
+		wantErr  bool
+	}{
+		{amount: "1.5", decimals: 6, want: "1500000"},
+		{amount: "0.000000000000000001", decimals: 18, want: "1"},
+		{amount: ".25", decimals: 2, want: "25"},
+		{amount: "7.", decimals: 2, want: "700"},
+		{amount: "-2.5", decimals: 3, want: "-2500"},
+		{amount: "1.500", decimals: 1, want: "15"},
+		{amount: "42", decimals: 0, want: "42"},
+		{amount: "1.05", decimals: 1, wantErr: true},
+		{amount: "", decimals: 18, wantErr: true},
+		{amount: ".", decimals: 18, wantErr: true},
+		{amount: "1e18", decimals: 18, wantErr: true},
+		{amount: "0x10", decimals: 18, wantErr: true},
+		{amount: "1.2.3", decimals: 18, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.amount, tt.decimals)
+		if tt.wantErr {
+			assert.Error(t, err, tt.amount)
+			continue
+		}
+		require.NoError(t, err, tt.amount)
+		assert.Equal(t, tt.want, got.String(), tt.amount)
+	}
+}
+
+func TestEtherAndGwei(t *testing.T) {
+	wei, err := ParseEther("0.1")
+	require.NoError(t, err)
+	assert.Equal(t, "100000000000000000", wei.String())
+	assert.Equal(t, "0.1", FormatEther(wei))
+	assert.Equal(t, "100000000", FormatGwei(wei))
+
+	wei, err = ParseGwei("1.5")
+	require.NoError(t, err)
+	assert.Equal(t, "1500000000", wei.String())
+	assert.Equal(t, "0.0000000015", FormatEther(wei))
+	assert.Equal(t, "3000000000", GweiToWei(big.NewInt(3)).String())
+
+	assert.Equal(t, "0.1", HexToEther("0x16345785d8a0000"))
+	assert.Equal(t, "20.5", HexToGwei("0x4c5e52d00"))
+	assert.Equal(t, "", HexToEther(""))
+	assert.Equal(t, "", HexToGwei("0xzz"))
+}
@@ -0,0 +1,266 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aceagles/etherum_parser/pkg/eth_observer"
+)
+
+// Headers sent with every delivery. the signature is the hex encoded HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the subscription's webhook secret, prefixed with "v1="
+const (
+	HeaderID        = "X-Webhook-ID"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Payload is the body POSTed to a webhook for a matched transaction
+type Payload struct {
+	ID          string                   `json:"id"`
+	Type        string                   `json:"type"`
+	Address     string                   `json:"address"`
+	Transaction eth_observer.Transaction `json:"transaction"`
+	// ChainId is the ID of the chain the transaction was matched on, once checked by the observer's VerifyChain
+	ChainId uint64 `json:"chainId,omitempty"`
+}
+
+// Sign returns the signature header value for a body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher POSTs matched transactions to the webhooks of the subscriptions watching their address.
+// deliveries go through the durable outbox: failed attempts are retried with exponential backoff and
+// deliveries which fail MaxAttempts times are moved to the dead-letter list
+type Dispatcher struct {
+	observer *eth_observer.EthereumObserver
+	outbox   *Outbox
+	client   *http.Client
+	queue    chan []Delivery
+	wake     chan struct{}
+	now      func() time.Time
+
+	// MaxAttempts is the number of attempts before a delivery is moved to the dead-letter list
+	MaxAttempts int
+	// BaseBackoff is the delay before the first retry, it doubles with every failed attempt up to MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// AllowPrivateNetworks lets webhooks reach loopback, link-local and private addresses, for receivers on an
+	// internal network. it is off by default so tenants cannot make the observer call internal services
+	AllowPrivateNetworks bool
+}
+
+// queueSize is the number of notifications buffered for the outbox before Notify writes to it directly
+const queueSize = 1024
+
+// NewDispatcher creates a new Dispatcher for the subscriptions of the observer
+func NewDispatcher(observer *eth_observer.EthereumObserver, outbox *Outbox) *Dispatcher {
+	d := &Dispatcher{
+		observer:    observer,
+		outbox:      outbox,
+		queue:       make(chan []Delivery, queueSize),
+		wake:        make(chan struct{}, 1),
+		now:         time.Now,
+		MaxAttempts: 8,
+		BaseBackoff: 5 * time.Second,
+		MaxBackoff:  time.Hour,
+	}
+	d.client = d.newClient()
+	return d
+}
+
+// CheckURL checks a webhook URL may be delivered to by the dispatcher, see CheckURL
+func (d *Dispatcher) CheckURL(ctx context.Context, rawURL string) error {
+	return CheckURL(ctx, rawURL, d.AllowPrivateNetworks)
+}
+
+// Outbox returns the outbox of the dispatcher
+func (d *Dispatcher) Outbox() *Outbox {
+	return d.outbox
+}
+
+// Notify queues a delivery of each transaction to the webhook of every subscription watching the address
+// which can see it. it is called with the transactions stored for an address, on the observer's polling
+// goroutine, so the deliveries are handed to Run which writes them to the outbox in batches. once the queue
+// is full they are written to the outbox directly
+func (d *Dispatcher) Notify(address string, transactions []eth_observer.Transaction) {
+	var deliveries []Delivery
+	for _, subscription := range d.observer.ListSubscriptions(eth_observer.SubscriptionFilter{Address: address}) {
+		if subscription.WebhookURL == "" {
+			continue
+		}
+		tenant := d.observer.Tenant(subscription.Owner)
+		for _, transaction := range transactions {
+			transaction, ok := tenant.Notification(address, transaction)
+			if !ok {
+				continue
+			}
+			delivery, err := d.newDelivery(subscription, transaction)
+			if err != nil {
+				slog.Error("Failed to build webhook delivery", "address", address, "error", err)
+				continue
+			}
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+	select {
+	case d.queue <- deliveries:
+	default:
+		if err := d.outbox.Enqueue(deliveries...); err != nil {
+			slog.Error("Failed to queue webhook deliveries", "address", address, "error", err)
+			return
+		}
+	}
+	d.Wake()
+}
+
+// flush writes the deliveries queued by Notify to the outbox with a single write
+func (d *Dispatcher) flush() {
+	var deliveries []Delivery
+drain:
+	for {
+		select {
+		case queued := <-d.queue:
+			deliveries = append(deliveries, queued...)
+		default:
+			break drain
+		}
+	}
+	if len(deliveries) == 0 {
+		return
+	}
+	if err := d.outbox.Enqueue(deliveries...); err != nil {
+		slog.Error("Failed to queue webhook deliveries", "deliveries", len(deliveries), "error", err)
+	}
+}
+
+// newDelivery builds the delivery of a transaction to the webhook of a subscription
+func (d *Dispatcher) newDelivery(subscription eth_observer.Subscription, transaction eth_observer.Transaction) (Delivery, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Delivery{}, err
+	}
+	payload := Payload{ID: hex.EncodeToString(id), Type: "transaction", Address: subscription.Address, Transaction: transaction, ChainId: d.observer.ChainId()}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return Delivery{}, err
+	}
+	now := d.now()
+	return Delivery{
+		ID:          payload.ID,
+		Owner:       subscription.Owner,
+		Address:     subscription.Address,
+		URL:         subscription.WebhookURL,
+		Secret:      subscription.WebhookSecret,
+		Payload:     body,
+		NextAttempt: now,
+		CreatedAt:   now,
+	}, nil
+}
+
+// Wake makes the dispatcher attempt the due deliveries now rather than on its next tick
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due deliveries until the context is cancelled, checking the outbox every second
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		d.DeliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// DeliverDue writes the queued notifications to the outbox then attempts every due delivery once,
+// rescheduling or killing the ones that fail
+func (d *Dispatcher) DeliverDue(ctx context.Context) {
+	d.flush()
+	for _, delivery := range d.outbox.Due(d.now()) {
+		if ctx.Err() != nil {
+			return
+		}
+		err := d.deliver(ctx, delivery)
+		if err == nil {
+			if err := d.outbox.Delivered(delivery.ID); err != nil {
+				slog.Error("Failed to remove delivered webhook from the outbox", "id", delivery.ID, "error", err)
+			}
+			continue
+		}
+
+		delivery.Attempts++
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= d.MaxAttempts {
+			slog.Warn("Webhook delivery moved to the dead-letter list", "id", delivery.ID, "url", delivery.URL, "error", err)
+			err = d.outbox.Kill(delivery)
+		} else {
+			delivery.NextAttempt = d.now().Add(d.backoff(delivery.Attempts))
+			slog.Debug("Webhook delivery failed", "id", delivery.ID, "attempts", delivery.Attempts, "error", delivery.LastError)
+			err = d.outbox.Retry(delivery)
+		}
+		if err != nil {
+			slog.Error("Failed to update webhook delivery", "id", delivery.ID, "error", err)
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt after the given number of failed attempts
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.BaseBackoff
+	for i := 1; i < attempts && delay < d.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, d.MaxBackoff)
+}
+
+// deliver POSTs a delivery to its webhook. any status other than 2xx is a failure
+func (d *Dispatcher) deliver(ctx context.Context, delivery Delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	timestamp := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, delivery.ID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
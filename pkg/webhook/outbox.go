@@ -0,0 +1,330 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	atomicfile "github.com/aceagles/etherum_parser/pkg/atomic_file"
+)
+
+// Delivery is a webhook POST waiting in the outbox or parked in the dead-letter list
+type Delivery struct {
+	ID          string          `json:"id"`
+	Owner       string          `json:"owner"`
+	Address     string          `json:"address"`
+	URL         string          `json:"url"`
+	Secret      string          `json:"secret,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+	LastError   string          `json:"lastError,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt"`
+}
+
+// ErrNotFound is returned when a delivery is not in the outbox
+var ErrNotFound = errors.New("delivery not found")
+
+// outboxFile is the content of the outbox file written by earlier versions, which is converted to a journal
+type outboxFile struct {
+	Pending []Delivery `json:"pending"`
+	Dead    []Delivery `json:"dead"`
+}
+
+// operations recorded in the outbox journal
+const (
+	opPending   = "pending"   // the delivery is queued, replacing any earlier state of it
+	opDead      = "dead"      // the delivery is moved to the dead-letter list
+	opDelivered = "delivered" // the delivery is removed from the outbox
+	opPurged    = "purged"    // the dead delivery is removed from the dead-letter list
+)
+
+// record is a line of the outbox journal. Delivery is set for pending and dead records and ID for the others
+type record struct {
+	Op       string    `json:"op"`
+	Delivery *Delivery `json:"delivery,omitempty"`
+	ID       string    `json:"id,omitempty"`
+}
+
+// DefaultMaxDeadLetters is the number of dead deliveries kept by an outbox unless set otherwise
+const DefaultMaxDeadLetters = 10000
+
+// Outbox is a durable queue of webhook deliveries backed by a journal file. every change is appended to
+// the journal before it returns so deliveries survive a restart of the observer, and the journal is
+// compacted when it is opened and once it holds many more records than deliveries
+type Outbox struct {
+	path    string
+	mux     sync.Mutex
+	pending map[string]Delivery
+	dead    map[string]Delivery
+	journal *atomicfile.Journal
+	records int // records in the journal
+
+	// MaxDeadLetters is the number of dead deliveries kept, the oldest are dropped once it is exceeded
+	MaxDeadLetters int
+}
+
+// NewOutbox creates a new Outbox persisting to the file at path
+// the file is read and compacted if it exists, otherwise it is created on the first change
+func NewOutbox(path string) (*Outbox, error) {
+	o := &Outbox{
+		path:           path,
+		pending:        make(map[string]Delivery),
+		dead:           make(map[string]Delivery),
+		journal:        atomicfile.NewJournal(path),
+		MaxDeadLetters: DefaultMaxDeadLetters,
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := o.read(data); err != nil {
+		return nil, err
+	}
+	if err := o.compact(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// read loads the deliveries of the journal, or of an outbox file written by earlier versions
+func (o *Outbox) read(data []byte) error {
+	if first, _, _ := bytes.Cut(bytes.TrimSpace(data), []byte("\n")); bytes.Equal(bytes.TrimSpace(first), []byte("{")) {
+		var file outboxFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return err
+		}
+		for _, delivery := range file.Pending {
+			o.pending[delivery.ID] = delivery
+		}
+		for _, delivery := range file.Dead {
+			o.dead[delivery.ID] = delivery
+		}
+		return nil
+	}
+
+	lines, torn := atomicfile.SplitLines(data)
+	for i, line := range lines {
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("%s: line %d: %w", o.path, i+1, err)
+		}
+		if err := o.apply(rec); err != nil {
+			return fmt.Errorf("%s: line %d: %w", o.path, i+1, err)
+		}
+	}
+	if torn {
+		slog.Warn("Dropping the incomplete last line of the webhook outbox", "path", o.path)
+	}
+	return nil
+}
+
+// apply changes the deliveries held in memory as a record says
+func (o *Outbox) apply(rec record) error {
+	switch rec.Op {
+	case opPending, opDead:
+		if rec.Delivery == nil {
+			return fmt.Errorf("%s record without a delivery", rec.Op)
+		}
+		delete(o.pending, rec.Delivery.ID)
+		delete(o.dead, rec.Delivery.ID)
+		if rec.Op == opPending {
+			o.pending[rec.Delivery.ID] = *rec.Delivery
+		} else {
+			o.dead[rec.Delivery.ID] = *rec.Delivery
+		}
+	case opDelivered:
+		delete(o.pending, rec.ID)
+	case opPurged:
+		delete(o.dead, rec.ID)
+	default:
+		return fmt.Errorf("unknown operation %q", rec.Op)
+	}
+	return nil
+}
+
+// commit appends records to the journal then applies them, compacting the journal once it holds more than twice as
+// many records as deliveries. the caller must hold o.mux
+func (o *Outbox) commit(records ...record) error {
+	lines := make([][]byte, 0, len(records))
+	for _, rec := range records {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, line)
+	}
+	if err := o.journal.Append(lines...); err != nil {
+		return err
+	}
+	o.records += len(records)
+	for _, rec := range records {
+		if err := o.apply(rec); err != nil {
+			return err
+		}
+	}
+	if live := len(o.pending) + len(o.dead); o.records > 2*live+1024 {
+		if err := o.compact(); err != nil {
+			// the journal still holds every change, it is compacted on the next attempt
+			slog.Error("Failed to compact the webhook outbox", "path", o.path, "error", err)
+		}
+	}
+	return nil
+}
+
+// compact rewrites the journal with a record per delivery. the caller must hold o.mux
+func (o *Outbox) compact() error {
+	var lines [][]byte
+	for _, list := range []struct {
+		op         string
+		deliveries map[string]Delivery
+	}{{opDead, o.dead}, {opPending, o.pending}} {
+		for _, delivery := range sorted(list.deliveries) {
+			line, err := json.Marshal(record{Op: list.op, Delivery: &delivery})
+			if err != nil {
+				return err
+			}
+			lines = append(lines, line)
+		}
+	}
+	if err := o.journal.Rewrite(lines); err != nil {
+		return err
+	}
+	o.records = len(lines)
+	return nil
+}
+
+// Enqueue adds deliveries to the outbox
+func (o *Outbox) Enqueue(deliveries ...Delivery) error {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	records := make([]record, 0, len(deliveries))
+	for i := range deliveries {
+		records = append(records, record{Op: opPending, Delivery: &deliveries[i]})
+	}
+	return o.commit(records...)
+}
+
+// Due returns the pending deliveries whose next attempt is at or before now, oldest first
+func (o *Outbox) Due(now time.Time) []Delivery {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	var due []Delivery
+	for _, delivery := range o.pending {
+		if !delivery.NextAttempt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sortDeliveries(due)
+	return due
+}
+
+// Pending returns every pending delivery oldest first
+func (o *Outbox) Pending() []Delivery {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	return sorted(o.pending)
+}
+
+// Delivered removes a delivery from the outbox
+func (o *Outbox) Delivered(id string) error {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	return o.commit(record{Op: opDelivered, ID: id})
+}
+
+// Retry records a failed attempt and schedules the next one
+func (o *Outbox) Retry(delivery Delivery) error {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	return o.commit(record{Op: opPending, Delivery: &delivery})
+}
+
+// Kill moves a delivery which keeps failing to the dead-letter list, dropping the oldest dead deliveries
+// past MaxDeadLetters
+func (o *Outbox) Kill(delivery Delivery) error {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	records := []record{{Op: opDead, Delivery: &delivery}}
+	if excess := len(o.dead) + 1 - o.MaxDeadLetters; o.MaxDeadLetters > 0 && excess > 0 {
+		for _, dead := range sorted(o.dead)[:excess] {
+			records = append(records, record{Op: opPurged, ID: dead.ID})
+		}
+	}
+	return o.commit(records...)
+}
+
+// Purge removes a dead delivery from the dead-letter list and returns it. only deliveries of the owner are purged
+// unless owner is empty
+func (o *Outbox) Purge(owner, id string) (Delivery, error) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	delivery, ok := o.dead[id]
+	if !ok || (owner != "" && delivery.Owner != owner) {
+		return Delivery{}, ErrNotFound
+	}
+	if err := o.commit(record{Op: opPurged, ID: id}); err != nil {
+		return Delivery{}, err
+	}
+	return delivery, nil
+}
+
+// DeadLetters returns the dead deliveries of an owner, or of every owner if owner is empty, oldest first
+func (o *Outbox) DeadLetters(owner string) []Delivery {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	deliveries := []Delivery{}
+	for _, delivery := range sorted(o.dead) {
+		if owner == "" || delivery.Owner == owner {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries
+}
+
+// Replay moves a dead delivery back to the outbox with its attempts reset so it is retried immediately
+// only deliveries of the owner are replayed unless owner is empty
+func (o *Outbox) Replay(owner, id string, now time.Time) (Delivery, error) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	delivery, ok := o.dead[id]
+	if !ok || (owner != "" && delivery.Owner != owner) {
+		return Delivery{}, ErrNotFound
+	}
+	delivery.Attempts = 0
+	delivery.NextAttempt = now
+	delivery.LastError = ""
+	if err := o.commit(record{Op: opPending, Delivery: &delivery}); err != nil {
+		return Delivery{}, err
+	}
+	return delivery, nil
+}
+
+// sorted returns the deliveries of a map oldest first
+func sorted(deliveries map[string]Delivery) []Delivery {
+	list := make([]Delivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		list = append(list, delivery)
+	}
+	sortDeliveries(list)
+	return list
+}
+
+// sortDeliveries orders deliveries by creation time then ID
+func sortDeliveries(deliveries []Delivery) {
+	sort.Slice(deliveries, func(i, j int) bool {
+		if !deliveries[i].CreatedAt.Equal(deliveries[j].CreatedAt) {
+			return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+		}
+		return deliveries[i].ID < deliveries[j].ID
+	})
+}
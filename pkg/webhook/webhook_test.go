@@ -0,0 +1,280 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aceagles/etherum_parser/pkg/eth_observer"
+	memorystore "github.com/aceagles/etherum_parser/pkg/memory_store"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAddress = "0x00000000000000000000000000000000000000aa"
+
+// newTestDispatcher returns a dispatcher for an observer where acme is subscribed to testAddress
+// with a webhook to url and globex is subscribed without a webhook. it delivers to local test servers
+func newTestDispatcher(t *testing.T, url string) *Dispatcher {
+	observer := eth_observer.NewEthereumObserver("", memorystore.NewMemStore())
+	observer.Tenant("acme").AddSubscription(eth_observer.Subscription{Address: testAddress, WebhookURL: url, WebhookSecret: "s3cret"})
+	observer.Tenant("globex").Subscribe(testAddress)
+	outbox, err := NewOutbox(filepath.Join(t.TempDir(), "outbox.json"))
+	assert.NoError(t, err)
+	d := NewDispatcher(observer, outbox)
+	d.AllowPrivateNetworks = true
+	return d
+}
+
+func TestSign(t *testing.T) {
+	assert.Equal(t, Sign("key", 1700000000, []byte(`{}`)), Sign("key", 1700000000, []byte(`{}`)))
+	assert.NotEqual(t, Sign("key", 1700000000, []byte(`{}`)), Sign("key", 1700000001, []byte(`{}`)))
+	assert.NotEqual(t, Sign("key", 1700000000, []byte(`{}`)), Sign("other", 1700000000, []byte(`{}`)))
+	assert.Len(t, Sign("key", 1, nil), len("v1=")+64)
+}
+
+func TestDispatcher_deliver(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer ts.Close()
+
+	d := newTestDispatcher(t, ts.URL)
+	d.Notify(testAddress, []eth_observer.Transaction{{Hash: "0x1", BlockNumber: "0x1"}})
+	assert.Empty(t, d.Outbox().Pending(), "notifications are queued until the dispatcher runs")
+	d.flush()
+	assert.Len(t, d.Outbox().Pending(), 1, "only subscriptions with a webhook are notified")
+	d.DeliverDue(context.Background())
+
+	req, body := <-received, <-bodies
+	timestamp, err := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	assert.NoError(t, err)
+	assert.Equal(t, Sign("s3cret", timestamp, body), req.Header.Get(HeaderSignature))
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+
+	var payload Payload
+	assert.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, req.Header.Get(HeaderID), payload.ID)
+	assert.Equal(t, "transaction", payload.Type)
+	assert.Equal(t, "0x1", payload.Transaction.Hash)
+	assert.Equal(t, "acme", payload.Transaction.Subscription.Owner)
+	assert.Empty(t, d.Outbox().Pending())
+}
+
+func TestDispatcher_rules(t *testing.T) {
+	d := newTestDispatcher(t, "http://127.0.0.1/hook")
+	d.observer.Tenant("acme").AddSubscription(eth_observer.Subscription{
+		Address:    "0x00000000000000000000000000000000000000bb",
+		WebhookURL: "http://127.0.0.1/hook",
+		Rules:      []eth_observer.Rule{{Name: "outgoing", Direction: eth_observer.DirectionOut}},
+	})
+	d.Notify("0x00000000000000000000000000000000000000bb", []eth_observer.Transaction{
+		{Hash: "0x1", From: testAddress, To: "0x00000000000000000000000000000000000000bb"},
+		{Hash: "0x2", From: "0x00000000000000000000000000000000000000bb", To: testAddress},
+	})
+	d.flush()
+	pending := d.Outbox().Pending()
+	assert.Len(t, pending, 1, "only matches firing a rule are delivered")
+	var transaction eth_observer.Transaction
+	assert.NoError(t, json.Unmarshal(pending[0].Payload, &struct {
+		Transaction *eth_observer.Transaction `json:"transaction"`
+	}{&transaction}))
+	assert.Equal(t, "0x2", transaction.Hash)
+	assert.Equal(t, []string{"outgoing"}, transaction.Rules)
+}
+
+func TestDispatcher_retryAndDeadLetter(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+
+	d := newTestDispatcher(t, ts.URL)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	d.MaxAttempts = 3
+	d.BaseBackoff = time.Second
+	d.Notify(testAddress, []eth_observer.Transaction{{Hash: "0x1", BlockNumber: "0x1"}})
+
+	d.DeliverDue(context.Background())
+	pending := d.Outbox().Pending()
+	assert.Len(t, pending, 1)
+	assert.Equal(t, 1, pending[0].Attempts)
+	assert.Equal(t, now.Add(time.Second), pending[0].NextAttempt)
+	assert.Contains(t, pending[0].LastError, "502")
+
+	// not due yet
+	d.DeliverDue(context.Background())
+	assert.Equal(t, int32(1), calls.Load())
+
+	now = now.Add(time.Second)
+	d.DeliverDue(context.Background())
+	assert.Equal(t, now.Add(2*time.Second), d.Outbox().Pending()[0].NextAttempt)
+	now = now.Add(2 * time.Second)
+	d.DeliverDue(context.Background())
+	assert.Empty(t, d.Outbox().Pending())
+	dead := d.Outbox().DeadLetters("acme")
+	assert.Len(t, dead, 1)
+	assert.Empty(t, d.Outbox().DeadLetters("globex"))
+
+	// the outbox survives a restart
+	reopened, err := NewOutbox(d.Outbox().path)
+	assert.NoError(t, err)
+	assert.Len(t, reopened.DeadLetters(""), 1)
+
+	// replaying delivers the dead letter again
+	_, err = d.Outbox().Replay("globex", dead[0].ID, now)
+	assert.ErrorIs(t, err, ErrNotFound)
+	failing.Store(false)
+	replayed, err := d.Outbox().Replay("acme", dead[0].ID, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, replayed.Attempts)
+	d.DeliverDue(context.Background())
+	assert.Empty(t, d.Outbox().Pending())
+	assert.Empty(t, d.Outbox().DeadLetters(""))
+	assert.Equal(t, int32(4), calls.Load())
+}
+
+func TestOutbox_journal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.json")
+	now := time.Unix(1700000000, 0).UTC()
+	delivery := func(id string, age int) Delivery {
+		return Delivery{ID: id, Owner: "acme", Payload: json.RawMessage(`{}`), CreatedAt: now.Add(time.Duration(age) * time.Second)}
+	}
+
+	// an outbox written by earlier versions is converted to a journal
+	legacy, err := json.MarshalIndent(outboxFile{Pending: []Delivery{delivery("p1", 0)}, Dead: []Delivery{delivery("d1", 1)}}, "", "  ")
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(path, legacy, 0o600))
+	outbox, err := NewOutbox(path)
+	assert.NoError(t, err)
+	assert.Len(t, outbox.Pending(), 1)
+	assert.Len(t, outbox.DeadLetters(""), 1)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, bytes.Count(data, []byte("\n")))
+
+	// changes are appended, a torn last line is dropped and the journal is compacted when it is opened
+	assert.NoError(t, outbox.Enqueue(delivery("p2", 2), delivery("p3", 3)))
+	assert.NoError(t, outbox.Delivered("p1"))
+	assert.NoError(t, outbox.Kill(delivery("p2", 2)))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	assert.NoError(t, err)
+	_, err = file.WriteString(`{"op":"delivered","id":"p`)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	reopened, err := NewOutbox(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []Delivery{delivery("p3", 3)}, reopened.Pending())
+	assert.Equal(t, []Delivery{delivery("d1", 1), delivery("p2", 2)}, reopened.DeadLetters(""))
+	data, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, bytes.Count(data, []byte("\n")))
+
+	// dead letters are purged by their owner
+	_, err = reopened.Purge("globex", "d1")
+	assert.ErrorIs(t, err, ErrNotFound)
+	purged, err := reopened.Purge("acme", "d1")
+	assert.NoError(t, err)
+	assert.Equal(t, "d1", purged.ID)
+	_, err = reopened.Purge("", "d1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// the oldest dead letters are dropped past the limit
+	reopened.MaxDeadLetters = 2
+	assert.NoError(t, reopened.Kill(delivery("d2", 4)))
+	assert.NoError(t, reopened.Kill(delivery("d3", 5)))
+	assert.Equal(t, []Delivery{delivery("d2", 4), delivery("d3", 5)}, reopened.DeadLetters(""))
+
+	// the journal is compacted once it holds many more records than deliveries
+	for i := 0; i < 2000; i++ {
+		assert.NoError(t, reopened.Retry(delivery("p3", 3)))
+	}
+	data, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Less(t, bytes.Count(data, []byte("\n")), 1100)
+	reopened, err = NewOutbox(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []Delivery{delivery("p3", 3)}, reopened.Pending())
+	assert.Len(t, reopened.DeadLetters(""), 2)
+}
+
+func TestDispatcher_backoff(t *testing.T) {
+	d := &Dispatcher{BaseBackoff: 5 * time.Second, MaxBackoff: time.Minute}
+	assert.Equal(t, 5*time.Second, d.backoff(1))
+	assert.Equal(t, 10*time.Second, d.backoff(2))
+	assert.Equal(t, 40*time.Second, d.backoff(4))
+	assert.Equal(t, time.Minute, d.backoff(5))
+	assert.Equal(t, time.Minute, d.backoff(50))
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		url          string
+		allowPrivate bool
+		wantErr      error
+	}{
+		{url: "https://93.184.215.14/hook"},
+		{url: "http://[2606:4700::1111]:8080/hook"},
+		{url: "http://127.0.0.1/hook", wantErr: ErrForbiddenAddress},
+		{url: "http://localhost:8080/hook", wantErr: ErrForbiddenAddress},
+		{url: "http://169.254.169.254/latest/meta-data", wantErr: ErrForbiddenAddress},
+		{url: "http://10.0.0.5/hook", wantErr: ErrForbiddenAddress},
+		{url: "http://192.168.1.1/hook", wantErr: ErrForbiddenAddress},
+		{url: "http://100.64.0.1/hook", wantErr: ErrForbiddenAddress},
+		{url: "http://0.0.0.0/hook", wantErr: ErrForbiddenAddress},
+		{url: "http://[::1]/hook", wantErr: ErrForbiddenAddress},
+		{url: "http://[fd00::1]/hook", wantErr: ErrForbiddenAddress},
+		{url: "http://127.0.0.1/hook", allowPrivate: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := CheckURL(context.Background(), tt.url, tt.allowPrivate)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+	assert.Error(t, CheckURL(context.Background(), "ftp://example.com", true))
+	assert.Error(t, CheckURL(context.Background(), "/hook", true))
+}
+
+func TestDispatcher_refusesPrivateAddresses(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer ts.Close()
+
+	// a webhook registered with a public host may later resolve to an internal address
+	d := newTestDispatcher(t, ts.URL)
+	d.AllowPrivateNetworks = false
+	d.MaxAttempts = 1
+	d.Notify(testAddress, []eth_observer.Transaction{{Hash: "0x1", BlockNumber: "0x1"}})
+	d.DeliverDue(context.Background())
+
+	assert.Zero(t, calls.Load())
+	dead := d.Outbox().DeadLetters("")
+	assert.Len(t, dead, 1)
+	assert.Contains(t, dead[0].LastError, ErrForbiddenAddress.Error())
+}
@@ -0,0 +1,426 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// acceptGUID is appended to the client key to compute Sec-WebSocket-Accept, see RFC 6455 section 1.3
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// DefaultMaxMessageSize is the largest message accepted from the peer unless changed on the Conn
+const DefaultMaxMessageSize = 1 << 20
+
+// Opcodes of the frames defined by RFC 6455
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// Close codes used by the package and its callers
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseTryAgainLater   = 1013
+)
+
+// ErrProtocol is returned when the peer violates the protocol
+var ErrProtocol = errors.New("websocket: protocol error")
+
+// CloseError is returned by ReadMessage once the peer closed the connection
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with code %d %s", e.Code, e.Reason)
+}
+
+// Conn is a websocket connection. ReadMessage must only be called from one goroutine at a time,
+// the write methods are safe to call concurrently
+type Conn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	isClient bool
+
+	writeMux sync.Mutex
+	closed   bool
+
+	// MaxMessageSize is the largest message accepted from the peer
+	MaxMessageSize int64
+	// PingHandler is called with the payload of every ping received from the peer before it is answered
+	PingHandler func(payload []byte)
+	// PongHandler is called with the payload of every pong received from the peer
+	PongHandler func(payload []byte)
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, isClient bool) *Conn {
+	return &Conn{conn: conn, reader: reader, isClient: isClient, MaxMessageSize: DefaultMaxMessageSize}
+}
+
+// acceptKey computes the Sec-WebSocket-Accept value for a Sec-WebSocket-Key
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerContains reports whether the comma separated header contains the token, ignoring case
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Protocols returns the subprotocols offered by the client in the Sec-WebSocket-Protocol headers of a request
+func Protocols(header http.Header) []string {
+	var protocols []string
+	for _, value := range header.Values("Sec-WebSocket-Protocol") {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				protocols = append(protocols, part)
+			}
+		}
+	}
+	return protocols
+}
+
+// selectProtocol returns the first subprotocol offered by the client which the server speaks, or an empty string
+func selectProtocol(header http.Header, protocols []string) string {
+	for _, offered := range Protocols(header) {
+		if slices.Contains(protocols, offered) {
+			return offered
+		}
+	}
+	return ""
+}
+
+// Upgrade upgrades an HTTP request to a websocket connection. the first of the subprotocols offered by the client in
+// Sec-WebSocket-Protocol which is among the protocols given is selected and returned to it. on failure an error
+// response has been written to w and the error is returned
+func Upgrade(w http.ResponseWriter, r *http.Request, protocols ...string) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "websocket: method must be GET", http.StatusMethodNotAllowed)
+		return nil, errors.New("websocket: method must be GET")
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket: not a websocket handshake", http.StatusBadRequest)
+		return nil, errors.New("websocket: not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "websocket: unsupported version", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "websocket: invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("websocket: invalid Sec-WebSocket-Key")
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "websocket: connection cannot be hijacked", http.StatusInternalServerError)
+		return nil, err
+	}
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n"
+	if protocol := selectProtocol(r.Header, protocols); protocol != "" {
+		response += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+	}
+	response += "\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newConn(conn, rw.Reader, false), nil
+}
+
+// Dial opens a client websocket connection to a ws:// or wss:// url
+func Dial(rawURL string, header http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		conn, err = net.Dial("tcp", hostPort(u, "80"))
+	case "wss":
+		conn, err = tls.Dial("tcp", hostPort(u, "443"), &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := &http.Request{Method: http.MethodGet, URL: u, Host: u.Host, Header: http.Header{}}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, resp, fmt.Errorf("websocket: handshake failed with status %d", resp.StatusCode)
+	}
+	return newConn(conn, reader, true), resp, nil
+}
+
+// hostPort returns the host and port of the url, using the default port when the url has none
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+// SetReadDeadline sets the deadline for reading the next message
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for writing messages
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// ReadMessage returns the next text or binary message. fragmented messages are reassembled,
+// pings are answered with pongs and a close frame is answered and returned as a *CloseError
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	var (
+		opcode  int
+		message []byte
+	)
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case OpPing:
+			if c.PingHandler != nil {
+				c.PingHandler(payload)
+			}
+			if err := c.WriteControl(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			if c.PongHandler != nil {
+				c.PongHandler(payload)
+			}
+			continue
+		case OpClose:
+			closeErr := &CloseError{Code: 1005}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Reason = string(payload[2:])
+			}
+			_ = c.Close(CloseNormal, "")
+			return 0, nil, closeErr
+		case OpText, OpBinary:
+			if opcode != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "new message before the previous one finished")
+			}
+			opcode = op
+		case OpContinuation:
+			if opcode == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "continuation without a message")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+		if int64(len(message)+len(payload)) > c.MaxMessageSize {
+			return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+		}
+		message = append(message, payload...)
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+// readFrame reads a single frame and unmasks its payload
+func (c *Conn) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	opcode := int(header[0] & 0x0f)
+	masked := header[1]&0x80 != 0
+	if masked == c.isClient {
+		// clients must mask their frames and servers must not, RFC 6455 section 5.1
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid masking")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= OpClose && (length > 125 || !fin) {
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+	if length > uint64(c.MaxMessageSize) {
+		return false, 0, nil, c.fail(CloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+// fail closes the connection with the code and returns ErrProtocol wrapped with the reason
+func (c *Conn) fail(code int, reason string) error {
+	_ = c.Close(code, reason)
+	return fmt.Errorf("%w: %s", ErrProtocol, reason)
+}
+
+// WriteMessage writes a text or binary message as a single frame
+func (c *Conn) WriteMessage(opcode int, data []byte) error {
+	return c.writeFrame(opcode, data)
+}
+
+// WriteControl writes a ping or pong frame
+func (c *Conn) WriteControl(opcode int, payload []byte) error {
+	if len(payload) > 125 {
+		return errors.New("websocket: control frame payload too long")
+	}
+	return c.writeFrame(opcode, payload)
+}
+
+// writeFrame writes a final frame, masking it when the connection is a client
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|byte(opcode))
+	maskBit := byte(0)
+	if c.isClient {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	if c.isClient {
+		var mask [4]byte
+		_, _ = rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame with the code and reason and closes the underlying connection
+func (c *Conn) Close(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > 125 {
+		payload = payload[:125]
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	err := c.writeFrame(OpClose, payload)
+
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if closeErr := c.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
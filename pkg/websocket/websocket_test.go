@@ -0,0 +1,170 @@
+package websocket
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newEchoServer returns the ws:// url of a server echoing every message it receives
+func newEchoServer(t *testing.T, maxMessageSize int64) string {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		if maxMessageSize > 0 {
+			conn.MaxMessageSize = maxMessageSize
+		}
+		for {
+			opcode, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(opcode, message); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+// writeRawFrame writes a masked client frame with the fin bit and opcode given
+func writeRawFrame(t *testing.T, c *Conn, fin bool, opcode int, payload []byte) {
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first, 0x80 | byte(len(payload)), 1, 2, 3, 4}
+	for i, b := range payload {
+		frame = append(frame, b^[]byte{1, 2, 3, 4}[i%4])
+	}
+	_, err := c.conn.Write(frame)
+	assert.NoError(t, err)
+}
+
+func Test_acceptKey(t *testing.T) {
+	// example from RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestConn_echo(t *testing.T) {
+	url := newEchoServer(t, 0)
+	conn, _, err := Dial(url, nil)
+	assert.NoError(t, err)
+	defer conn.Close(CloseNormal, "")
+
+	for _, message := range []string{"hello", strings.Repeat("a", 200), strings.Repeat("b", 70000)} {
+		assert.NoError(t, conn.WriteMessage(OpText, []byte(message)))
+		opcode, got, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, OpText, opcode)
+		assert.Equal(t, message, string(got))
+	}
+}
+
+func TestConn_fragmentedMessage(t *testing.T) {
+	conn, _, err := Dial(newEchoServer(t, 0), nil)
+	assert.NoError(t, err)
+	defer conn.Close(CloseNormal, "")
+
+	writeRawFrame(t, conn, false, OpText, []byte("hel"))
+	// control frames may be interleaved with fragments
+	writeRawFrame(t, conn, true, OpPing, []byte("p"))
+	writeRawFrame(t, conn, true, OpContinuation, []byte("lo"))
+
+	pongs := make(chan string, 1)
+	conn.PongHandler = func(payload []byte) { pongs <- string(payload) }
+	_, got, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+	assert.Equal(t, "p", <-pongs)
+}
+
+func TestConn_close(t *testing.T) {
+	conn, _, err := Dial(newEchoServer(t, 0), nil)
+	assert.NoError(t, err)
+	writeRawFrame(t, conn, true, OpClose, []byte{0x03, 0xe8})
+
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	assert.True(t, errors.As(err, &closeErr))
+	assert.Equal(t, CloseNormal, closeErr.Code)
+}
+
+func TestConn_messageTooBig(t *testing.T) {
+	conn, _, err := Dial(newEchoServer(t, 10), nil)
+	assert.NoError(t, err)
+	assert.NoError(t, conn.WriteMessage(OpText, []byte(strings.Repeat("a", 11))))
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	assert.True(t, errors.As(err, &closeErr))
+	assert.Equal(t, CloseMessageTooBig, closeErr.Code)
+}
+
+func TestUpgrade_rejectsInvalidHandshake(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = Upgrade(w, r)
+	}))
+	defer ts.Close()
+	tests := []struct {
+		name       string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{name: "Plain request", wantStatus: http.StatusBadRequest},
+		{name: "Wrong version", headers: map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8"}, wantStatus: http.StatusUpgradeRequired},
+		{name: "Invalid key", headers: map[string]string{"Connection": "keep-alive, Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "abc"}, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestUpgrade_protocol(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := Upgrade(w, r, "chat", "superchat"); err == nil {
+			conn.Close(CloseNormal, "")
+		}
+	}))
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+	for offered, want := range map[string]string{"": "", "other": "", "other, superchat, chat": "superchat"} {
+		conn, resp, err := Dial(url, http.Header{"Sec-Websocket-Protocol": {offered}})
+		assert.NoError(t, err)
+		assert.Equal(t, want, resp.Header.Get("Sec-WebSocket-Protocol"), offered)
+		conn.Close(CloseNormal, "")
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, Protocols(http.Header{"Sec-Websocket-Protocol": {"a, b", " c,"}}))
+}
+
+func Test_hostPort(t *testing.T) {
+	for rawURL, want := range map[string]string{
+		"ws://node.example":       "node.example:80",
+		"wss://node.example/rpc":  "node.example:443",
+		"wss://node.example:8546": "node.example:8546",
+		"ws://[::1]/rpc":          "[::1]:80",
+	} {
+		u, err := url.Parse(rawURL)
+		assert.NoError(t, err)
+		assert.Equal(t, want, hostPort(u, map[string]string{"ws": "80", "wss": "443"}[u.Scheme]), rawURL)
+	}
+}